@@ -22,16 +22,37 @@ func NewCache() *Cache {
 }
 
 func (c *Cache) Get(filename string) (content []byte, mimetype string, err error) {
+	content, mimetype, _, err = c.GetWithModTime(filename)
+	return content, mimetype, err
+}
+
+// GetWithModTime is like Get, but also returns the modification time
+// of the file, in nanoseconds.
+func (c *Cache) GetWithModTime(filename string) (content []byte, mimetype string, mtime int64, err error) {
+	content, mtime, err = c.file(filename).GetWithModTime()
+	if err == nil {
+		mimetype = mime.TypeByExtension(path.Ext(filename))
+	}
+	return content, mimetype, mtime, err
+}
+
+// Stat is like GetWithModTime, but returns the size of the file
+// instead of its contents, which it does not read.
+func (c *Cache) Stat(filename string) (size int64, mimetype string, mtime int64, err error) {
+	size, mtime, err = c.file(filename).Stat()
+	if err == nil {
+		mimetype = mime.TypeByExtension(path.Ext(filename))
+	}
+	return size, mimetype, mtime, err
+}
+
+func (c *Cache) file(filename string) *CachedFile {
 	c.Lock()
+	defer c.Unlock()
 	f, ok := c.files[filename]
 	if !ok {
 		f = NewCachedFile(filename)
 		c.files[filename] = f
 	}
-	c.Unlock()
-	content, err = f.Get()
-	if err == nil {
-		mimetype = mime.TypeByExtension(path.Ext(filename))
-	}
-	return content, mimetype, err
+	return f
 }
@@ -0,0 +1,101 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cache-test-")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	return dir
+}
+
+var statTests = []struct {
+	name     string
+	size     int64
+	mimetype string
+	err      error
+	errOther bool // Any error but ErrIsDir is expected, rather than err
+}{
+	{"a.txt", 5, "text/plain; charset=utf-8", nil, false},
+	{"sub", 0, "", ErrIsDir, false},
+	{"missing.txt", 0, "", nil, true},
+}
+
+func TestCacheStat(t *testing.T) {
+	dir := tempTree(t)
+	defer os.RemoveAll(dir)
+
+	c := NewCache()
+	for _, tt := range statTests {
+		full := filepath.Join(dir, tt.name)
+		size, mimetype, mtime, err := c.Stat(full)
+		switch {
+		case tt.errOther:
+			if err == nil || err == ErrIsDir {
+				t.Errorf("%s: got error %v, want a stat error", tt.name, err)
+			}
+			continue
+		case err != tt.err:
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		case err != nil:
+			continue
+		}
+		if size != tt.size || mimetype != tt.mimetype {
+			t.Errorf("%s: got size %d, type %q, want %d, %q", tt.name, size, mimetype, tt.size, tt.mimetype)
+		}
+		fi, _ := os.Stat(full)
+		if mtime != fi.ModTime().UnixNano() {
+			t.Errorf("%s: got mtime %d, want %d", tt.name, mtime, fi.ModTime().UnixNano())
+		}
+	}
+}
+
+func TestCacheStatAgreesWithGet(t *testing.T) {
+	dir := tempTree(t)
+	defer os.RemoveAll(dir)
+	full := filepath.Join(dir, "a.txt")
+
+	c := NewCache()
+	content, _, mtime, err := c.GetWithModTime(full)
+	if err != nil {
+		t.Fatalf("GetWithModTime: %s", err)
+	}
+	size, _, smtime, err := c.Stat(full)
+	if err != nil || size != int64(len(content)) || smtime != mtime {
+		t.Errorf("Stat after Get: got %d, %d, %v, want %d, %d", size, smtime, err, len(content), mtime)
+	}
+
+	// A newer file is reported as it is on disk, before it is read again
+	if err = ioutil.WriteFile(full, []byte("hello, world"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	later := time.Unix(0, mtime).Add(2 * time.Second)
+	if err = os.Chtimes(full, later, later); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	size, _, smtime, err = c.Stat(full)
+	if err != nil || size != 12 || smtime != later.UnixNano() {
+		t.Errorf("Stat after change: got %d, %d, %v, want 12, %d", size, smtime, err, later.UnixNano())
+	}
+	if _, _, err = c.Get(filepath.Join(dir, "sub")); err == nil {
+		t.Errorf("Get of a directory: got no error")
+	}
+}
@@ -5,11 +5,15 @@
 package cache
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"sync"
 )
 
+// ErrIsDir is returned by Stat for directories, whose contents cannot be read.
+var ErrIsDir = errors.New("cache: file is a directory")
+
 // CachedFile is responsible for returning the contents of a single file.
 // It remembers the contents in memory, and updates it as necessary.
 type CachedFile struct {
@@ -24,6 +28,13 @@ func NewCachedFile(filename string) *CachedFile {
 }
 
 func (c *CachedFile) Get() (data []byte, err error) {
+	data, _, err = c.GetWithModTime()
+	return data, err
+}
+
+// GetWithModTime is like Get, but also returns the modification time
+// of the file, in nanoseconds, as of when its contents were read.
+func (c *CachedFile) GetWithModTime() (data []byte, mtime int64, err error) {
 	c.Lock()
 	defer c.Unlock()
 
@@ -32,25 +43,45 @@ func (c *CachedFile) Get() (data []byte, err error) {
 	}
 	fi, err := os.Stat(c.fname)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if fi.ModTime().UnixNano() > c.mtime {
 		return c.readFile()
 	}
-	return c.data, nil
+	return c.data, c.mtime, nil
+}
+
+// Stat returns the size and modification time of the file, in nanoseconds,
+// without reading its contents. They agree with GetWithModTime when the
+// file is unchanged.
+func (c *CachedFile) Stat() (size int64, mtime int64, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	fi, err := os.Stat(c.fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.IsDir() {
+		return 0, 0, ErrIsDir
+	}
+	if c.data != nil && fi.ModTime().UnixNano() <= c.mtime {
+		return int64(len(c.data)), c.mtime, nil
+	}
+	return fi.Size(), fi.ModTime().UnixNano(), nil
 }
 
-func (c *CachedFile) readFile() (data []byte, err error) {
+func (c *CachedFile) readFile() (data []byte, mtime int64, err error) {
 	fi, err := os.Stat(c.fname)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	data, err = ioutil.ReadFile(c.fname)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	c.data = data
 	c.mtime = fi.ModTime().UnixNano()
 
-	return data, nil
+	return data, c.mtime, nil
 }
@@ -0,0 +1,235 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// An AcceptSpec is one element of an Accept, Accept-Encoding or
+// Accept-Language header: a media range, a content coding or a language
+// range, together with its quality.
+type AcceptSpec struct {
+	Value   string            // Such as "text/html", "text/*", "gzip" or "en-us", in lower case
+	Params  map[string]string // Media type parameters, other than q, or nil
+	Quality float64           // Between 0 and 1; 1 unless given by a valid q parameter
+}
+
+// ParseAccept parses the Accept header of h. The media ranges are returned
+// in descending order of preference: by quality, then with the more
+// specific range first, "text/html;level=1" before "text/html" before
+// "text/*" before "*/*", and otherwise in the order in which they were listed.
+func ParseAccept(h Header) []AcceptSpec {
+	return parseAcceptHeader(h, "Accept", true, mediaSpecificity)
+}
+
+// ParseAcceptEncoding parses the Accept-Encoding header of h, ordering the
+// codings as ParseAccept does, with "*" after all other codings.
+func ParseAcceptEncoding(h Header) []AcceptSpec {
+	return parseAcceptHeader(h, "Accept-Encoding", false, wildcardSpecificity)
+}
+
+// ParseAcceptLanguage parses the Accept-Language header of h, ordering the
+// language ranges as ParseAccept does, with longer ranges such as "en-us"
+// before shorter ones such as "en", and "*" last.
+func ParseAcceptLanguage(h Header) []AcceptSpec {
+	return parseAcceptHeader(h, "Accept-Language", false, languageSpecificity)
+}
+
+func parseAcceptHeader(h Header, key string, params bool, specificity func(*AcceptSpec) int) []AcceptSpec {
+	var specs []AcceptSpec
+	for _, line := range h.Values(key) {
+		for _, elem := range strings.Split(line, ",") {
+			parts := strings.Split(elem, ";")
+			spec := AcceptSpec{Value: strings.ToLower(strings.TrimSpace(parts[0])), Quality: 1}
+			if spec.Value == "" {
+				continue
+			}
+			for _, p := range parts[1:] {
+				k, v := strings.TrimSpace(p), ""
+				if i := strings.Index(k, "="); i >= 0 {
+					k, v = strings.TrimSpace(k[:i]), strings.TrimSpace(k[i+1:])
+				}
+				k = strings.ToLower(k)
+				if k == "q" {
+					spec.Quality = parseQuality(v)
+					break // Accept extensions follow
+				}
+				if params && k != "" {
+					if spec.Params == nil {
+						spec.Params = make(map[string]string)
+					}
+					if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+						v = v[1 : len(v)-1]
+					}
+					spec.Params[k] = v
+				}
+			}
+			specs = append(specs, spec)
+		}
+	}
+	// Insertion sort, which keeps listed order among equals
+	for i := 1; i < len(specs); i++ {
+		for j := i; j > 0 && acceptLess(&specs[j], &specs[j-1], specificity); j-- {
+			specs[j], specs[j-1] = specs[j-1], specs[j]
+		}
+	}
+	return specs
+}
+
+// parseQuality parses a q-value, clamping it to [0, 1]. Malformed q-values
+// count as 1.
+func parseQuality(v string) float64 {
+	q, err := strconv.Atof64(v)
+	switch {
+	case err != nil || q != q:
+		return 1
+	case q < 0:
+		return 0
+	case q > 1:
+		return 1
+	}
+	return q
+}
+
+// acceptLess reports whether a is preferred to b.
+func acceptLess(a, b *AcceptSpec, specificity func(*AcceptSpec) int) bool {
+	if a.Quality != b.Quality {
+		return a.Quality > b.Quality
+	}
+	return specificity(a) > specificity(b)
+}
+
+func mediaSpecificity(s *AcceptSpec) int {
+	switch {
+	case s.Value == "*/*" || s.Value == "*":
+		return 0
+	case strings.HasSuffix(s.Value, "/*"):
+		return 1
+	case len(s.Params) > 0:
+		return 3
+	}
+	return 2
+}
+
+func wildcardSpecificity(s *AcceptSpec) int {
+	if s.Value == "*" {
+		return 0
+	}
+	return 1
+}
+
+func languageSpecificity(s *AcceptSpec) int {
+	if s.Value == "*" {
+		return 0
+	}
+	return 1 + strings.Count(s.Value, "-")
+}
+
+func mediaMatch(s *AcceptSpec, offer string) bool {
+	o := AcceptSpec{Value: offer}
+	if strings.Index(offer, ";") >= 0 {
+		o = parseAcceptHeader(Header{"Accept": {offer}}, "Accept", true, mediaSpecificity)[0]
+	}
+	switch {
+	case s.Value == "*/*" || s.Value == "*":
+	case strings.HasSuffix(s.Value, "/*"):
+		if !strings.HasPrefix(o.Value, s.Value[:len(s.Value)-1]) {
+			return false
+		}
+	case s.Value != o.Value:
+		return false
+	}
+	for k, v := range s.Params {
+		if o.Params[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func encodingMatch(s *AcceptSpec, offer string) bool {
+	return s.Value == "*" || s.Value == offer
+}
+
+func languageMatch(s *AcceptSpec, offer string) bool {
+	return s.Value == "*" || s.Value == offer || strings.HasPrefix(offer, s.Value+"-")
+}
+
+// negotiate returns the offer that specs prefer, or def if they admit none.
+// Each offer takes the quality of the most specific range that matches it,
+// as RFC 2616 has it. Among offers of equal quality, the one matched by
+// the more specific range wins, then the one matched by the range listed
+// first, then the one offered first. The implicit quality, if positive,
+// applies to offers that no range matches, and loses all ties.
+func negotiate(specs []AcceptSpec, offered []string, def string, implicit func(offer string) float64,
+	match func(*AcceptSpec, string) bool, specificity func(*AcceptSpec) int) string {
+
+	best, bestQ, bestSpec, bestPos := def, 0.0, -2, 0
+	for _, offer := range offered {
+		o := strings.ToLower(offer)
+		q, spec, pos := 0.0, -1, len(specs)
+		if implicit != nil {
+			q = implicit(o)
+		}
+		for i := range specs {
+			s := &specs[i]
+			if match(s, o) && specificity(s) > spec {
+				q, spec, pos = s.Quality, specificity(s), i
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || q == bestQ && (spec > bestSpec || spec == bestSpec && pos < bestPos) {
+			best, bestQ, bestSpec, bestPos = offer, q, spec, pos
+		}
+	}
+	return best
+}
+
+// NegotiateContentType returns the media type among offered, such as
+// "application/json" or "text/html", that the Accept header of h prefers.
+// It returns def if there is no Accept header, or if it admits none of
+// the offered types.
+func NegotiateContentType(h Header, offered []string, def string) string {
+	specs := ParseAccept(h)
+	if len(specs) == 0 {
+		return def
+	}
+	return negotiate(specs, offered, def, nil, mediaMatch, mediaSpecificity)
+}
+
+// NegotiateContentEncoding returns the content coding among offered, such
+// as "gzip", that the Accept-Encoding header of h prefers. The "identity"
+// coding is acceptable unless the header refuses it. NegotiateContentEncoding
+// returns def if there is no Accept-Encoding header, or if it admits none of
+// the offered codings.
+func NegotiateContentEncoding(h Header, offered []string, def string) string {
+	specs := ParseAcceptEncoding(h)
+	if len(specs) == 0 {
+		return def
+	}
+	identity := func(offer string) float64 {
+		if offer == "identity" {
+			return 1
+		}
+		return 0
+	}
+	return negotiate(specs, offered, def, identity, encodingMatch, wildcardSpecificity)
+}
+
+// NegotiateLanguage returns the language tag among offered, such as "en-US",
+// that the Accept-Language header of h prefers. A language range, such as
+// "en", matches the tags it is a prefix of. NegotiateLanguage returns def if
+// there is no Accept-Language header, or if it admits none of the offered tags.
+func NegotiateLanguage(h Header, offered []string, def string) string {
+	specs := ParseAcceptLanguage(h)
+	if len(specs) == 0 {
+		return def
+	}
+	return negotiate(specs, offered, def, nil, languageMatch, languageSpecificity)
+}
@@ -0,0 +1,122 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"reflect"
+	"testing"
+)
+
+var parseAcceptTests = []struct {
+	accept string
+	specs  []AcceptSpec
+}{
+	{"", nil},
+	{"Text/HTML", []AcceptSpec{{"text/html", nil, 1}}},
+	{
+		"*/*, text/*, text/html, text/html;level=1",
+		[]AcceptSpec{
+			{"text/html", map[string]string{"level": "1"}, 1},
+			{"text/html", nil, 1},
+			{"text/*", nil, 1},
+			{"*/*", nil, 1},
+		},
+	},
+	{
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		[]AcceptSpec{
+			{"text/html", nil, 1},
+			{"application/xhtml+xml", nil, 1},
+			{"application/xml", nil, 0.9},
+			{"*/*", nil, 0.8},
+		},
+	},
+	{
+		`a/b;q=2, c/d;q=-1, e/f;q=x, g/h; charset="utf-8" ;q=0.5;ext=1,,`,
+		[]AcceptSpec{
+			{"a/b", nil, 1},
+			{"e/f", nil, 1},
+			{"g/h", map[string]string{"charset": "utf-8"}, 0.5},
+			{"c/d", nil, 0},
+		},
+	},
+}
+
+func TestParseAccept(t *testing.T) {
+	for i, tt := range parseAcceptTests {
+		h := Header{}
+		if tt.accept != "" {
+			h.Set("Accept", tt.accept)
+		}
+		if specs := ParseAccept(h); !reflect.DeepEqual(specs, tt.specs) {
+			t.Errorf("#%d: got %v, want %v", i, specs, tt.specs)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	h := Header{"Accept-Language": {"*;q=0.5, en", "en-US, de;q=0.7"}}
+	want := []AcceptSpec{
+		{"en-us", nil, 1},
+		{"en", nil, 1},
+		{"de", nil, 0.7},
+		{"*", nil, 0.5},
+	}
+	if specs := ParseAcceptLanguage(h); !reflect.DeepEqual(specs, want) {
+		t.Errorf("got %v, want %v", specs, want)
+	}
+}
+
+var negotiateTests = []struct {
+	key, value string
+	offered    []string
+	def, want  string
+}{
+	{"Accept", "", []string{"text/html"}, "def", "def"},
+	{"Accept", "text/html", nil, "def", "def"},
+	{"Accept", "*/*", []string{"application/json", "text/html"}, "def", "application/json"},
+	{"Accept", "application/json;q=0.5, text/html", []string{"application/json", "text/html"}, "def", "text/html"},
+	{"Accept", "text/*;q=0.5, text/plain;q=0", []string{"text/plain", "text/html"}, "def", "text/html"},
+	{"Accept", "image/png", []string{"application/json", "text/html"}, "def", "def"},
+	{"Accept", "text/*, text/html", []string{"text/plain", "text/html"}, "def", "text/html"},
+	{"Accept", "application/xml, application/json", []string{"application/json", "application/xml"}, "def", "application/xml"},
+	{"Accept", "text/html;level=1;q=0.2, text/html", []string{"text/html;level=1", "text/html"}, "def", "text/html"},
+	{"Accept", "TEXT/HTML", []string{"Text/Html"}, "def", "Text/Html"},
+
+	{"Accept-Encoding", "gzip", []string{"gzip"}, "identity", "gzip"},
+	{"Accept-Encoding", "gzip;q=0, identity", []string{"gzip"}, "identity", "identity"},
+	{"Accept-Encoding", "gzip;q=0.000", []string{"gzip", "identity"}, "none", "identity"},
+	{"Accept-Encoding", "*", []string{"gzip"}, "identity", "gzip"},
+	{"Accept-Encoding", "deflate, *;q=0", []string{"gzip", "identity"}, "none", "none"},
+	{"Accept-Encoding", "deflate;q=0.5, gzip", []string{"deflate", "gzip"}, "identity", "gzip"},
+	{"Accept-Encoding", "gzip;q=0.5", []string{"identity", "gzip"}, "none", "identity"},
+
+	{"Accept-Language", "en", []string{"de", "en-US"}, "def", "en-US"},
+	{"Accept-Language", "en-gb, en;q=0.8, *;q=0.1", []string{"de", "en-US", "en-GB"}, "def", "en-GB"},
+	{"Accept-Language", "en-gb, en;q=0.8, *;q=0.1", []string{"de", "en-US"}, "def", "en-US"},
+	{"Accept-Language", "fr, *;q=0", []string{"de", "en"}, "def", "def"},
+	{"Accept-Language", "e", []string{"en"}, "def", "def"},
+}
+
+func TestNegotiate(t *testing.T) {
+	for i, tt := range negotiateTests {
+		h := Header{}
+		if tt.value != "" {
+			h.Set(tt.key, tt.value)
+		}
+		var got string
+		switch tt.key {
+		case "Accept":
+			got = NegotiateContentType(h, tt.offered, tt.def)
+		case "Accept-Encoding":
+			got = NegotiateContentEncoding(h, tt.offered, tt.def)
+		case "Accept-Language":
+			got = NegotiateLanguage(h, tt.offered, tt.def)
+		}
+		if got != tt.want {
+			t.Errorf("#%d: %s: %q: got %q, want %q", i, tt.key, tt.value, got, tt.want)
+		}
+	}
+}
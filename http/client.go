@@ -7,9 +7,11 @@
 package http
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"url"
@@ -21,8 +23,6 @@ import (
 // The Client's Transport typically has internal state (cached
 // TCP connections), so Clients should be reused instead of created as
 // needed. Clients are safe for concurrent use by multiple goroutines.
-//
-// Client is not yet very configurable.
 type Client struct {
 	Transport RoundTripper // if nil, DefaultTransport is used
 
@@ -33,8 +33,35 @@ type Client struct {
 	// returns that error instead of issue the Request req.
 	//
 	// If CheckRedirect is nil, the Client uses its default policy,
-	// which is to stop after 10 consecutive requests.
+	// which is to stop after MaxRedirects consecutive requests.
+	//
+	// The upcoming request carries the headers of the original one,
+	// except for Authorization and Cookie if the host changes.
+	// CheckRedirect may modify them.
 	CheckRedirect func(req *Request, via []*Request) os.Error
+
+	// MaxRedirects is the number of redirects after which the default
+	// policy stops, 10 if zero. If negative, redirect responses are
+	// returned to the caller instead of being followed.
+	MaxRedirects int
+
+	// If Jar is not nil, the client adds its cookies to every request,
+	// including those following redirects, and stores in it the cookies
+	// set by every response. Cookies without a Domain attribute are bound
+	// to the host that set them, and cookies are exchanged only with
+	// hosts that their domain matches.
+	Jar CookieJar
+}
+
+// A CookieJar stores cookies for a Client.
+//
+// A CookieJar must be safe for concurrent use by multiple goroutines.
+type CookieJar interface {
+	// SetCookies stores cookies received in response to a request to u.
+	SetCookies(u *url.URL, cookies []*Cookie)
+
+	// Cookies returns the cookies to send in a request to u.
+	Cookies(u *url.URL) []*Cookie
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -83,12 +110,15 @@ type readClose struct {
 // (typically Transport) may not be able to re-use a persistent TCP
 // connection to the server for a subsequent "keep-alive" request.
 //
+// Redirects are followed as described for Get. A 303 (See Other) turns the
+// request into a GET without a body, as do a 301 and a 302 for a POST;
+// otherwise the method and body are kept, the body being sent again if it
+// is at most 1MB long. The Set-Cookie headers of the redirect responses
+// are carried over to the final response, ahead of its own.
+//
 // Generally Get, Post, or PostForm will be used instead of Do.
 func (c *Client) Do(req *Request) (resp *Response, err os.Error) {
-	if req.Method == "GET" || req.Method == "HEAD" {
-		return c.doFollowingRedirects(req)
-	}
-	return send(req, c.Transport)
+	return c.doFollowingRedirects(req)
 }
 
 // send issues an HTTP request.  Caller should close resp.Body when done reading from it.
@@ -145,7 +175,9 @@ func Get(url string) (r *Response, err os.Error) {
 
 // Get issues a GET to the specified URL.  If the response is one of the
 // following redirect codes, Get follows the redirect after calling the
-// Client's CheckRedirect function.
+// Client's CheckRedirect function, resolving a relative Location against
+// the URL of the request. Requesting a URL again with the same method and
+// cookies is reported as a redirect loop.
 //
 //    301 (Moved Permanently)
 //    302 (Found)
@@ -162,26 +194,66 @@ func (c *Client) Get(url string) (r *Response, err os.Error) {
 }
 
 func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err os.Error) {
-	// TODO: if/when we add cookie support, the redirected request shouldn't
-	// necessarily supply the same cookies as the original.
 	var base *url.URL
 	redirectChecker := c.CheckRedirect
 	if redirectChecker == nil {
-		redirectChecker = defaultCheckRedirect
+		redirectChecker = c.defaultCheckRedirect
 	}
 	var via []*Request
+	var setCookies []string          // Set-Cookie lines of the redirect responses
+	visited := make(map[string]bool) // Method, URL and cookies of the requests made
+	var rbody *replayBody
 
+	// The body is recorded only if redirects are followed, and dropped as
+	// soon as a redirect does not send it again
+	sendBody := ireq.Body != nil
+	record := sendBody && c.MaxRedirects >= 0
 	req := ireq
+	if record || c.Jar != nil {
+		// Work on a copy, leaving the caller's request alone
+		req = new(Request)
+		*req = *ireq
+		if record {
+			rbody = &replayBody{r: ireq.Body}
+			req.Body = rbody
+		}
+		if c.Jar != nil {
+			req.Header = ireq.Header.Clone()
+			if req.Header == nil {
+				req.Header = make(Header)
+			}
+		}
+	}
+	method := ireq.Method
+	if method == "" {
+		method = "GET"
+	}
 	urlStr := "" // next relative or absolute URL to fetch (after first request)
 	for redirect := 0; ; redirect++ {
 		if redirect != 0 {
 			req = new(Request)
-			req.Method = ireq.Method
-			req.Header = make(Header)
+			req.Method = method
+			req.Timeout, req.Cancel = ireq.Timeout, ireq.Cancel
+			req.Header = ireq.Header.Clone()
+			if req.Header == nil {
+				req.Header = make(Header)
+			}
 			req.URL, err = base.Parse(urlStr)
 			if err != nil {
 				break
 			}
+			if req.URL.Host != ireq.URL.Host {
+				req.Header.Del("Authorization")
+				req.Header.Del("Cookie")
+			}
+			if sendBody {
+				if req.Body, err = rbody.replay(); err != nil {
+					break
+				}
+				req.ContentLength = int64(len(rbody.buf.Bytes()))
+			} else if ireq.Body != nil {
+				req.Header.Del("Content-Type")
+			}
 			if len(via) > 0 {
 				// Add the Referer header.
 				lastReq := via[len(via)-1]
@@ -195,36 +267,152 @@ func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err os.Error)
 				}
 			}
 		}
+		if c.Jar != nil {
+			host := hostname(req.URL)
+			for _, ck := range c.Jar.Cookies(req.URL) {
+				if ck.MatchesHost(host) {
+					req.AddCookie(&Cookie{Name: ck.Name, Value: ck.Value})
+				}
+			}
+		}
 
+		// Sending the same request again can only lead to the same redirect,
+		// unless cookies set on the way have changed it
+		key := req.Method + " " + req.URL.String() + " " + strings.Join(req.Header["Cookie"], "; ")
+		if visited[key] {
+			err = os.NewError("redirect loop detected")
+			break
+		}
+		visited[key] = true
 		urlStr = req.URL.String()
 		if r, err = send(req, c.Transport); err != nil {
 			break
 		}
-		if shouldRedirect(r.StatusCode) {
+		if c.Jar != nil {
+			if cookies := jarCookies(req.URL, r.Cookies()); len(cookies) > 0 {
+				c.Jar.SetCookies(req.URL, cookies)
+			}
+		}
+		if shouldRedirect(r.StatusCode) && c.MaxRedirects >= 0 {
 			r.Body.Close()
-			if urlStr = r.Header.Get("Location"); urlStr == "" {
+			loc := r.Header.Get("Location")
+			if loc == "" {
 				err = os.NewError(fmt.Sprintf("%d response missing Location header", r.StatusCode))
 				break
 			}
+			urlStr = loc
 			base = req.URL
 			via = append(via, req)
+			var keepBody bool
+			method, keepBody = redirectMethod(r.StatusCode, req.Method)
+			if !keepBody {
+				sendBody, rbody = false, nil
+			}
+			setCookies = append(setCookies, r.Header.Values("Set-Cookie")...)
 			continue
 		}
+		if len(setCookies) > 0 {
+			r.Header["Set-Cookie"] = append(setCookies, r.Header.Values("Set-Cookie")...)
+		}
 		return
 	}
 
-	method := ireq.Method
 	err = &url.Error{method[0:1] + strings.ToLower(method[1:]), urlStr, err}
 	return
 }
 
-func defaultCheckRedirect(req *Request, via []*Request) os.Error {
-	if len(via) >= 10 {
-		return os.NewError("stopped after 10 redirects")
+func (c *Client) defaultCheckRedirect(req *Request, via []*Request) os.Error {
+	max := c.MaxRedirects
+	if max == 0 {
+		max = 10
+	}
+	if len(via) >= max {
+		return os.NewError(fmt.Sprintf("stopped after %d redirects", max))
 	}
 	return nil
 }
 
+// redirectMethod returns the method with which to follow a redirect with
+// the given status code from a request with the given method, and whether
+// the request body is to be sent again. Like browsers do, a POST turns into
+// a GET on a 301 or 302.
+func redirectMethod(statusCode int, method string) (string, bool) {
+	switch {
+	case statusCode == StatusTemporaryRedirect:
+		return method, true
+	case statusCode == StatusSeeOther && method != "HEAD",
+		method == "POST":
+		return "GET", false
+	}
+	return method, true
+}
+
+// maxReplayBody is the size up to which request bodies are kept, so that
+// they can be sent again when following a redirect.
+const maxReplayBody = 1 << 20
+
+// replayBody records a request body as it is sent.
+type replayBody struct {
+	r    io.ReadCloser
+	buf  bytes.Buffer
+	eof  bool // Whether r has been read to the end
+	over bool // Whether the body exceeded maxReplayBody
+}
+
+func (b *replayBody) Read(p []byte) (n int, err os.Error) {
+	n, err = b.r.Read(p)
+	if !b.over {
+		if b.buf.Len()+n > maxReplayBody {
+			b.over = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == os.EOF {
+		b.eof = true
+	}
+	return
+}
+
+func (b *replayBody) Close() os.Error { return b.r.Close() }
+
+// replay returns a reader for the recorded body.
+func (b *replayBody) replay() (io.ReadCloser, os.Error) {
+	if !b.eof || b.over {
+		return nil, os.NewError("request body cannot be sent again")
+	}
+	return ioutil.NopCloser(bytes.NewBuffer(b.buf.Bytes())), nil
+}
+
+// hostname returns the host of u without any port.
+func hostname(u *url.URL) string {
+	host := u.Host
+	if hasPort(host) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// jarCookies prepares cookies received in response to a request to u for
+// storage in a CookieJar. Cookies without a Domain attribute are bound to the
+// host of u, and cookies for domains that u does not belong to are dropped.
+func jarCookies(u *url.URL, cookies []*Cookie) []*Cookie {
+	host := hostname(u)
+	var kept []*Cookie
+	for _, c := range cookies {
+		if c.Domain == "" {
+			cc := *c
+			cc.Domain, cc.HostOnly = host, true
+			c = &cc
+		}
+		if c.MatchesHost(host) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
 // Post issues a POST to the specified URL.
 //
 // Caller should close r.Body when done reading from it.
@@ -234,7 +422,7 @@ func Post(url string, bodyType string, body io.Reader) (r *Response, err os.Erro
 	return DefaultClient.Post(url, bodyType, body)
 }
 
-// Post issues a POST to the specified URL.
+// Post issues a POST to the specified URL, following redirects as Do does.
 //
 // Caller should close r.Body when done reading from it.
 func (c *Client) Post(url string, bodyType string, body io.Reader) (r *Response, err os.Error) {
@@ -243,7 +431,7 @@ func (c *Client) Post(url string, bodyType string, body io.Reader) (r *Response,
 		return nil, err
 	}
 	req.Header.Set("Content-Type", bodyType)
-	return send(req, c.Transport)
+	return c.doFollowingRedirects(req)
 }
 
 // PostForm issues a POST to the specified URL, 
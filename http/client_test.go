@@ -80,6 +80,22 @@ func TestGetRequestFormat(t *testing.T) {
 	}
 }
 
+func TestEmptyMethodError(t *testing.T) {
+	tr := &recordingTransport{}
+	client := &Client{Transport: tr}
+	req := &Request{URL: &url.URL{Scheme: "http", Host: "dummy.faketld", Path: "/"}}
+	_, err := client.Do(req) // Note: doesn't hit network
+	if err == nil {
+		t.Fatal("got no error from failing transport")
+	}
+	if uerr, ok := err.(*url.Error); !ok || uerr.Op != "Get" {
+		t.Errorf("got error %#v, want a url.Error for Get", err)
+	}
+	if req.Method != "" {
+		t.Errorf("Do changed the request method to %q", req.Method)
+	}
+}
+
 func TestPostRequestFormat(t *testing.T) {
 	tr := &recordingTransport{}
 	client := &Client{Transport: tr}
@@ -290,3 +306,155 @@ func TestClientWrites(t *testing.T) {
 		t.Errorf("Post request did %d Write calls, want 1", writes)
 	}
 }
+
+func TestRedirectPolicy(t *testing.T) {
+	var ts2 *httptest.Server
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/303":
+			Redirect(w, r, "/echo", StatusSeeOther)
+		case "/307":
+			Redirect(w, r, "/echo", StatusTemporaryRedirect)
+		case "/away":
+			Redirect(w, r, ts2.URL+"/echo", StatusFound)
+		case "/loop":
+			w.Header().Set("Location", "loop")
+			w.WriteHeader(StatusFound)
+		case "/cookieloop":
+			SetCookie(w, &Cookie{Name: "c", Value: "v"})
+			w.Header().Set("Location", "cookieloop")
+			w.WriteHeader(StatusFound)
+		case "/login":
+			if _, err := r.Cookie("session"); err == nil {
+				fmt.Fprint(w, "logged in")
+				return
+			}
+			SetCookie(w, &Cookie{Name: "session", Value: "v"})
+			Redirect(w, r, "/login", StatusFound)
+		case "/nolocation":
+			w.WriteHeader(StatusFound)
+		default:
+			b, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s auth=%q", r.Method, b, r.Header.Get("Authorization"))
+		}
+	}))
+	defer ts.Close()
+	ts2 = httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "auth=%q", r.Header.Get("Authorization"))
+	}))
+	defer ts2.Close()
+
+	c := &Client{}
+	get := func(res *Response, err os.Error) string {
+		if err != nil {
+			return err.String()
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b)
+	}
+	tests := []struct {
+		method, path, body, want string
+	}{
+		{"POST", "/303", "data", `GET  auth=""`},
+		{"POST", "/307", "data", `POST data auth=""`},
+		{"PUT", "/307", "data", `PUT data auth=""`},
+		{"GET", "/away", "", `auth=""`},
+		{"GET", "/loop", "", "Get loop: redirect loop detected"},
+		{"GET", "/cookieloop", "", "Get cookieloop: redirect loop detected"},
+		{"GET", "/nolocation", "", "Get " + ts.URL + "/nolocation: 302 response missing Location header"},
+	}
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.body != "" {
+			body = strings.NewReader(tt.body)
+		}
+		req, _ := NewRequest(tt.method, ts.URL+tt.path, body)
+		if got := get(c.Do(req)); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	// Authorization is kept on the same host
+	req, _ := NewRequest("GET", ts.URL+"/303", nil)
+	req.Header.Set("Authorization", "secret")
+	if got, want := get(c.Do(req)), `GET  auth="secret"`; got != want {
+		t.Errorf("same host: got %q, want %q", got, want)
+	}
+	req, _ = NewRequest("GET", ts.URL+"/away", nil)
+	req.Header.Set("Authorization", "secret")
+	if got, want := get(c.Do(req)), `auth=""`; got != want {
+		t.Errorf("other host: got %q, want %q", got, want)
+	}
+
+	// Revisiting a URL with the cookies set on the way is not a loop
+	c = &Client{Jar: new(testJar)}
+	if got, want := get(c.Get(ts.URL+"/login")), "logged in"; got != want {
+		t.Errorf("login with Jar: got %q, want %q", got, want)
+	}
+
+	c = &Client{MaxRedirects: 1}
+	if got, want := get(c.Get(ts.URL+"/away")), "Get "+ts2.URL+"/echo: stopped after 1 redirects"; got != want {
+		t.Errorf("MaxRedirects 1: got %q, want %q", got, want)
+	}
+	c = &Client{MaxRedirects: -1}
+	res, err := c.Get(ts.URL + "/303")
+	if err != nil || res.StatusCode != StatusSeeOther {
+		t.Errorf("MaxRedirects -1: got %v, %v; want the 303 response", res, err)
+	}
+}
+
+type testJar struct {
+	cookies []*Cookie
+}
+
+func (j *testJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	j.cookies = append(j.cookies, cookies...)
+}
+
+func (j *testJar) Cookies(u *url.URL) []*Cookie {
+	return j.cookies
+}
+
+func TestRedirectCookies(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		if n < 3 {
+			SetCookie(w, &Cookie{Name: fmt.Sprintf("c%d", n), Value: "v"})
+			SetCookie(w, &Cookie{Name: "foreign", Value: "v", Domain: "example.org"})
+			Redirect(w, r, fmt.Sprintf("/?n=%d", n+1), StatusFound)
+			return
+		}
+		var names []string
+		for _, c := range r.Cookies() {
+			names = append(names, c.Name)
+		}
+		fmt.Fprint(w, strings.Join(names, ","))
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if g, e := len(res.Cookies()), 6; g != e {
+		t.Errorf("got %d cookies on the final response, want %d", g, e)
+	}
+
+	jar := new(testJar)
+	c = &Client{Jar: jar}
+	res, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if g, e := string(b), "c0,c1,c2"; g != e {
+		t.Errorf("cookies sent after redirects: got %q, want %q", g, e)
+	}
+	if g, e := len(jar.cookies), 3; g != e {
+		t.Errorf("got %d cookies in the jar, want %d", g, e)
+	}
+}
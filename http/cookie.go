@@ -7,6 +7,7 @@ package http
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -32,30 +33,94 @@ type Cookie struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	Priority string // "Low", "Medium", "High" or empty
+	SameSite string // "Strict", "Lax", "None" or empty
+
+	// Partitioned requests that the cookie be stored in partitioned
+	// storage (CHIPS). It should typically accompany Secure and SameSite=None.
+	Partitioned bool
+
+	// HostOnly is set by cookie storage for cookies that came without a
+	// Domain attribute, which are to be returned only to the exact host
+	// that set them in Domain. It is not part of the Set-Cookie syntax.
+	HostOnly bool
+
+	// Version is set by readCookies to the value of the RFC 2965 $Version
+	// attribute that last preceded the cookie in its Cookie line, or 0 if
+	// there is none. It is not part of the Set-Cookie syntax.
+	Version int
+
 	Raw      string
 	Unparsed []string // Raw text of unparsed attribute-value pairs
 }
 
+// OnCookieParseError, if not nil, is called with the offending header line
+// whenever a Set-Cookie or Cookie line is dropped, or one of its attributes
+// is routed to Unparsed, because it fails to parse.
+var OnCookieParseError func(line string, reason string)
+
+func cookieParseError(line, reason string) {
+	if f := OnCookieParseError; f != nil {
+		f(line, reason)
+	}
+}
+
+// RejectDuplicateCookieAttrs makes the Set-Cookie parser keep only the first
+// occurrence of an attribute that is repeated within a cookie, routing the
+// others to Unparsed. By default, the last occurrence wins.
+var RejectDuplicateCookieAttrs = false
+
 // readSetCookies parses all "Set-Cookie" values from
 // the header h and returns the successfully parsed Cookies.
 func readSetCookies(h Header) []*Cookie {
+	return parseSetCookies(h, cookieParseError)
+}
+
+// A CookieParseError describes a Set-Cookie line, or an attribute in it,
+// that failed to parse.
+type CookieParseError struct {
+	Line   string // The offending header line
+	Reason string
+}
+
+func (e CookieParseError) String() string {
+	return "http: Set-Cookie " + strconv.Quote(e.Line) + ": " + e.Reason
+}
+
+// readSetCookiesVerbose is like readSetCookies, but also returns the reasons
+// why lines were dropped or attributes were routed to Unparsed.
+func readSetCookiesVerbose(h Header) ([]*Cookie, []CookieParseError) {
+	var errs []CookieParseError
+	cookies := parseSetCookies(h, func(line, reason string) {
+		errs = append(errs, CookieParseError{line, reason})
+		cookieParseError(line, reason)
+	})
+	return cookies, errs
+}
+
+// parseSetCookies parses the "Set-Cookie" values of h, calling report
+// for every line or attribute that fails to parse.
+func parseSetCookies(h Header, report func(line, reason string)) []*Cookie {
 	cookies := []*Cookie{}
-	for _, line := range h["Set-Cookie"] {
-		parts := strings.Split(strings.TrimSpace(line), ";")
+	for _, line := range h.Values("Set-Cookie") {
+		parts := splitSetCookie(strings.TrimSpace(line))
 		if len(parts) == 1 && parts[0] == "" {
 			continue
 		}
 		parts[0] = strings.TrimSpace(parts[0])
 		j := strings.Index(parts[0], "=")
 		if j < 0 {
+			report(line, "missing '=' in name-value pair")
 			continue
 		}
 		name, value := parts[0][:j], parts[0][j+1:]
 		if !isCookieNameValid(name) {
+			report(line, "invalid cookie name")
 			continue
 		}
-		value, success := parseCookieValue(value)
+		value, success := parseSetCookieValue(value)
 		if !success {
+			report(line, "invalid cookie value")
 			continue
 		}
 		c := &Cookie{
@@ -63,6 +128,10 @@ func readSetCookies(h Header) []*Cookie {
 			Value: value,
 			Raw:   line,
 		}
+		var seen map[string]bool
+		if RejectDuplicateCookieAttrs {
+			seen = make(map[string]bool)
+		}
 		for i := 1; i < len(parts); i++ {
 			parts[i] = strings.TrimSpace(parts[i])
 			if len(parts[i]) == 0 {
@@ -74,12 +143,21 @@ func readSetCookies(h Header) []*Cookie {
 				attr, val = attr[:j], attr[j+1:]
 			}
 			lowerAttr := strings.ToLower(attr)
+			if seen != nil {
+				if seen[lowerAttr] {
+					report(line, "duplicate attribute "+attr)
+					c.Unparsed = append(c.Unparsed, parts[i])
+					continue
+				}
+				seen[lowerAttr] = true
+			}
 			parseCookieValueFn := parseCookieValue
 			if lowerAttr == "expires" {
 				parseCookieValueFn = parseCookieExpiresValue
 			}
 			val, success = parseCookieValueFn(val)
 			if !success {
+				report(line, "invalid value of attribute "+attr)
 				c.Unparsed = append(c.Unparsed, parts[i])
 				continue
 			}
@@ -90,6 +168,9 @@ func readSetCookies(h Header) []*Cookie {
 			case "httponly":
 				c.HttpOnly = true
 				continue
+			case "partitioned":
+				c.Partitioned = true
+				continue
 			case "domain":
 				c.Domain = val
 				// TODO: Add domain parsing
@@ -121,17 +202,157 @@ func readSetCookies(h Header) []*Cookie {
 				c.Path = val
 				// TODO: Add path parsing
 				continue
+			case "priority":
+				// Unknown priorities leave the priority unspecified
+				prio, ok := cookiePriorities[strings.ToLower(val)]
+				if !ok {
+					report(line, "unknown priority "+val)
+				}
+				c.Priority = prio
+				continue
+			case "samesite":
+				mode, ok := cookieSameSites[strings.ToLower(val)]
+				if !ok {
+					report(line, "unknown SameSite mode "+val)
+				}
+				c.SameSite = mode
+				continue
 			}
+			report(line, "unrecognized or malformed attribute "+attr)
 			c.Unparsed = append(c.Unparsed, parts[i])
 		}
+		// User agents ignore cookies that violate their name prefix. The name
+		// and value are checked already, allowing semicolons in quoted values.
+		if err := c.validAttrs(); err != nil {
+			report(line, err.String())
+			continue
+		}
 		cookies = append(cookies, c)
 	}
 	return cookies
 }
 
+var cookiePriorities = map[string]string{
+	"low":    "Low",
+	"medium": "Medium",
+	"high":   "High",
+}
+
+var cookieSameSites = map[string]string{
+	"strict": "Strict",
+	"lax":    "Lax",
+	"none":   "None",
+}
+
+var (
+	ErrCookieName         = os.NewError("http: invalid cookie name")
+	ErrCookieValue        = os.NewError("http: invalid cookie value")
+	ErrCookiePath         = os.NewError("http: invalid cookie path")
+	ErrCookieDomain       = os.NewError("http: invalid cookie domain")
+	ErrCookieSecurePrefix = os.NewError("http: __Secure- cookie without Secure attribute")
+	ErrCookieHostPrefix   = os.NewError("http: __Host- cookie without Secure, with Domain, or with Path other than /")
+)
+
+// Valid reports whether c can be sent in a Set-Cookie header. The name must
+// be a token, the value must consist of cookie octets, and the path and domain
+// must not contain control characters or semicolons. Valid also enforces the
+// requirements of the cookie name prefixes: a cookie named __Secure-* must be
+// Secure, and a cookie named __Host-* must be Secure, have no Domain and have
+// Path "/".
+func (c *Cookie) Valid() os.Error {
+	if c.Name == "" || !isCookieNameValid(c.Name) {
+		return ErrCookieName
+	}
+	if _, ok := parseCookieValue(c.Value); !ok {
+		return ErrCookieValue
+	}
+	return c.validAttrs()
+}
+
+// validAttrs is the part of Valid that checks the path, the domain and the
+// requirements of the name prefixes.
+func (c *Cookie) validAttrs() os.Error {
+	if !isCookieAttrValid(c.Path) {
+		return ErrCookiePath
+	}
+	if !isCookieAttrValid(c.Domain) {
+		return ErrCookieDomain
+	}
+	switch {
+	case strings.HasPrefix(c.Name, "__Secure-"):
+		if !c.Secure {
+			return ErrCookieSecurePrefix
+		}
+	case strings.HasPrefix(c.Name, "__Host-"):
+		if !c.Secure || c.Domain != "" || c.Path != "/" {
+			return ErrCookieHostPrefix
+		}
+	}
+	return nil
+}
+
+// WriteSetCookies adds a Set-Cookie header to h for each valid cookie.
+// Invalid cookies are skipped and reported together in the returned error.
+func WriteSetCookies(h Header, cookies []*Cookie) os.Error {
+	var rejected []string
+	for _, c := range cookies {
+		if err := c.Valid(); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%q (%s)", c.Name, err))
+			continue
+		}
+		h.Add("Set-Cookie", c.String())
+	}
+	if len(rejected) > 0 {
+		return os.NewError("http: rejected Set-Cookie for " + strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// ProxySetCookies passes each cookie in the Set-Cookie lines of h to rewrite,
+// which may change it, for instance to set its Domain for the proxy's host or
+// to add Secure, and replaces the line with the rewritten cookie. Attributes
+// of the cookie that did not parse are carried over. Lines whose cookie is
+// left unchanged, and lines that fail to parse, are kept verbatim. Cookies
+// that rewrite makes invalid are dropped.
+func ProxySetCookies(h Header, rewrite func(*Cookie)) {
+	lines := h.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		cookies := readSetCookies(Header{"Set-Cookie": {line}})
+		if len(cookies) == 0 {
+			out = append(out, line)
+			continue
+		}
+		c := cookies[0]
+		orig := proxyCookieString(c)
+		rewrite(c)
+		if s := proxyCookieString(c); s != orig {
+			if c.Valid() != nil {
+				continue
+			}
+			line = s
+		}
+		out = append(out, line)
+	}
+	h["Set-Cookie"] = out
+}
+
+// proxyCookieString is like c.String, followed by the unparsed attributes of c.
+func proxyCookieString(c *Cookie) string {
+	s := c.String()
+	for _, u := range c.Unparsed {
+		s += "; " + u
+	}
+	return s
+}
+
 // SetCookie adds a Set-Cookie header to the provided ResponseWriter's headers.
+// Invalid cookies are silently dropped.
 func SetCookie(w ResponseWriter, cookie *Cookie) {
-	w.Header().Add("Set-Cookie", cookie.String())
+	WriteSetCookies(w.Header(), []*Cookie{cookie})
 }
 
 // String returns the serialization of the cookie for use in a Cookie
@@ -146,8 +367,8 @@ func (c *Cookie) String() string {
 	if len(c.Domain) > 0 {
 		fmt.Fprintf(&b, "; Domain=%s", sanitizeValue(c.Domain))
 	}
-	if len(c.Expires.Zone) > 0 {
-		fmt.Fprintf(&b, "; Expires=%s", c.Expires.Format(time.RFC1123))
+	if !isZeroTime(&c.Expires) {
+		fmt.Fprintf(&b, "; Expires=%s", formatCookieExpires(&c.Expires))
 	}
 	if c.MaxAge > 0 {
 		fmt.Fprintf(&b, "; Max-Age=%d", c.MaxAge)
@@ -160,17 +381,54 @@ func (c *Cookie) String() string {
 	if c.Secure {
 		fmt.Fprintf(&b, "; Secure")
 	}
+	if c.Partitioned {
+		fmt.Fprintf(&b, "; Partitioned")
+	}
+	if prio, ok := cookiePriorities[strings.ToLower(c.Priority)]; ok {
+		fmt.Fprintf(&b, "; Priority=%s", prio)
+	}
+	if mode, ok := cookieSameSites[strings.ToLower(c.SameSite)]; ok {
+		fmt.Fprintf(&b, "; SameSite=%s", mode)
+	}
 	return b.String()
 }
 
+// responseAttrs returns the names of the attributes set in c that only
+// a Set-Cookie header can carry, or nil if there are none.
+func (c *Cookie) responseAttrs() []string {
+	var attrs []string
+	if !isZeroTime(&c.Expires) || c.RawExpires != "" {
+		attrs = append(attrs, "Expires")
+	}
+	if c.MaxAge != 0 {
+		attrs = append(attrs, "Max-Age")
+	}
+	if c.Secure {
+		attrs = append(attrs, "Secure")
+	}
+	if c.HttpOnly {
+		attrs = append(attrs, "HttpOnly")
+	}
+	if c.Partitioned {
+		attrs = append(attrs, "Partitioned")
+	}
+	if c.Priority != "" {
+		attrs = append(attrs, "Priority")
+	}
+	if c.SameSite != "" {
+		attrs = append(attrs, "SameSite")
+	}
+	return attrs
+}
+
 // readCookies parses all "Cookie" values from the header h and
 // returns the successfully parsed Cookies.
 //
 // if filter isn't empty, only cookies of that name are returned
 func readCookies(h Header, filter string) []*Cookie {
 	cookies := []*Cookie{}
-	lines, ok := h["Cookie"]
-	if !ok {
+	lines := h.Values("Cookie")
+	if len(lines) == 0 {
 		return cookies
 	}
 
@@ -181,6 +439,8 @@ func readCookies(h Header, filter string) []*Cookie {
 		}
 		// Per-line attributes
 		parsedPairs := 0
+		version := 0     // Applies to the cookies that follow it, up to the next $Version
+		var last *Cookie // Cookie that subsequent $Path and $Domain apply to
 		for i := 0; i < len(parts); i++ {
 			parts[i] = strings.TrimSpace(parts[i])
 			if len(parts[i]) == 0 {
@@ -190,7 +450,33 @@ func readCookies(h Header, filter string) []*Cookie {
 			if j := strings.Index(name, "="); j >= 0 {
 				name, val = name[:j], name[j+1:]
 			}
+			if len(name) > 0 && name[0] == '$' {
+				// Per RFC 2965, $Version applies to the cookies that follow
+				// it, $Path and $Domain to the preceding cookie only
+				val, success := parseCookieValue(val)
+				if !success {
+					continue
+				}
+				if strings.ToLower(name) == "$version" {
+					if v, err := strconv.Atoi(val); err == nil && v >= 0 {
+						version = v
+					}
+					continue
+				}
+				if last == nil {
+					continue
+				}
+				switch strings.ToLower(name) {
+				case "$path":
+					last.Path = val
+				case "$domain":
+					last.Domain = val
+				}
+				continue
+			}
+			last = nil
 			if !isCookieNameValid(name) {
+				cookieParseError(line, "invalid cookie name")
 				continue
 			}
 			if filter != "" && filter != name {
@@ -198,15 +484,95 @@ func readCookies(h Header, filter string) []*Cookie {
 			}
 			val, success := parseCookieValue(val)
 			if !success {
+				cookieParseError(line, "invalid cookie value")
 				continue
 			}
-			cookies = append(cookies, &Cookie{Name: name, Value: val})
+			last = &Cookie{Name: name, Value: val, Version: version}
+			cookies = append(cookies, last)
 			parsedPairs++
 		}
 	}
 	return cookies
 }
 
+// ReadCookie returns the first cookie named name in the "Cookie" values
+// of h, or ErrNoCookie if there is none. The header is left unchanged.
+func ReadCookie(h Header, name string) (*Cookie, os.Error) {
+	for _, c := range readCookies(h, name) {
+		return c, nil
+	}
+	return nil, ErrNoCookie
+}
+
+// MatchesHost reports whether c should be sent to host, according to
+// its Domain and HostOnly fields (RFC 6265, Section 5.1.3). A cookie with
+// an empty Domain matches no host.
+func (c *Cookie) MatchesHost(host string) bool {
+	host = strings.ToLower(host)
+	domain := strings.ToLower(c.Domain)
+	if len(domain) > 0 && domain[0] == '.' {
+		domain = domain[1:]
+	}
+	if domain == "" {
+		return false
+	}
+	if host == domain {
+		return true
+	}
+	return !c.HostOnly && strings.HasSuffix(host, "."+domain)
+}
+
+// SetExpires sets the expiration time of the cookie to t, and RawExpires
+// to its serialization.
+func (c *Cookie) SetExpires(t time.Time) {
+	c.Expires = t
+	c.RawExpires = formatCookieExpires(&t)
+}
+
+// ExpiryTime returns the time at which the cookie expires, counting from
+// now. As RFC 6265 prescribes, Max-Age takes precedence over Expires, and a
+// negative MaxAge, which is how a "Max-Age=0" attribute is parsed, expires
+// the cookie at once, which is reported as the Unix epoch. If neither is
+// set, the cookie is a session cookie, and ExpiryTime returns the zero Time
+// and true.
+func (c *Cookie) ExpiryTime(now time.Time) (time.Time, bool) {
+	switch {
+	case c.MaxAge > 0:
+		return *time.SecondsToUTC(now.Seconds() + int64(c.MaxAge)), false
+	case c.MaxAge < 0:
+		return *time.SecondsToUTC(0), false
+	case !isZeroTime(&c.Expires):
+		return c.Expires, false
+	}
+	return time.Time{}, true
+}
+
+// Clone returns a copy of c that shares no memory with it, so that either
+// can be changed without affecting the other. Clone of nil is nil.
+func (c *Cookie) Clone() *Cookie {
+	if c == nil {
+		return nil
+	}
+	c2 := *c
+	if c.Unparsed != nil {
+		c2.Unparsed = make([]string, len(c.Unparsed))
+		copy(c2.Unparsed, c.Unparsed)
+	}
+	return &c2
+}
+
+// formatCookieExpires formats t for the Expires attribute. Cookie dates
+// are always given in GMT.
+func formatCookieExpires(t *time.Time) string {
+	return time.SecondsToUTC(t.Seconds()).Format("Mon, 02 Jan 2006 15:04:05 GMT")
+}
+
+// isZeroTime reports whether t is the zero Time, i.e. no time at all.
+func isZeroTime(t *time.Time) bool {
+	return t.Year == 0 && t.Month == 0 && t.Day == 0 &&
+		t.Hour == 0 && t.Minute == 0 && t.Second == 0
+}
+
 var cookieNameSanitizer = strings.NewReplacer("\n", "-", "\r", "-")
 
 func sanitizeName(n string) string {
@@ -219,6 +585,26 @@ func sanitizeValue(v string) string {
 	return cookieValueSanitizer.Replace(v)
 }
 
+// splitSetCookie splits a "Set-Cookie" line at the semicolons that separate
+// its attributes, leaving those inside double-quoted values in place.
+func splitSetCookie(line string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				parts = append(parts, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, line[start:])
+}
+
 func unquoteCookieValue(v string) string {
 	if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
 		return v[1 : len(v)-1]
@@ -243,6 +629,15 @@ func parseCookieValue(raw string) (string, bool) {
 	return parseCookieValueUsing(raw, isCookieByte)
 }
 
+// parseSetCookieValue is like parseCookieValue, but allows the semicolons
+// that splitSetCookie leaves inside double-quoted values.
+func parseSetCookieValue(raw string) (string, bool) {
+	if len(raw) > 1 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		return parseCookieValueUsing(raw, func(c byte) bool { return isCookieByte(c) || c == ';' })
+	}
+	return parseCookieValue(raw)
+}
+
 func parseCookieExpiresValue(raw string) (string, bool) {
 	return parseCookieValueUsing(raw, isCookieExpiresByte)
 }
@@ -257,6 +652,15 @@ func parseCookieValueUsing(raw string, validByte func(byte) bool) (string, bool)
 	return raw, true
 }
 
+func isCookieAttrValid(raw string) bool {
+	for i := 0; i < len(raw); i++ {
+		if c := raw[i]; c < 0x20 || c >= 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
 func isCookieNameValid(raw string) bool {
 	for _, c := range raw {
 		if !isToken(byte(c)) {
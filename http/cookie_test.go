@@ -5,10 +5,13 @@
 package http
 
 import (
+	"bytes"
 	"fmt"
 	"json"
+	"log"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -33,6 +36,43 @@ var writeSetCookiesTests = []struct {
 		&Cookie{Name: "cookie-4", Value: "four", Path: "/restricted/"},
 		"cookie-4=four; Path=/restricted/",
 	},
+	{
+		&Cookie{Name: "cookie-5", Value: "five", Priority: "High"},
+		"cookie-5=five; Priority=High",
+	},
+	{
+		&Cookie{Name: "cookie-6", Value: "six", Secure: true, Partitioned: true},
+		"cookie-6=six; Secure; Partitioned",
+	},
+	{
+		&Cookie{Name: "cookie-7", Value: "seven", Expires: *time.SecondsToUTC(1e9)},
+		"cookie-7=seven; Expires=Sun, 09 Sep 2001 01:46:40 GMT",
+	},
+	{
+		&Cookie{Name: "cookie-8", Value: "eight", Expires: time.Time{Year: 2011, Month: 11, Day: 23, Hour: 1, Minute: 5, Second: 3}},
+		"cookie-8=eight; Expires=Wed, 23 Nov 2011 01:05:03 GMT",
+	},
+	{
+		&Cookie{Name: "cookie-9", Value: "nine", Priority: "low"},
+		"cookie-9=nine; Priority=Low",
+	},
+	{
+		&Cookie{Name: "cookie-10", Value: "ten", Priority: "urgent"},
+		"cookie-10=ten",
+	},
+	{
+		&Cookie{Name: "cookie-11", Value: "eleven", SameSite: "lax"},
+		"cookie-11=eleven; SameSite=Lax",
+	},
+	// Path and Domain are not URL-escaped, but kept from splitting the line
+	{
+		&Cookie{Name: "cookie-12", Value: "twelve", Path: "/", Domain: "app.example.com"},
+		"cookie-12=twelve; Path=/; Domain=app.example.com",
+	},
+	{
+		&Cookie{Name: "cookie-13", Value: "thirteen", Path: "/app/a,b;Secure"},
+		"cookie-13=thirteen; Path=/app/a,b Secure",
+	},
 }
 
 func TestWriteSetCookies(t *testing.T) {
@@ -58,6 +98,73 @@ func (ho headerOnlyResponseWriter) WriteHeader(int) {
 	panic("NOIMPL")
 }
 
+func TestReadSetCookiesVerbose(t *testing.T) {
+	h := Header{"Set-Cookie": {"good=1; Path=/", "bad value=1", "c=1; Max-Age=forever", "noequals"}}
+	cookies, errs := readSetCookiesVerbose(h)
+	if len(cookies) != 2 || cookies[0].Name != "good" || cookies[1].Name != "c" {
+		t.Errorf("got cookies %s", toJSON(cookies))
+	}
+	want := []CookieParseError{
+		{"bad value=1", "invalid cookie name"},
+		{"c=1; Max-Age=forever", "unrecognized or malformed attribute Max-Age"},
+		{"noequals", "missing '=' in name-value pair"},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("got errors %v, want %v", errs, want)
+	}
+}
+
+func TestCookieSetExpires(t *testing.T) {
+	c := &Cookie{Name: "a", Value: "1", RawExpires: "stale"}
+	c.SetExpires(*time.SecondsToUTC(1e9))
+	if g, e := c.RawExpires, "Sun, 09 Sep 2001 01:46:40 GMT"; g != e {
+		t.Errorf("RawExpires: got %q, want %q", g, e)
+	}
+	if g, e := c.String(), "a=1; Expires=Sun, 09 Sep 2001 01:46:40 GMT"; g != e {
+		t.Errorf("String: got %q, want %q", g, e)
+	}
+}
+
+var expiryTimeTests = []struct {
+	expires int64 // Seconds since the epoch, or 0 for no Expires
+	maxAge  int
+	want    int64
+	session bool
+}{
+	{0, 0, 0, true},
+	{2e9, 0, 2e9, false},
+	{0, 60, 1e9 + 60, false},
+	{2e9, 60, 1e9 + 60, false},
+	{2e9, -1, 0, false},
+}
+
+func TestCookieExpiryTime(t *testing.T) {
+	now := time.SecondsToUTC(1e9)
+	for _, tt := range expiryTimeTests {
+		c := &Cookie{Name: "a", Value: "1", MaxAge: tt.maxAge}
+		if tt.expires != 0 {
+			c.SetExpires(*time.SecondsToUTC(tt.expires))
+		}
+		exp, session := c.ExpiryTime(*now)
+		if session != tt.session {
+			t.Errorf("Expires %d, MaxAge %d: got session %v, want %v", tt.expires, tt.maxAge, session, tt.session)
+		}
+		if !session && exp.Seconds() != tt.want {
+			t.Errorf("Expires %d, MaxAge %d: got expiry %d, want %d", tt.expires, tt.maxAge, exp.Seconds(), tt.want)
+		}
+	}
+
+	// Max-Age=0 deletes the cookie, even with a future Expires
+	h := Header{"Set-Cookie": {"a=1; Max-Age=0; Expires=Wed, 18 May 2033 03:33:20 GMT"}}
+	cookies := readSetCookies(h)
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	if exp, session := cookies[0].ExpiryTime(*now); session || exp.Seconds() > now.Seconds() {
+		t.Errorf("Max-Age=0: got expiry %d and session %v, want an expired cookie", exp.Seconds(), session)
+	}
+}
+
 func TestSetCookie(t *testing.T) {
 	m := make(Header)
 	SetCookie(headerOnlyResponseWriter(m), &Cookie{Name: "cookie-1", Value: "one", Path: "/restricted/"})
@@ -93,6 +200,15 @@ var addCookieTests = []struct {
 		},
 		"cookie-1=v$1; cookie-2=v$2; cookie-3=v$3",
 	},
+	{
+		[]*Cookie{
+			&Cookie{Name: "a\r\nX-Injected: 1", Value: "1"},
+			&Cookie{Name: "b", Value: "2\r\nX-Injected: 1"},
+			&Cookie{Name: "c", Value: "x;y"},
+			&Cookie{Name: "d", Value: "4"},
+		},
+		"d=4",
+	},
 }
 
 func TestAddCookie(t *testing.T) {
@@ -108,6 +224,25 @@ func TestAddCookie(t *testing.T) {
 	}
 }
 
+func TestAddCookieResponseAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(&Cookie{Name: "a", Value: "1", Path: "/", Domain: "example.com"})
+	if buf.Len() > 0 {
+		t.Errorf("request cookie was logged: %s", buf.String())
+	}
+	req.AddCookie(&Cookie{Name: "b", Value: "2", MaxAge: 60, Secure: true, SameSite: "Lax"})
+	if g := req.Header.Get("Cookie"); g != "a=1; b=2" {
+		t.Errorf("Cookie: got %q, want %q", g, "a=1; b=2")
+	}
+	if g := buf.String(); !strings.Contains(g, `Max-Age, Secure, SameSite of cookie "b"`) {
+		t.Errorf("response attributes were not logged, got %q", g)
+	}
+}
+
 var readSetCookiesTests = []struct {
 	Header  Header
 	Cookies []*Cookie
@@ -129,6 +264,128 @@ var readSetCookiesTests = []struct {
 			Raw:        "NID=99=YsDT5i3E-CXax-; expires=Wed, 23-Nov-2011 01:05:03 GMT; path=/; domain=.google.ch; HttpOnly",
 		}},
 	},
+	{
+		Header{"Set-Cookie": {"chips=1; Secure; Partitioned; Path=/"}},
+		[]*Cookie{&Cookie{Name: "chips", Value: "1", Secure: true, Partitioned: true, Path: "/", Raw: "chips=1; Secure; Partitioned; Path=/"}},
+	},
+	{
+		Header{"Set-Cookie": {`sid="x;y;z"; Path=/`}},
+		[]*Cookie{&Cookie{Name: "sid", Value: "x;y;z", Path: "/", Raw: `sid="x;y;z"; Path=/`}},
+	},
+	{
+		Header{"Set-Cookie": {"a=1; Priority=high", "b=2; priority=Low", "c=3; Priority=urgent"}},
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1", Priority: "High", Raw: "a=1; Priority=high"},
+			&Cookie{Name: "b", Value: "2", Priority: "Low", Raw: "b=2; priority=Low"},
+			&Cookie{Name: "c", Value: "3", Raw: "c=3; Priority=urgent"},
+		},
+	},
+	{
+		Header{"Set-Cookie": {"a=1; SameSite=strict", "b=2; SameSite=bogus"}},
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1", SameSite: "Strict", Raw: "a=1; SameSite=strict"},
+			&Cookie{Name: "b", Value: "2", Raw: "b=2; SameSite=bogus"},
+		},
+	},
+	{
+		Header{"Set-Cookie": {"__Secure-a=1", "__Host-b=2; Secure", "__Host-c=3; Secure; Path=/; Domain=example.com"}},
+		[]*Cookie{},
+	},
+	{
+		Header{"Set-Cookie": {"__Secure-a=1; Secure", "__Host-b=2; Secure; Path=/"}},
+		[]*Cookie{
+			&Cookie{Name: "__Secure-a", Value: "1", Secure: true, Raw: "__Secure-a=1; Secure"},
+			&Cookie{Name: "__Host-b", Value: "2", Secure: true, Path: "/", Raw: "__Host-b=2; Secure; Path=/"},
+		},
+	},
+}
+
+var cookieValidTests = []struct {
+	Cookie *Cookie
+	Valid  bool
+}{
+	{&Cookie{Name: "sid"}, true},
+	{&Cookie{Name: ""}, false},
+	{&Cookie{Name: "s id"}, false},
+	{&Cookie{Name: "sid\r\nX-Evil: 1"}, false},
+	{&Cookie{Name: "sid", Value: "a;b"}, false},
+	{&Cookie{Name: "sid", Value: "a b"}, false},
+	{&Cookie{Name: "sid", Value: `"quoted"`}, true},
+	{&Cookie{Name: "sid", Path: "/a;b"}, false},
+	{&Cookie{Name: "sid", Path: "/a\nb"}, false},
+	{&Cookie{Name: "sid", Domain: "example.com\r"}, false},
+	{&Cookie{Name: "__Secure-sid"}, false},
+	{&Cookie{Name: "__Secure-sid", Secure: true}, true},
+	{&Cookie{Name: "__Secure-sid", Secure: true, Domain: "example.com", Path: "/a"}, true},
+	{&Cookie{Name: "__Host-sid", Path: "/"}, false},
+	{&Cookie{Name: "__Host-sid", Secure: true}, false},
+	{&Cookie{Name: "__Host-sid", Secure: true, Path: "/a"}, false},
+	{&Cookie{Name: "__Host-sid", Secure: true, Path: "/", Domain: "example.com"}, false},
+	{&Cookie{Name: "__Host-sid", Secure: true, Path: "/"}, true},
+}
+
+func TestCookieValid(t *testing.T) {
+	for i, tt := range cookieValidTests {
+		if err := tt.Cookie.Valid(); (err == nil) != tt.Valid {
+			t.Errorf("#%d %s: Valid() = %v, want valid %v", i, tt.Cookie, err, tt.Valid)
+		}
+	}
+}
+
+func TestWriteSetCookiesRejected(t *testing.T) {
+	h := make(Header)
+	err := WriteSetCookies(h, []*Cookie{
+		&Cookie{Name: "good", Value: "1"},
+		&Cookie{Name: "bad;name", Value: "2"},
+		&Cookie{Name: "other", Value: "3\r\n"},
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if g, e := h["Set-Cookie"], []string{"good=1"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("Set-Cookie: got %q, want %q", g, e)
+	}
+	for _, name := range []string{`"bad;name"`, `"other"`} {
+		if !strings.Contains(err.String(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+}
+
+func TestOnCookieParseError(t *testing.T) {
+	type report struct{ line, reason string }
+	var reports []report
+	OnCookieParseError = func(line, reason string) {
+		reports = append(reports, report{line, reason})
+	}
+	defer func() { OnCookieParseError = nil }()
+
+	readSetCookies(Header{"Set-Cookie": {"good=1; Path=/", "bad value=1", "c=1; Max-Age=forever"}})
+	readCookies(Header{"Cookie": {"ok=1; b@d=2"}}, "")
+	want := []string{"bad value=1", "c=1; Max-Age=forever", "ok=1; b@d=2"}
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports %v, want %d", len(reports), reports, len(want))
+	}
+	for i, r := range reports {
+		if r.line != want[i] || r.reason == "" {
+			t.Errorf("report #%d: got (%q, %q), want line %q", i, r.line, r.reason, want[i])
+		}
+	}
+}
+
+func TestDuplicateCookieAttrs(t *testing.T) {
+	h := Header{"Set-Cookie": {"a=1; Path=/one; path=/two"}}
+	c := readSetCookies(h)
+	if len(c) != 1 || c[0].Path != "/two" || c[0].Unparsed != nil {
+		t.Errorf("last wins: got %s", toJSON(c))
+	}
+
+	RejectDuplicateCookieAttrs = true
+	defer func() { RejectDuplicateCookieAttrs = false }()
+	c = readSetCookies(h)
+	if len(c) != 1 || c[0].Path != "/one" || !reflect.DeepEqual(c[0].Unparsed, []string{"path=/two"}) {
+		t.Errorf("reject duplicates: got %s", toJSON(c))
+	}
 }
 
 func toJSON(v interface{}) string {
@@ -186,6 +443,39 @@ var readCookiesTests = []struct {
 			&Cookie{Name: "c2", Value: "v2"},
 		},
 	},
+	{
+		Header{"Cookie": {"a=1; $Path=/x; b=2"}},
+		"",
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1", Path: "/x"},
+			&Cookie{Name: "b", Value: "2"},
+		},
+	},
+	{
+		Header{"Cookie": {"a=1; b=2; $Domain=.example.com; $Path=/y"}},
+		"",
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1"},
+			&Cookie{Name: "b", Value: "2", Path: "/y", Domain: ".example.com"},
+		},
+	},
+	{
+		Header{"Cookie": {`$Version="1"; a=1; $Path="/"; $Version=0; b=2; $Version=1; c=3; $Domain=.example.com`}},
+		"",
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1", Path: "/", Version: 1},
+			&Cookie{Name: "b", Value: "2"},
+			&Cookie{Name: "c", Value: "3", Domain: ".example.com", Version: 1},
+		},
+	},
+	{
+		Header{"Cookie": {"$Version=1; a=1", "b=2"}},
+		"",
+		[]*Cookie{
+			&Cookie{Name: "a", Value: "1", Version: 1},
+			&Cookie{Name: "b", Value: "2"},
+		},
+	},
 }
 
 func TestReadCookies(t *testing.T) {
@@ -199,3 +489,88 @@ func TestReadCookies(t *testing.T) {
 		}
 	}
 }
+
+func TestReadCookie(t *testing.T) {
+	h := Header{"Cookie": {"a=1; b=2", "b=3; c=4"}}
+	c, err := ReadCookie(h, "b")
+	if err != nil || c.Name != "b" || c.Value != "2" {
+		t.Errorf("ReadCookie b: got %v, %v", c, err)
+	}
+	if _, err = ReadCookie(h, "d"); err != ErrNoCookie {
+		t.Errorf("ReadCookie d: got error %v, want ErrNoCookie", err)
+	}
+	if g := h["Cookie"]; len(g) != 2 || g[0] != "a=1; b=2" || g[1] != "b=3; c=4" {
+		t.Errorf("header was modified: %q", g)
+	}
+}
+
+var matchesHostTests = []struct {
+	Cookie *Cookie
+	Host   string
+	Match  bool
+}{
+	{&Cookie{Domain: "example.com", HostOnly: true}, "example.com", true},
+	{&Cookie{Domain: "example.com", HostOnly: true}, "sub.example.com", false},
+	{&Cookie{Domain: "example.com"}, "sub.example.com", true},
+	{&Cookie{Domain: ".Example.com"}, "example.COM", true},
+	{&Cookie{Domain: "example.com"}, "badexample.com", false},
+	{&Cookie{}, "example.com", false},
+}
+
+func TestMatchesHost(t *testing.T) {
+	for i, tt := range matchesHostTests {
+		if g := tt.Cookie.MatchesHost(tt.Host); g != tt.Match {
+			t.Errorf("#%d: MatchesHost(%q) of %+v: got %v, want %v", i, tt.Host, tt.Cookie, g, tt.Match)
+		}
+	}
+}
+
+func TestProxySetCookies(t *testing.T) {
+	h := Header{"Set-Cookie": {
+		"a=1; Domain=upstream.internal; Path=/",
+		"b=2; Path=/; Priority=Urgent",
+		"=nameless; Domain=upstream.internal",
+		"c=3",
+		"d=4; Domain=other.example; Foo=Bar",
+		`sid="x;y;z"; Path=/`,
+	}}
+	ProxySetCookies(h, func(c *Cookie) {
+		if c.Domain == "upstream.internal" {
+			c.Domain = "proxy.example"
+		}
+	})
+	want := []string{
+		"a=1; Path=/; Domain=proxy.example",
+		"b=2; Path=/; Priority=Urgent",
+		"=nameless; Domain=upstream.internal",
+		"c=3",
+		"d=4; Domain=other.example; Foo=Bar",
+		`sid="x;y;z"; Path=/`,
+	}
+	if !reflect.DeepEqual(h["Set-Cookie"], want) {
+		t.Errorf("got %q, want %q", h["Set-Cookie"], want)
+	}
+
+	h = Header{"Set-Cookie": {"a=1; Domain=upstream.internal; Foo=Bar; Path=/"}}
+	ProxySetCookies(h, func(c *Cookie) { c.Domain = ""; c.Secure = true })
+	if g := h["Set-Cookie"]; len(g) != 1 || g[0] != "a=1; Path=/; Secure; Foo=Bar" {
+		t.Errorf("unparsed attribute: got %q", g)
+	}
+}
+
+func TestCookieClone(t *testing.T) {
+	h := Header{"Set-Cookie": {"a=1; Path=/; Flavor=mint; Secure"}}
+	c := readSetCookies(h)[0]
+	c2 := c.Clone()
+	if !reflect.DeepEqual(c, c2) {
+		t.Fatalf("clone differs:\nhave: %s\nwant: %s", toJSON(c2), toJSON(c))
+	}
+	c2.Value = "2"
+	c2.Unparsed[0] = "Flavor=lime"
+	if c.Value != "1" || c.Unparsed[0] != "Flavor=mint" {
+		t.Errorf("changing the clone changed the original: %s", toJSON(c))
+	}
+	if (*Cookie)(nil).Clone() != nil {
+		t.Errorf("Clone of nil is not nil")
+	}
+}
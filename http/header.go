@@ -14,11 +14,16 @@ import (
 )
 
 // A Header represents the key-value pairs in an HTTP header.
+// Its methods canonicalize keys with CanonicalHeaderKey. A nil Header
+// reads as empty, but Add and Set panic on it.
 type Header map[string][]string
 
 // Add adds the key, value pair to the header.
 // It appends to any existing values associated with key.
 func (h Header) Add(key, value string) {
+	if h == nil {
+		panic("http: Add on nil Header")
+	}
 	textproto.MIMEHeader(h).Add(key, value)
 }
 
@@ -26,6 +31,9 @@ func (h Header) Add(key, value string) {
 // the single element value.  It replaces any existing
 // values associated with key.
 func (h Header) Set(key, value string) {
+	if h == nil {
+		panic("http: Set on nil Header")
+	}
 	textproto.MIMEHeader(h).Set(key, value)
 }
 
@@ -37,33 +45,113 @@ func (h Header) Get(key string) string {
 	return textproto.MIMEHeader(h).Get(key)
 }
 
+// Values returns all values associated with the given key, or nil.
+// The returned slice is not a copy.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
 // Del deletes the values associated with key.
 func (h Header) Del(key string) {
+	if h == nil {
+		return
+	}
 	textproto.MIMEHeader(h).Del(key)
 }
 
+// Clone returns a copy of h whose value slices are copies as well, so that
+// changes to either header do not show in the other. It returns nil if h
+// is nil.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(Header, len(h))
+	for k, vv := range h {
+		vv2 := make([]string, len(vv))
+		copy(vv2, vv)
+		h2[k] = vv2
+	}
+	return h2
+}
+
 // Write writes a header in wire format.
 func (h Header) Write(w io.Writer) os.Error {
 	return h.WriteSubset(w, nil)
 }
 
+var (
+	ErrHeaderKey   = os.NewError("http: header key is not a token")
+	ErrHeaderValue = os.NewError("http: header value contains CR or LF")
+)
+
+// LenientHeaderWrite makes the writing of headers, and of the requests and
+// responses that carry them, repair invalid headers rather than fail: bytes
+// that may not appear in a key are removed, keys left empty are skipped, and
+// CR and LF in values are replaced by spaces. Request.AddCookie then likewise
+// sanitizes invalid cookies, instead of dropping them.
+var LenientHeaderWrite = false
+
 var headerNewlineToSpace = strings.NewReplacer("\n", " ", "\r", " ")
 
+func validHeaderKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if !isToken(k[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func stripHeaderKey(k string) string {
+	b := make([]byte, 0, len(k))
+	for i := 0; i < len(k); i++ {
+		if isToken(k[i]) {
+			b = append(b, k[i])
+		}
+	}
+	return string(b)
+}
+
 // WriteSubset writes a header in wire format.
 // If exclude is not nil, keys where exclude[key] == true are not written.
+// Unless LenientHeaderWrite is set, a key that is not a token fails the
+// write with ErrHeaderKey, and a value containing CR or LF with
+// ErrHeaderValue, before anything is written.
 func (h Header) WriteSubset(w io.Writer, exclude map[string]bool) os.Error {
+	lenient := LenientHeaderWrite
 	keys := make([]string, 0, len(h))
 	for k := range h {
-		if exclude == nil || !exclude[k] {
-			keys = append(keys, k)
+		if exclude != nil && exclude[k] {
+			continue
+		}
+		if !lenient {
+			if !validHeaderKey(k) {
+				return ErrHeaderKey
+			}
+			for _, v := range h[k] {
+				if strings.IndexAny(v, "\r\n") >= 0 {
+					return ErrHeaderValue
+				}
+			}
 		}
+		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
+		key := k
+		if lenient {
+			if key = stripHeaderKey(k); key == "" {
+				continue
+			}
+		}
 		for _, v := range h[k] {
 			v = headerNewlineToSpace.Replace(v)
 			v = strings.TrimSpace(v)
-			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", key, v); err != nil {
 				return err
 			}
 		}
@@ -6,6 +6,8 @@ package http
 
 import (
 	"bytes"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -79,3 +81,119 @@ func TestHeaderWrite(t *testing.T) {
 		buf.Reset()
 	}
 }
+
+var ifNoneMatchTests = []struct {
+	h     Header
+	etag  string
+	match bool
+}{
+	{Header{}, `"a"`, false},
+	{Header{"If-None-Match": {`"a"`}}, `"a"`, true},
+	{Header{"If-None-Match": {`"b", "a"`}}, `"a"`, true},
+	{Header{"If-None-Match": {`W/"a"`}}, `"a"`, true},
+	{Header{"If-None-Match": {`"a"`}}, `W/"a"`, true},
+	{Header{"If-None-Match": {`"b"`}}, `"a"`, false},
+	{Header{"If-None-Match": {"*"}}, `"a"`, true},
+	{Header{"If-None-Match": {"*"}}, "", false},
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	for i, tt := range ifNoneMatchTests {
+		if m := IfNoneMatch(tt.h, tt.etag); m != tt.match {
+			t.Errorf("#%d: IfNoneMatch(%v, %q) = %v, want %v", i, tt.h, tt.etag, m, tt.match)
+		}
+	}
+}
+
+func TestHeaderMethods(t *testing.T) {
+	h := make(Header)
+	h.Add("content-type", "text/plain")
+	h.Add("X-Multi", "a")
+	h.Add("x-multi", "b")
+	if got := h.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Get: got %q", got)
+	}
+	if got := h.Values("X-MULTI"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Values: got %q", got)
+	}
+	h.Set("x-multi", "c")
+	if got := h["X-Multi"]; len(got) != 1 || got[0] != "c" {
+		t.Errorf("after Set: got %q", got)
+	}
+	h.Del("CONTENT-TYPE")
+	if _, ok := h["Content-Type"]; ok {
+		t.Errorf("Del left the key in place")
+	}
+
+	var nilh Header
+	if nilh.Get("A") != "" || nilh.Values("A") != nil || nilh.Clone() != nil {
+		t.Errorf("nil Header does not read as empty")
+	}
+	nilh.Del("A")
+	defer func() {
+		if e, _ := recover().(string); e != "http: Set on nil Header" {
+			t.Errorf("Set on nil Header: got panic %q", e)
+		}
+	}()
+	nilh.Set("A", "1")
+}
+
+func TestHeaderClone(t *testing.T) {
+	h := Header{"A": {"1", "2"}, "B": {}}
+	c := h.Clone()
+	if len(c) != 2 || len(c["A"]) != 2 || c["B"] == nil || len(c["B"]) != 0 {
+		t.Fatalf("clone: got %v", c)
+	}
+	c["A"][0] = "x"
+	c.Add("A", "3")
+	if h["A"][0] != "1" || len(h["A"]) != 2 {
+		t.Errorf("changing the clone changed the original: %v", h)
+	}
+}
+
+func TestHeaderWriteInvalid(t *testing.T) {
+	defer func() { LenientHeaderWrite = false }()
+	for c := 0; c < 128; c++ {
+		b := string(byte(c))
+		for _, tt := range []struct {
+			h   Header
+			err os.Error
+		}{
+			{Header{"X" + b + "Y": {"v"}}, ErrHeaderKey},
+			{Header{"X-Key": {"a" + b + "b"}}, ErrHeaderValue},
+		} {
+			want := tt.err
+			if tt.err == ErrHeaderKey && isToken(byte(c)) ||
+				tt.err == ErrHeaderValue && c != '\r' && c != '\n' {
+				want = nil
+			}
+			var buf bytes.Buffer
+			LenientHeaderWrite = false
+			if err := tt.h.Write(&buf); err != want {
+				t.Errorf("%q: got error %v, want %v", tt.h, err, want)
+			}
+			if want != nil && buf.Len() > 0 {
+				t.Errorf("%q: wrote %q before failing", tt.h, buf.String())
+			}
+			buf.Reset()
+			LenientHeaderWrite = true
+			if err := tt.h.Write(&buf); err != nil {
+				t.Errorf("%q: lenient write failed: %s", tt.h, err)
+			}
+			lines := strings.Split(buf.String(), "\r\n")
+			if len(lines) != 2 || lines[1] != "" || strings.IndexAny(lines[0], "\r\n") >= 0 {
+				t.Errorf("%q: lenient write gave %q", tt.h, buf.String())
+			}
+		}
+	}
+
+	LenientHeaderWrite = false
+	var buf bytes.Buffer
+	if err := (Header{"": {"v"}}).Write(&buf); err != ErrHeaderKey {
+		t.Errorf("empty key: got error %v, want ErrHeaderKey", err)
+	}
+	LenientHeaderWrite = true
+	if err := (Header{"\r\n": {"v"}, "A": {"1"}}).Write(&buf); err != nil || buf.String() != "A: 1\r\n" {
+		t.Errorf("key stripped to nothing: got %q, %v", buf.String(), err)
+	}
+}
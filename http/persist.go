@@ -31,6 +31,8 @@ type ServerConn struct {
 	lk              sync.Mutex // read-write protects the following fields
 	c               net.Conn
 	r               *bufio.Reader
+	lr              *io.LimitedReader // Bounds header reads, if r was allocated by NewServerConn
+	maxHeader       int
 	re, we          os.Error // read/write errors
 	lastbody        io.ReadCloser
 	nread, nwritten int
@@ -42,10 +44,25 @@ type ServerConn struct {
 // NewServerConn returns a new ServerConn reading and writing c.  If r is not
 // nil, it is the buffer to use when reading c.
 func NewServerConn(c net.Conn, r *bufio.Reader) *ServerConn {
+	var lr *io.LimitedReader
 	if r == nil {
-		r = bufio.NewReader(c)
+		lr = io.LimitReader(c, noLimit).(*io.LimitedReader)
+		r = bufio.NewReader(lr)
 	}
-	return &ServerConn{c: c, r: r, pipereq: make(map[*Request]uint)}
+	return &ServerConn{c: c, r: r, lr: lr, pipereq: make(map[*Request]uint)}
+}
+
+// SetMaxHeaderBytes limits the size of request headers read by Read to
+// roughly n bytes. The limit is enforced while the header is read, so an
+// oversized header is never buffered in full. Requests with larger headers
+// are answered with a 431 status, once the responses to earlier requests
+// have been written, and Read fails with ErrHeaderTooLong. A non-positive n
+// removes the limit. The limit is only enforced if the ServerConn allocated
+// its own read buffer.
+func (sc *ServerConn) SetMaxHeaderBytes(n int) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	sc.maxHeader = n
 }
 
 // Hijack detaches the ServerConn and returns the underlying connection as well
@@ -73,8 +90,10 @@ func (sc *ServerConn) Close() os.Error {
 
 // Read returns the next request on the wire. An ErrPersistEOF is returned if
 // it is gracefully determined that there are no more requests (e.g. after the
-// first request on an HTTP/1.0 connection, or after a Connection:close on a
-// HTTP/1.1 connection).
+// first request on an HTTP/1.0 connection, after a Connection:close on a
+// HTTP/1.1 connection, or when the client closes the connection between
+// requests). A client closing the connection in the middle of a request
+// yields io.ErrUnexpectedEOF.
 func (sc *ServerConn) Read() (req *Request, err os.Error) {
 
 	// Ensure ordered execution of Reads and Writes
@@ -84,6 +103,9 @@ func (sc *ServerConn) Read() (req *Request, err os.Error) {
 		sc.pipe.EndRequest(id)
 		if req == nil {
 			sc.pipe.StartResponse(id)
+			if err == ErrHeaderTooLong {
+				sc.writeRaw(response431)
+			}
 			sc.pipe.EndResponse(id)
 		} else {
 			// Remember the pipeline id of this request
@@ -107,6 +129,7 @@ func (sc *ServerConn) Read() (req *Request, err os.Error) {
 		return nil, os.EBADF
 	}
 	r := sc.r
+	lr, maxHeader := sc.lr, sc.maxHeader
 	lastbody := sc.lastbody
 	sc.lastbody = nil
 	sc.lk.Unlock()
@@ -125,20 +148,32 @@ func (sc *ServerConn) Read() (req *Request, err os.Error) {
 		}
 	}
 
+	// A client that closes the connection before sending any byte of
+	// another request is closing it gracefully
+	if _, err = r.Peek(1); err == os.EOF {
+		sc.lk.Lock()
+		defer sc.lk.Unlock()
+		sc.re = ErrPersistEOF
+		return nil, sc.re
+	}
+
+	if lr != nil && maxHeader > 0 {
+		lr.N = int64(maxHeader) + 4096 /* bufio slop */
+	}
 	req, err = ReadRequest(r)
+	if lr != nil && maxHeader > 0 {
+		if lr.N == 0 {
+			req, err = nil, ErrHeaderTooLong
+		}
+		lr.N = noLimit
+	}
 	sc.lk.Lock()
 	defer sc.lk.Unlock()
 	if err != nil {
-		if err == io.ErrUnexpectedEOF {
-			// A close from the opposing client is treated as a
-			// graceful close, even if there was some unparse-able
-			// data before the close.
-			sc.re = ErrPersistEOF
-			return nil, sc.re
-		} else {
-			sc.re = err
-			return req, err
-		}
+		// Since the request had begun, an io.ErrUnexpectedEOF here
+		// means that the client closed the connection part way through it
+		sc.re = err
+		return req, err
 	}
 	sc.lastbody = req.Body
 	sc.nread++
@@ -149,6 +184,42 @@ func (sc *ServerConn) Read() (req *Request, err os.Error) {
 	return req, err
 }
 
+const response431 = "HTTP/1.1 431 Request Header Fields Too Large\r\n" +
+	"Content-Length: 0\r\n" +
+	"Connection: close\r\n\r\n"
+
+// writeRaw writes s to the connection, unless its write side has failed.
+func (sc *ServerConn) writeRaw(s string) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	if sc.we != nil || sc.c == nil {
+		return
+	}
+	if _, err := io.WriteString(sc.c, s); err != nil {
+		sc.we = err
+	}
+}
+
+// WriteContinue sends an interim "100 Continue" response, inviting the
+// client to send the body of a request carrying "Expect: 100-continue".
+// It should not be used while responses to earlier pipelined requests
+// are still being written.
+func (sc *ServerConn) WriteContinue() os.Error {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	if sc.we != nil {
+		return sc.we
+	}
+	if sc.c == nil { // connection closed by user in the meantime
+		return os.EBADF
+	}
+	_, err := io.WriteString(sc.c, "HTTP/1.1 100 Continue\r\n\r\n")
+	if err != nil {
+		sc.we = err
+	}
+	return err
+}
+
 // Pending returns the number of unanswered requests
 // that have been received on the connection.
 func (sc *ServerConn) Pending() int {
@@ -4,6 +4,10 @@
 
 package http
 
+import (
+	"strconv"
+)
+
 func NewResponse200(req *Request) *Response {
 	return &Response{
 		Status:        "OK",
@@ -34,6 +38,38 @@ func NewResponse200Bytes(req *Request, b []byte) *Response {
 	}
 }
 
+func NewResponse204(req *Request) *Response {
+	return &Response{
+		Status:        "No Content",
+		StatusCode:    204,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Close:         false,
+		ContentLength: 0,
+	}
+}
+
+// NewResponse304 returns a Not Modified response carrying etag, if non-empty.
+func NewResponse304(req *Request, etag string) *Response {
+	resp := &Response{
+		Status:        "Not Modified",
+		StatusCode:    304,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Close:         false,
+		ContentLength: 0,
+		Header:        make(Header),
+	}
+	if etag != "" {
+		resp.Header.Set("Etag", etag)
+	}
+	return resp
+}
+
 func NewResponse200CONNECT(req *Request) *Response {
 	return &Response{
 		Status:        "Connection Established",
@@ -107,6 +143,28 @@ func NewResponse400(req *Request) *Response {
 	}
 }
 
+// NewResponse408 returns a 408 response to req, which closes the connection,
+// since the request body was not received in full.
+func NewResponse408(req *Request) *Response {
+	html := "<html>" +
+		"<head><title>408 Request Timeout</title></head>\n" +
+		"<body bgcolor=\"white\">\n" +
+		"<center><h1>408 Request Timeout</h1></center>\n" +
+		"<hr><center>Go HTTP package</center>\n" +
+		"</body></html>"
+	return &Response{
+		Status:        "Request Timeout",
+		StatusCode:    408,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Body:          NewBodyString(html),
+		ContentLength: int64(len(html)),
+		Close:         true,
+	}
+}
+
 func NewResponse400String(req *Request, body string) *Response {
 	return &Response{
 		Status:        "Bad Request",
@@ -121,6 +179,47 @@ func NewResponse400String(req *Request, body string) *Response {
 	}
 }
 
+func NewResponse401(req *Request, realm string) *Response {
+	html := "<html>" +
+		"<head><title>401 Unauthorized</title></head>\n" +
+		"<body bgcolor=\"white\">\n" +
+		"<center><h1>401 Unauthorized</h1></center>\n" +
+		"<hr><center>Go HTTP package</center>\n" +
+		"</body></html>"
+	return &Response{
+		Status:        "Unauthorized",
+		StatusCode:    401,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Body:          NewBodyString(html),
+		ContentLength: int64(len(html)),
+		Close:         false,
+		Header:        Header{"Www-Authenticate": []string{"Basic realm=" + strconv.Quote(realm)}},
+	}
+}
+
+func NewResponse403(req *Request) *Response {
+	html := "<html>" +
+		"<head><title>403 Forbidden</title></head>\n" +
+		"<body bgcolor=\"white\">\n" +
+		"<center><h1>403 Forbidden</h1></center>\n" +
+		"<hr><center>Go HTTP package</center>\n" +
+		"</body></html>"
+	return &Response{
+		Status:        "Forbidden",
+		StatusCode:    403,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Body:          NewBodyString(html),
+		ContentLength: int64(len(html)),
+		Close:         false,
+	}
+}
+
 func NewResponse404(req *Request) *Response {
 	html := "<html>" +
 		"<head><title>404 Not found</title></head>\n" +
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"github.com/petar/GoGauge/unclosed"
 )
 
@@ -79,3 +80,29 @@ func DupReq(req *Request) (r2 *Request, err os.Error) {
 	}
 	return &tmp, err
 }
+
+// IfNoneMatch reports whether the If-None-Match header of h matches etag,
+// in which case the client's cached copy is current. Entity tags are
+// compared weakly, ignoring any W/ prefix, as RFC 2616 requires for GET and HEAD.
+func IfNoneMatch(h Header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strongETag(etag)
+	for _, v := range h["If-None-Match"] {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strongETag(t) == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func strongETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag[2:]
+	}
+	return etag
+}
@@ -7,11 +7,9 @@ package http
 import (
 	"os"
 	"testing"
+	"url"
 )
 
-// TODO(mattn):
-//	test ProxyAuth
-
 var UseProxyTests = []struct {
 	host  string
 	match bool
@@ -46,3 +44,20 @@ func TestUseProxy(t *testing.T) {
 		}
 	}
 }
+
+var proxyAuthTests = []struct {
+	userinfo, auth string
+}{
+	{"", ""},
+	{"user:pass", "Basic dXNlcjpwYXNz"},
+	{"user:>>>?", "Basic dXNlcjo+Pj4/"},
+}
+
+func TestProxyAuth(t *testing.T) {
+	for _, test := range proxyAuthTests {
+		cm := &connectMethod{proxyURL: &url.URL{Scheme: "http", Host: "proxy:8080", RawUserinfo: test.userinfo}}
+		if g := cm.proxyAuth(); g != test.auth {
+			t.Errorf("proxyAuth with userinfo %q = %q, want %q", test.userinfo, g, test.auth)
+		}
+	}
+}
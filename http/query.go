@@ -0,0 +1,107 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"url"
+)
+
+// Values maps a string key to a list of values, as found in a URL query or
+// an application/x-www-form-urlencoded body. Unlike in a Header, keys are
+// case-sensitive. Values converts to and from url.Values, the type of
+// Request.Form, whose Encode does not order keys.
+type Values map[string][]string
+
+// Get gets the first value associated with the given key.
+// If there are no values associated with the key, Get returns "".
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Set sets the key to the single element value.
+// It replaces any existing values.
+func (v Values) Set(key, value string) {
+	v[key] = []string{value}
+}
+
+// Add adds the value to key. It appends to any existing values
+// associated with key.
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Del deletes the values associated with key.
+func (v Values) Del(key string) {
+	v[key] = nil, false
+}
+
+// Encode encodes the values in URL-encoded form, such as "a=1&b=x+y".
+// Keys are written in sorted order, and the values of a repeated key in
+// the order in which they were added.
+func (v Values) Encode() string {
+	if len(v) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		prefix := url.QueryEscape(k) + "="
+		for _, val := range v[k] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(prefix)
+			buf.WriteString(url.QueryEscape(val))
+		}
+	}
+	return buf.String()
+}
+
+// ParseQuery parses the URL-encoded query string s, whose pairs are
+// separated by '&' or ';'. A '+' decodes as a space, and the values of a
+// repeated key are kept in order. A pair with a malformed %-sequence is
+// skipped; the returned Values always hold the remaining pairs, and err
+// describes the first malformed one, if any.
+func ParseQuery(s string) (v Values, err os.Error) {
+	v = make(Values)
+	for s != "" {
+		pair := s
+		if i := strings.IndexAny(pair, "&;"); i >= 0 {
+			pair, s = pair[:i], pair[i+1:]
+		} else {
+			s = ""
+		}
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		key, e := url.QueryUnescape(key)
+		if e == nil {
+			value, e = url.QueryUnescape(value)
+		}
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		v[key] = append(v[key], value)
+	}
+	return v, err
+}
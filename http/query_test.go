@@ -0,0 +1,79 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"reflect"
+	"testing"
+)
+
+var encodeQueryTests = []struct {
+	v   Values
+	out string
+}{
+	{nil, ""},
+	{Values{"q": {"foo bar"}}, "q=foo+bar"},
+	{Values{"b": {"2"}, "a": {"1"}}, "a=1&b=2"},
+	{Values{"a": {"x&y=z", "1+1"}}, "a=x%26y%3Dz&a=1%2B1"},
+	{Values{"a b": {""}, "c": {}}, "a+b="},
+}
+
+func TestValuesEncode(t *testing.T) {
+	for i, tt := range encodeQueryTests {
+		if out := tt.v.Encode(); out != tt.out {
+			t.Errorf("#%d: got %q, want %q", i, out, tt.out)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	v := make(Values)
+	v.Set("a", "1")
+	v.Add("a", "2")
+	v.Add("b", "3")
+	if got := v.Get("a"); got != "1" {
+		t.Errorf("Get(a): got %q, want %q", got, "1")
+	}
+	v.Set("b", "4")
+	if got := v["b"]; !reflect.DeepEqual(got, []string{"4"}) {
+		t.Errorf("after Set, b: got %q", got)
+	}
+	v.Del("a")
+	if got := v.Get("a"); got != "" {
+		t.Errorf("after Del, Get(a): got %q, want empty", got)
+	}
+	if _, ok := v["a"]; ok {
+		t.Errorf("after Del, a still present")
+	}
+}
+
+var parseQueryTests = []struct {
+	in  string
+	out Values
+	ok  bool
+}{
+	{"", Values{}, true},
+	{"a=1&b=x+y", Values{"a": {"1"}, "b": {"x y"}}, true},
+	{"a=1;a=2&&a", Values{"a": {"1", "2", ""}}, true},
+	{"q=%26%3D%2B", Values{"q": {"&=+"}}, true},
+	{"a=%zz&b=2&c%=3&d=4", Values{"b": {"2"}, "d": {"4"}}, false},
+}
+
+func TestParseQuery(t *testing.T) {
+	for i, tt := range parseQueryTests {
+		v, err := ParseQuery(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d %q: err = %v, want ok = %v", i, tt.in, err, tt.ok)
+		}
+		if !reflect.DeepEqual(v, tt.out) {
+			t.Errorf("#%d %q: got %v, want %v", i, tt.in, v, tt.out)
+		}
+	}
+	// Encode and ParseQuery round-trip
+	v := Values{"x": {"a b", "c&d"}, "y=": {"%"}}
+	if got, _ := ParseQuery(v.Encode()); !reflect.DeepEqual(got, v) {
+		t.Errorf("round-trip: got %v, want %v", got, v)
+	}
+}
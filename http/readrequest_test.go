@@ -178,3 +178,50 @@ func TestReadRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestReadRequestTrailer(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\n" +
+		"Host: foo.com\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: Content-MD5, X-Count\r\n\r\n" +
+		"3\r\nfoo\r\n3\r\nbar\r\n0\r\n" +
+		"Content-MD5: 3858f62230ac3c915f300c664312c63f\r\n" +
+		"X-Count: 2\r\n\r\n" +
+		"GET /next HTTP/1.1\r\nHost: foo.com\r\n\r\n"
+	br := bufio.NewReader(bytes.NewBufferString(raw))
+	req, err := ReadRequest(br)
+	if err != nil {
+		t.Fatalf("read request: %s", err)
+	}
+	if _, ok := req.Header["Trailer"]; ok {
+		t.Errorf("Trailer left in header: %q", req.Header["Trailer"])
+	}
+	if _, err := req.ReadTrailer(); err != ErrTrailerUnread {
+		t.Errorf("trailer before body: got error %v, want ErrTrailerUnread", err)
+	}
+	var body bytes.Buffer
+	io.Copy(&body, req.Body)
+	if body.String() != "foobar" {
+		t.Errorf("body: got %q, want %q", body.String(), "foobar")
+	}
+	trailer, err := req.ReadTrailer()
+	if err != nil {
+		t.Fatalf("trailer after body: %s", err)
+	}
+	if g := trailer.Get("Content-Md5"); g != "3858f62230ac3c915f300c664312c63f" {
+		t.Errorf("Content-MD5: got %q", g)
+	}
+	if g := trailer.Get("X-Count"); g != "2" {
+		t.Errorf("X-Count: got %q", g)
+	}
+	req.Body.Close()
+
+	// The trailer must be consumed for the next request to be framed correctly
+	next, err := ReadRequest(br)
+	if err != nil {
+		t.Fatalf("read next request: %s", err)
+	}
+	if next.URL.Path != "/next" {
+		t.Errorf("next request: got path %q, want /next", next.URL.Path)
+	}
+}
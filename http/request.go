@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"mime"
 	"mime/multipart"
 	"net/textproto"
@@ -139,9 +140,12 @@ type Request struct {
 
 	// Trailer maps trailer keys to values.  Like for Header, if the
 	// response has multiple trailer lines with the same key, they will be
-	// concatenated, delimited by commas.
+	// concatenated, delimited by commas. For a chunked body, the values
+	// are filled in once Body has been read to EOF; see ReadTrailer.
 	Trailer Header
 
+	trailerRead bool // whether the chunked body was read to EOF
+
 	// RemoteAddr allows HTTP servers and other software to record
 	// the network address that sent the request, usually for
 	// logging. This field is not filled in by ReadRequest and
@@ -157,6 +161,25 @@ type Request struct {
 	// TLS-enabled connections before invoking a handler;
 	// otherwise it leaves the field nil.
 	TLS *tls.ConnectionState
+
+	// Timeout, if positive, bounds in nanoseconds the time a client
+	// request may take, from writing it to the end of the response
+	// body. A request that runs out of time fails with
+	// ErrRequestTimeout, and so do later reads of its response body.
+	// Timeout and Cancel are ignored by the server.
+	Timeout int64
+
+	// Cancel, if not nil, abandons a client request in flight when it
+	// is closed or receives a value. The request then fails with
+	// ErrCanceled, and so do later reads of its response body.
+	Cancel <-chan bool
+}
+
+// ReadTrailer returns the trailer of the request. For a request with a
+// chunked body, the trailer follows the body on the wire, and ReadTrailer
+// returns ErrTrailerUnread until Body has been read to EOF.
+func (r *Request) ReadTrailer() (Header, os.Error) {
+	return readTrailer(r.Trailer, r.TransferEncoding, r.Body, r.trailerRead)
 }
 
 // ProtoAtLeast returns whether the HTTP protocol used
@@ -181,17 +204,29 @@ var ErrNoCookie = os.NewError("http: named cookied not present")
 // Cookie returns the named cookie provided in the request or
 // ErrNoCookie if not found.
 func (r *Request) Cookie(name string) (*Cookie, os.Error) {
-	for _, c := range readCookies(r.Header, name) {
-		return c, nil
-	}
-	return nil, ErrNoCookie
+	return ReadCookie(r.Header, name)
 }
 
 // AddCookie adds a cookie to the request.  Per RFC 6265 section 5.4,
 // AddCookie does not attach more than one Cookie header field.  That
 // means all cookies, if any, are written into the same line,
-// separated by semicolon.
+// separated by semicolon. Cookies with an invalid name or value are
+// dropped, unless LenientHeaderWrite is set.
+//
+// Only the Name and Value of c are sent. Attributes that belong in a
+// Set-Cookie header, such as Expires, MaxAge, Secure or SameSite, have no
+// place in a request; AddCookie logs the cookies that carry them, which
+// usually means a response cookie was mistaken for a request one. Path and
+// Domain, which readCookies may set from $Path and $Domain, pass silently.
 func (r *Request) AddCookie(c *Cookie) {
+	if attrs := c.responseAttrs(); len(attrs) > 0 {
+		log.Printf("http: AddCookie ignores the Set-Cookie attributes %s of cookie %q", strings.Join(attrs, ", "), c.Name)
+	}
+	if !LenientHeaderWrite {
+		if _, ok := parseCookieValue(c.Value); !ok || c.Name == "" || !isCookieNameValid(c.Name) {
+			return
+		}
+	}
 	s := fmt.Sprintf("%s=%s", sanitizeName(c.Name), sanitizeValue(c.Value))
 	if c := r.Header.Get("Cookie"); c != "" {
 		r.Header.Set("Cookie", c+"; "+s)
@@ -363,9 +398,6 @@ func (req *Request) write(w io.Writer, usingProxy bool) os.Error {
 	bw := bufio.NewWriter(w)
 	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", valueOrDefault(req.Method, "GET"), urlStr)
 
-	// Header lines
-	fmt.Fprintf(bw, "Host: %s\r\n", host)
-
 	// Use the defaultUserAgent unless the Header contains one, which
 	// may be blank to not send the header.
 	userAgent := defaultUserAgent
@@ -374,6 +406,17 @@ func (req *Request) write(w io.Writer, usingProxy bool) os.Error {
 			userAgent = ua[0]
 		}
 	}
+	// Host and User-Agent are written here, not by WriteSubset, but are
+	// held to the same rules. Nothing has been flushed if they fail.
+	if LenientHeaderWrite {
+		host = headerNewlineToSpace.Replace(host)
+		userAgent = headerNewlineToSpace.Replace(userAgent)
+	} else if strings.IndexAny(host+userAgent, "\r\n") >= 0 {
+		return ErrHeaderValue
+	}
+
+	// Header lines
+	fmt.Fprintf(bw, "Host: %s\r\n", host)
 	if userAgent != "" {
 		fmt.Fprintf(bw, "User-Agent: %s\r\n", userAgent)
 	}
@@ -479,9 +522,10 @@ func ParseHTTPVersion(vers string) (major, minor int, ok bool) {
 }
 
 type chunkedReader struct {
-	r   *bufio.Reader
-	n   uint64 // unread bytes in chunk
-	err os.Error
+	r       *bufio.Reader
+	n       uint64 // unread bytes in chunk
+	err     os.Error
+	trailer Header // trailer following the last chunk
 }
 
 func (cr *chunkedReader) beginChunk() {
@@ -505,11 +549,30 @@ func (cr *chunkedReader) beginChunk() {
 			if line == "" {
 				break
 			}
+			cr.addTrailer(line)
 		}
 		cr.err = os.EOF
 	}
 }
 
+// addTrailer records a trailer line. Malformed lines, and keys that
+// may not appear in a trailer, are ignored.
+func (cr *chunkedReader) addTrailer(line string) {
+	i := strings.Index(line, ":")
+	if i <= 0 {
+		return
+	}
+	key := CanonicalHeaderKey(strings.TrimSpace(line[:i]))
+	switch key {
+	case "Transfer-Encoding", "Trailer", "Content-Length":
+		return
+	}
+	if cr.trailer == nil {
+		cr.trailer = make(Header)
+	}
+	cr.trailer.Add(key, strings.TrimSpace(line[i+1:]))
+}
+
 func (cr *chunkedReader) Read(b []uint8) (n int, err os.Error) {
 	if cr.err != nil {
 		return 0, cr.err
@@ -575,8 +638,58 @@ func NewRequest(method, urlStr string, body io.Reader) (*Request, os.Error) {
 // With HTTP Basic Authentication the provided username and password
 // are not encrypted.
 func (r *Request) SetBasicAuth(username, password string) {
+	SetBasicAuth(r.Header, username, password)
+}
+
+// SetBasicAuth sets the Authorization header in h to use HTTP Basic
+// Authentication with the provided username and password.
+func SetBasicAuth(h Header, username, password string) {
 	s := username + ":" + password
-	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s)))
+	h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+// ParseBasicAuth returns the username and password provided in the
+// Authorization header in h, if it uses HTTP Basic Authentication.
+// The password extends from the first colon to the end, and so may itself
+// contain colons. A missing or malformed header yields ok == false.
+//
+// Servers should compare the credentials against the expected ones in
+// constant time, for instance with crypto/subtle's ConstantTimeCompare,
+// so as not to reveal how much of a guess was correct.
+func ParseBasicAuth(h Header) (username, password string, ok bool) {
+	cred, ok := authCredentials(h, "basic")
+	if !ok {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(cred)
+	if err != nil {
+		return "", "", false
+	}
+	s := string(b)
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// ParseBearerToken returns the token provided in the Authorization header
+// in h, if it uses the Bearer scheme of RFC 6750. As with ParseBasicAuth,
+// tokens should be compared in constant time.
+func ParseBearerToken(h Header) (token string, ok bool) {
+	return authCredentials(h, "bearer")
+}
+
+// authCredentials returns the non-empty credentials that follow the
+// given scheme, which is matched case-insensitively, in the Authorization
+// header in h.
+func authCredentials(h Header, scheme string) (string, bool) {
+	auth := h.Get("Authorization")
+	if len(auth) <= len(scheme) || strings.ToLower(auth[:len(scheme)]) != scheme || auth[len(scheme)] != ' ' {
+		return "", false
+	}
+	cred := strings.TrimSpace(auth[len(scheme)+1:])
+	return cred, cred != ""
 }
 
 // ReadRequest reads and parses a request from b.
@@ -706,7 +819,7 @@ func (l *maxBytesReader) Close() os.Error {
 	return l.r.Close()
 }
 
-// ParseForm parses the raw query from the URL.
+// ParseForm parses the raw query from the URL with ParseQuery.
 //
 // For POST or PUT requests, it also parses the request body as a form.
 // If the request Body's size has not already been limited by MaxBytesReader,
@@ -719,7 +832,9 @@ func (r *Request) ParseForm() (err os.Error) {
 		return
 	}
 	if r.URL != nil {
-		r.Form, err = url.ParseQuery(r.URL.RawQuery)
+		var v Values
+		v, err = ParseQuery(r.URL.RawQuery)
+		r.Form = url.Values(v)
 	}
 	if r.Method == "POST" || r.Method == "PUT" {
 		if r.Body == nil {
@@ -745,8 +860,8 @@ func (r *Request) ParseForm() (err os.Error) {
 			if int64(len(b)) > maxFormSize {
 				return os.NewError("http: POST too large")
 			}
-			var newValues url.Values
-			newValues, e = url.ParseQuery(string(b))
+			var newValues Values
+			newValues, e = ParseQuery(string(b))
 			if err == nil {
 				err = e
 			}
@@ -46,6 +46,22 @@ func TestPostQuery(t *testing.T) {
 	}
 }
 
+func TestParseFormMalformedPair(t *testing.T) {
+	req := &Request{Method: "POST"}
+	req.URL, _ = url.Parse("http://www.google.com/search?q=%zz&a=1")
+	req.Header = Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	}
+	req.Body = ioutil.NopCloser(strings.NewReader("b=%&c=x+y"))
+	if err := req.ParseForm(); err == nil {
+		t.Errorf("ParseForm: expected an error for the malformed pairs")
+	}
+	want := url.Values{"a": {"1"}, "c": {"x y"}}
+	if !reflect.DeepEqual(req.Form, want) {
+		t.Errorf("Form: got %v, want %v", req.Form, want)
+	}
+}
+
 type stringMap map[string][]string
 type parseContentTypeTest struct {
 	contentType stringMap
@@ -131,6 +147,72 @@ func TestSetBasicAuth(t *testing.T) {
 	}
 }
 
+var parseBasicAuthTests = []struct {
+	Header     string
+	User, Pass string
+	Ok         bool
+}{
+	{"Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "Aladdin", "open sesame", true},
+	{"basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "Aladdin", "open sesame", true},
+	{"Basic QWxhZGRpbjo=", "Aladdin", "", true},
+	{"Basic QWxhZGRpbg==", "", "", false},  // no colon
+	{"Basic !!!", "", "", false},           // bad base64
+	{"Digest QWxhZGRpbjo=", "", "", false}, // other scheme
+	{"Basic", "", "", false},
+	{"Basic dTpwOnE6cg==", "u", "p:q:r", true},                   // colons in password
+	{"Basic w7xzZXI6cMOkc3N3w7ZydA==", "üser", "pässwört", true}, // UTF-8
+	{"", "", "", false},
+}
+
+func TestParseBasicAuth(t *testing.T) {
+	for i, tt := range parseBasicAuthTests {
+		r, _ := NewRequest("GET", "http://example.com/", nil)
+		if tt.Header != "" {
+			r.Header.Set("Authorization", tt.Header)
+		}
+		user, pass, ok := ParseBasicAuth(r.Header)
+		if user != tt.User || pass != tt.Pass || ok != tt.Ok {
+			t.Errorf("#%d: got (%q, %q, %v), want (%q, %q, %v)", i, user, pass, ok, tt.User, tt.Pass, tt.Ok)
+		}
+	}
+}
+
+func TestBasicAuthRoundTrip(t *testing.T) {
+	h := make(Header)
+	SetBasicAuth(h, "用户", "密:码")
+	user, pass, ok := ParseBasicAuth(h)
+	if !ok || user != "用户" || pass != "密:码" {
+		t.Errorf("got (%q, %q, %v)", user, pass, ok)
+	}
+}
+
+var parseBearerTokenTests = []struct {
+	Header string
+	Token  string
+	Ok     bool
+}{
+	{"Bearer mF_9.B5f-4.1JqM", "mF_9.B5f-4.1JqM", true},
+	{"bearer  abc ", "abc", true},
+	{"Bearer", "", false},
+	{"Bearer ", "", false},
+	{"Bearerabc", "", false},
+	{"Basic QWxhZGRpbjo=", "", false},
+	{"", "", false},
+}
+
+func TestParseBearerToken(t *testing.T) {
+	for i, tt := range parseBearerTokenTests {
+		h := make(Header)
+		if tt.Header != "" {
+			h.Set("Authorization", tt.Header)
+		}
+		token, ok := ParseBearerToken(h)
+		if token != tt.Token || ok != tt.Ok {
+			t.Errorf("#%d: got (%q, %v), want (%q, %v)", i, token, ok, tt.Token, tt.Ok)
+		}
+	}
+}
+
 func TestMultipartRequest(t *testing.T) {
 	// Test that we can read the values and files of a 
 	// multipart request with FormValue and FormFile,
@@ -468,3 +468,20 @@ func mustParseURL(s string) *url.URL {
 	}
 	return u
 }
+
+func TestRequestWriteHeaderInjection(t *testing.T) {
+	for _, h := range []Header{
+		{"User-Agent": {"Go\r\nX-Injected: 1"}},
+		{"X-Other": {"a\nX-Injected: 1"}},
+	} {
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		req.Header = h
+		var buf bytes.Buffer
+		if err := req.Write(&buf); err != ErrHeaderValue {
+			t.Errorf("%q: got error %v, want ErrHeaderValue", h, err)
+		}
+		if buf.Len() > 0 {
+			t.Errorf("%q: wrote %q", h, buf.String())
+		}
+	}
+}
@@ -64,9 +64,12 @@ type Response struct {
 	Close bool
 
 	// Trailer maps trailer keys to values, in the same
-	// format as the header.
+	// format as the header. For a chunked body, the values
+	// are filled in once Body has been read to EOF.
 	Trailer Header
 
+	trailerRead bool // whether the chunked body was read to EOF
+
 	// The Request that was sent to obtain this Response.
 	// Request's Body is nil (having already been consumed).
 	// This is only populated for Client requests.
@@ -78,6 +81,12 @@ func (r *Response) Cookies() []*Cookie {
 	return readSetCookies(r.Header)
 }
 
+// CookiesVerbose is like Cookies, but also describes the Set-Cookie
+// lines and attributes that failed to parse.
+func (r *Response) CookiesVerbose() ([]*Cookie, []CookieParseError) {
+	return readSetCookiesVerbose(r.Header)
+}
+
 var ErrNoLocation = os.NewError("http: no Location header in response")
 
 // Location returns the URL of the response's "Location" header,
@@ -166,6 +175,12 @@ func fixPragmaCacheControl(header Header) {
 	}
 }
 
+// ReadTrailer returns the trailer of the response. For a response with a
+// chunked body, it returns ErrTrailerUnread until Body has been read to EOF.
+func (r *Response) ReadTrailer() (Header, os.Error) {
+	return readTrailer(r.Trailer, r.TransferEncoding, r.Body, r.trailerRead)
+}
+
 // ProtoAtLeast returns whether the HTTP protocol used
 // in the response is at least major.minor.
 func (r *Response) ProtoAtLeast(major, minor int) bool {
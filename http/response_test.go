@@ -445,3 +445,29 @@ func TestLocationResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestReadResponseTrailer(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\n\r\n" +
+		"6\r\nabcdef\r\n0\r\n" +
+		"X-Checksum: 42\r\n" +
+		"X-Undeclared: 1\r\n\r\n"
+	resp, err := ReadResponse(bufio.NewReader(bytes.NewBufferString(raw)), dummyReq("GET"))
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	if _, err := resp.ReadTrailer(); err != ErrTrailerUnread {
+		t.Errorf("trailer before body: got error %v, want ErrTrailerUnread", err)
+	}
+	// Close reads the remaining body, and the trailer with it
+	resp.Body.Close()
+	trailer, err := resp.ReadTrailer()
+	if err != nil {
+		t.Fatalf("trailer after body: %s", err)
+	}
+	// Undeclared fields are dropped
+	if !reflect.DeepEqual(trailer, Header{"X-Checksum": {"42"}}) {
+		t.Errorf("got trailer %v", trailer)
+	}
+}
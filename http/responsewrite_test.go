@@ -7,6 +7,7 @@ package http
 import (
 	"bytes"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -67,8 +68,7 @@ var respWriteTests = []respWriteTest{
 			"6\r\nabcdef\r\n0\r\n\r\n",
 	},
 
-	// Header value with a newline character (Issue 914).
-	// Also tests removal of leading and trailing whitespace.
+	// Removal of leading and trailing whitespace
 	{
 		Response{
 			StatusCode: 204,
@@ -76,7 +76,7 @@ var respWriteTests = []respWriteTest{
 			ProtoMinor: 1,
 			Request:    dummyReq("GET"),
 			Header: Header{
-				"Foo": []string{" Bar\nBaz "},
+				"Foo": []string{" Bar Baz "},
 			},
 			Body:             nil,
 			ContentLength:    0,
@@ -89,6 +89,27 @@ var respWriteTests = []respWriteTest{
 			"Foo: Bar Baz\r\n" +
 			"\r\n",
 	},
+
+	// HTTP/1.1, chunked coding; trailer after the last chunk
+	{
+		Response{
+			StatusCode:       200,
+			ProtoMajor:       1,
+			ProtoMinor:       1,
+			Request:          dummyReq("GET"),
+			Header:           Header{},
+			Body:             ioutil.NopCloser(bytes.NewBufferString("abcdef")),
+			ContentLength:    -1,
+			TransferEncoding: []string{"chunked"},
+			Trailer:          Header{"Content-Md5": {"e80b5017098950fc58aad83c8c14978e"}},
+		},
+
+		"HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: Content-Md5\r\n\r\n" +
+			"6\r\nabcdef\r\n0\r\n" +
+			"Content-Md5: e80b5017098950fc58aad83c8c14978e\r\n\r\n",
+	},
 }
 
 func TestResponseWrite(t *testing.T) {
@@ -107,3 +128,34 @@ func TestResponseWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestResponseWriteHeaderInjection(t *testing.T) {
+	resp := func(v string) *Response {
+		return &Response{
+			StatusCode:    302,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Request:       dummyReq("GET"),
+			Header:        Header{"Location": {v}},
+			ContentLength: 0,
+		}
+	}
+	var buf bytes.Buffer
+	if err := resp("/x\r\nSet-Cookie: evil=1").Write(&buf); err != ErrHeaderValue {
+		t.Errorf("got error %v, want ErrHeaderValue", err)
+	}
+	if strings.Contains(buf.String(), "evil") {
+		t.Errorf("injected header was written:\n%q", buf.String())
+	}
+
+	// Header value with a newline character (Issue 914), in lenient mode
+	LenientHeaderWrite = true
+	defer func() { LenientHeaderWrite = false }()
+	buf.Reset()
+	if err := resp(" /x\nBaz ").Write(&buf); err != nil {
+		t.Fatalf("lenient write: %s", err)
+	}
+	if !strings.Contains(buf.String(), "\r\nLocation: /x Baz\r\n") {
+		t.Errorf("lenient write: got\n%q", buf.String())
+	}
+}
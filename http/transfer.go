@@ -215,9 +215,13 @@ func (t *transferWriter) WriteBody(w io.Writer) (err os.Error) {
 			t.ContentLength, ncopy)
 	}
 
-	// TODO(petar): Place trailer writer code here.
 	if chunked(t.TransferEncoding) {
-		// Last chunk, empty trailer
+		// Trailer, following the last chunk
+		if t.Trailer != nil {
+			if err = t.Trailer.Write(w); err != nil {
+				return err
+			}
+		}
 		_, err = io.WriteString(w, "\r\n")
 	}
 
@@ -497,7 +501,7 @@ func fixTrailer(header Header, te []string) (Header, os.Error) {
 		case "Transfer-Encoding", "Trailer", "Content-Length":
 			return nil, &badStringError{"bad trailer key", key}
 		}
-		trailer.Del(key)
+		trailer[key] = nil
 	}
 	if len(trailer) == 0 {
 		return nil, nil
@@ -532,7 +536,54 @@ func (b *body) Read(p []byte) (n int, err os.Error) {
 	if b.closed {
 		return 0, ErrBodyReadAfterClose
 	}
-	return b.Reader.Read(p)
+	n, err = b.Reader.Read(p)
+	if err == os.EOF && b.hdr != nil {
+		b.readTrailer()
+		b.hdr = nil
+	}
+	return n, err
+}
+
+// readTrailer moves the trailer, which the chunked reader has read past the
+// last chunk, into the Trailer field of the Request or Response.
+func (b *body) readTrailer() {
+	var trailer Header
+	if cr, ok := b.Reader.(*chunkedReader); ok {
+		trailer = cr.trailer
+	}
+	switch rr := b.hdr.(type) {
+	case *Request:
+		rr.Trailer = mergeTrailer(rr.Trailer, trailer)
+		rr.trailerRead = true
+	case *Response:
+		rr.Trailer = mergeTrailer(rr.Trailer, trailer)
+		rr.trailerRead = true
+	}
+}
+
+// mergeTrailer fills the trailer keys declared in dst with their values in
+// src. Fields that the sender did not declare in its Trailer header are
+// dropped, so that they cannot pose as fields of the header.
+func mergeTrailer(dst, src Header) Header {
+	for k := range dst {
+		if vv, ok := src[k]; ok {
+			dst[k] = vv
+		}
+	}
+	return dst
+}
+
+// ErrTrailerUnread is returned when the trailer of a chunked body is asked
+// for before the body has been read to its end.
+var ErrTrailerUnread = os.NewError("http: trailer requested before body was read to EOF")
+
+// readTrailer returns trailer, unless it is the trailer of a chunked body
+// that has not been read to its end yet.
+func readTrailer(trailer Header, te []string, body io.ReadCloser, read bool) (Header, os.Error) {
+	if body != nil && chunked(te) && !read {
+		return nil, ErrTrailerUnread
+	}
+	return trailer, nil
 }
 
 func (b *body) Close() os.Error {
@@ -557,15 +608,10 @@ func (b *body) Close() os.Error {
 		return nil
 	}
 
+	// Reading to EOF also reads the trailer, if any
 	if _, err := io.Copy(ioutil.Discard, b); err != nil {
 		return err
 	}
 
-	if b.hdr == nil { // not reading trailer
-		return nil
-	}
-
-	// TODO(petar): Put trailer reader code here
-
 	return nil
 }
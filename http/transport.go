@@ -17,6 +17,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 	"url"
 )
 
@@ -27,6 +28,13 @@ import (
 // environment variables.
 var DefaultTransport RoundTripper = &Transport{Proxy: ProxyFromEnvironment}
 
+// Errors of client requests that were abandoned, as directed by the Timeout
+// and Cancel fields of Request. Client methods return them in a *url.Error.
+var (
+	ErrCanceled       = os.NewError("http: request canceled")
+	ErrRequestTimeout = os.NewError("http: request timed out")
+)
+
 // DefaultMaxIdleConnsPerHost is the default value of Transport's
 // MaxIdleConnsPerHost.
 const DefaultMaxIdleConnsPerHost = 2
@@ -34,19 +42,32 @@ const DefaultMaxIdleConnsPerHost = 2
 // Transport is an implementation of RoundTripper that supports http,
 // https, and http proxies (for either http or https with CONNECT).
 // Transport can also cache connections for future re-use.
+//
+// A connection is returned to the cache once the response body has been
+// read to EOF or closed; closing a body reads what is left of it. A
+// response body that is never closed keeps its connection out of the cache
+// for good. A request without a body whose method is idempotent is retried
+// once, on a new connection, if the cached connection it was sent on turns
+// out to have been closed by the server.
 type Transport struct {
 	lk       sync.Mutex
 	idleConn map[string][]*persistConn
 	altProto map[string]RoundTripper // nil or map of URI scheme => RoundTripper
 
 	// TODO: tunable on global max cached connections
-	// TODO: tunable on timeout on cached connections
 	// TODO: optional pipelining
 
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
 	// request is aborted with the provided error.
-	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	// If Proxy is nil or returns a nil *URL, no proxy is used,
+	// which lets Proxy exclude hosts as $NO_PROXY does.
+	//
+	// Plain http requests are sent to the proxy with an absolute
+	// URI in the request line, while https requests are tunneled
+	// through the proxy with CONNECT before the TLS handshake. If
+	// the proxy URL carries userinfo, it is sent to the proxy in a
+	// Proxy-Authorization header, using basic authentication.
 	Proxy func(*Request) (*url.URL, os.Error)
 
 	// Dial specifies the dial function for creating TCP
@@ -65,6 +86,11 @@ type Transport struct {
 	// (keep-alive) to keep to keep per-host.  If zero,
 	// DefaultMaxIdleConnsPerHost is used.
 	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout, if positive, is the time in nanoseconds after
+	// which an idle (keep-alive) connection is no longer re-used. Such
+	// connections are closed when they are next found in the cache.
+	IdleConnTimeout int64
 }
 
 // ProxyFromEnvironment returns the URL of the proxy to use for a
@@ -132,7 +158,34 @@ func (t *Transport) RoundTrip(req *Request) (resp *Response, err os.Error) {
 		return nil, err
 	}
 
-	return pconn.roundTrip(req)
+	resp, err = pconn.roundTrip(req)
+	if de, ok := err.(deadConnError); ok {
+		if !retryable(req) {
+			return nil, de.err
+		}
+		if pconn, err = t.dialConn(cm); err != nil {
+			return nil, err
+		}
+		resp, err = pconn.roundTrip(req)
+		if de, ok := err.(deadConnError); ok {
+			err = de.err
+		}
+	}
+	return resp, err
+}
+
+// retryable reports whether req may be sent again after it failed on a
+// connection that was found dead: its method must be idempotent, and it
+// must have no body, which might have been consumed.
+func retryable(req *Request) bool {
+	if req.Body != nil {
+		return false
+	}
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
 }
 
 // RegisterProtocol registers a new protocol with scheme.
@@ -208,7 +261,7 @@ func (cm *connectMethod) proxyAuth() string {
 	}
 	proxyInfo := cm.proxyURL.RawUserinfo
 	if proxyInfo != "" {
-		return "Basic " + base64.URLEncoding.EncodeToString([]byte(proxyInfo))
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxyInfo))
 	}
 	return ""
 }
@@ -232,6 +285,7 @@ func (t *Transport) putIdleConn(pconn *persistConn) {
 		pconn.close()
 		return
 	}
+	pconn.idleSince = time.Nanoseconds()
 	t.idleConn[key] = append(t.idleConn[key], pconn)
 }
 
@@ -256,7 +310,12 @@ func (t *Transport) getIdleConn(cm *connectMethod) (pconn *persistConn) {
 			pconn = pconns[len(pconns)-1]
 			t.idleConn[key] = pconns[0 : len(pconns)-1]
 		}
+		if t.IdleConnTimeout > 0 && time.Nanoseconds()-pconn.idleSince > t.IdleConnTimeout {
+			pconn.close()
+			continue
+		}
 		if !pconn.isBroken() {
+			pconn.reused = true
 			return
 		}
 	}
@@ -278,7 +337,11 @@ func (t *Transport) getConn(cm *connectMethod) (*persistConn, os.Error) {
 	if pc := t.getIdleConn(cm); pc != nil {
 		return pc, nil
 	}
+	return t.dialConn(cm)
+}
 
+// dialConn is like getConn, but always creates a new persistConn.
+func (t *Transport) dialConn(cm *connectMethod) (*persistConn, os.Error) {
 	conn, err := t.dial("tcp", cm.addr())
 	if err != nil {
 		if cm.proxyURL != nil {
@@ -475,8 +538,20 @@ type persistConn struct {
 	lk                   sync.Mutex // guards numExpectedResponses and broken
 	numExpectedResponses int
 	broken               bool // an error has happened on this connection; marked broken so it's not reused.
+
+	idleSince int64 // when the connection was put in the idle cache; guarded by t.lk
+	reused    bool  // whether the connection was taken from the idle cache
 }
 
+// deadConnError is returned by persistConn.roundTrip for a request that
+// failed on a re-used connection before any of the response was read,
+// which happens when the server closed the connection while it was idle.
+type deadConnError struct {
+	err os.Error
+}
+
+func (e deadConnError) String() string { return e.err.String() }
+
 func (pc *persistConn) isBroken() bool {
 	pc.lk.Lock()
 	defer pc.lk.Unlock()
@@ -509,6 +584,7 @@ func (pc *persistConn) readLoop() {
 		}
 
 		rc := <-pc.reqch
+		watch := rc.watch
 		resp, err := pc.cc.readUsing(rc.req, func(buf *bufio.Reader, forReq *Request) (*Response, os.Error) {
 			resp, err := ReadResponse(buf, forReq)
 			if err != nil || resp.ContentLength == 0 {
@@ -548,9 +624,15 @@ func (pc *persistConn) readLoop() {
 			if hasBody {
 				waitForBodyRead = make(chan bool)
 				resp.Body.(*bodyEOFSignal).fn = func() {
-					pc.t.putIdleConn(pc)
+					// A connection is pooled only once the request
+					// can no longer be abandoned on it
+					if watch.stop() {
+						pc.t.putIdleConn(pc)
+					}
 					waitForBodyRead <- true
 				}
+			} else if !watch.stop() {
+				alive = false
 			} else {
 				// When there's no response body, we immediately
 				// reuse the TCP connection (putIdleConn), but
@@ -590,6 +672,107 @@ type requestAndChan struct {
 	// Accept-Encoding gzip header? only if it we set it do
 	// we transparently decode the gzip.
 	addedGzip bool
+
+	watch *requestWatch // nil unless the request has a Timeout or Cancel
+}
+
+// requestWatch abandons a request, by closing its connection, when the
+// request times out or is canceled. It is stopped once the response has
+// been read, or the request has failed.
+type requestWatch struct {
+	pc   *persistConn
+	done chan bool
+
+	lk      sync.Mutex // guards err and stopped
+	err     os.Error   // ErrRequestTimeout or ErrCanceled, once abandoned
+	stopped bool
+}
+
+// watch returns a requestWatch for req on pc, or nil if req has neither
+// a Timeout nor a Cancel channel.
+func (pc *persistConn) watch(req *Request) *requestWatch {
+	if req.Timeout <= 0 && req.Cancel == nil {
+		return nil
+	}
+	w := &requestWatch{pc: pc, done: make(chan bool)}
+	var timeout <-chan int64
+	if req.Timeout > 0 {
+		timeout = time.After(req.Timeout)
+	}
+	go func() {
+		select {
+		case <-timeout:
+			w.abort(ErrRequestTimeout)
+		case <-req.Cancel:
+			w.abort(ErrCanceled)
+		case <-w.done:
+		}
+	}()
+	return w
+}
+
+func (w *requestWatch) abort(err os.Error) {
+	w.lk.Lock()
+	if w.stopped {
+		w.lk.Unlock()
+		return
+	}
+	w.err = err
+	w.stopped = true
+	w.lk.Unlock()
+	w.pc.close()
+}
+
+// stop ends the watch. It reports whether the request was still alive,
+// that is, whether it had not been abandoned. A nil watch is always alive.
+func (w *requestWatch) stop() bool {
+	if w == nil {
+		return true
+	}
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.done)
+	}
+	return w.err == nil
+}
+
+// abandoned returns the reason the request was abandoned, if it was.
+func (w *requestWatch) abandoned() os.Error {
+	if w == nil {
+		return nil
+	}
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.err
+}
+
+// watchedBody is the body of a response to a watched request. It reports
+// the reason the request was abandoned in place of the errors that closing
+// its connection causes, and ends the watch with the body.
+type watchedBody struct {
+	io.ReadCloser
+	w *requestWatch
+}
+
+func (b *watchedBody) Read(p []byte) (n int, err os.Error) {
+	n, err = b.ReadCloser.Read(p)
+	if err != nil {
+		if aerr := b.w.abandoned(); aerr != nil {
+			return n, aerr
+		}
+		if err == os.EOF {
+			b.w.stop()
+		}
+	}
+	return
+}
+
+func (b *watchedBody) Close() os.Error {
+	err := b.ReadCloser.Close()
+	b.w.stop()
+	return err
 }
 
 func (pc *persistConn) roundTrip(req *Request) (resp *Response, err os.Error) {
@@ -614,22 +797,53 @@ func (pc *persistConn) roundTrip(req *Request) (resp *Response, err os.Error) {
 	pc.numExpectedResponses++
 	pc.lk.Unlock()
 
+	watch := pc.watch(req)
 	err = pc.cc.Write(req)
 	if err != nil {
 		pc.close()
-		return
+		if aerr := watch.abandoned(); aerr != nil {
+			return nil, aerr
+		}
+		watch.stop()
+		return nil, pc.deadConn(req, requestedGzip, err)
 	}
 
 	ch := make(chan responseAndError, 1)
-	pc.reqch <- requestAndChan{req, ch, requestedGzip}
+	pc.reqch <- requestAndChan{req, ch, requestedGzip, watch}
 	re := <-ch
 	pc.lk.Lock()
 	pc.numExpectedResponses--
 	pc.lk.Unlock()
 
+	if re.err != nil {
+		if aerr := watch.abandoned(); aerr != nil {
+			return nil, aerr
+		}
+		watch.stop()
+	}
+	if re.err == os.EOF && re.res == nil {
+		pc.close()
+		return nil, pc.deadConn(req, requestedGzip, re.err)
+	}
+	if watch != nil && re.res != nil {
+		re.res.Body = &watchedBody{re.res.Body, watch}
+	}
 	return re.res, re.err
 }
 
+// deadConn wraps err, the error of sending req, into a deadConnError if
+// the connection was re-used, undoing the changes made to req so that it
+// can be sent again.
+func (pc *persistConn) deadConn(req *Request, requestedGzip bool, err os.Error) os.Error {
+	if !pc.reused {
+		return err
+	}
+	if requestedGzip {
+		req.Header.Del("Accept-Encoding")
+	}
+	return deadConnError{err}
+}
+
 func (pc *persistConn) close() {
 	pc.lk.Lock()
 	defer pc.lk.Unlock()
@@ -7,6 +7,7 @@
 package http_test
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"crypto/rand"
@@ -15,6 +16,7 @@ import (
 	"http/httptest"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -305,6 +307,92 @@ func TestTransportServerClosingUnexpectedly(t *testing.T) {
 	}
 }
 
+func TestTransportIdleConnTimeout(t *testing.T) {
+	ts := httptest.NewServer(hostPortHandler)
+	defer ts.Close()
+
+	tr := &Transport{IdleConnTimeout: 50e6}
+	c := &Client{Transport: tr}
+	get := func() string {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(body)
+	}
+	body1 := get()
+	if body2 := get(); body1 != body2 {
+		t.Errorf("expected the idle connection to be re-used; got %q and %q", body1, body2)
+	}
+	time.Sleep(100e6)
+	if body3 := get(); body1 == body3 {
+		t.Errorf("expected a new connection after the idle timeout")
+	}
+}
+
+// TestTransportRetryDeadConn verifies that an idempotent request is retried
+// on a new connection if the server closes the re-used one without answering.
+func TestTransportRetryDeadConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for n := 0; ; n++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn, n int) {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for i := 0; ; i++ {
+					if _, err := ReadRequest(br); err != nil {
+						return
+					}
+					if n%2 == 0 && i == 1 {
+						// Close the re-used connection without answering.
+						return
+					}
+					body := fmt.Sprintf("conn %d", n)
+					fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				}
+			}(c, n)
+		}
+	}()
+
+	tr := &Transport{}
+	c := &Client{Transport: tr}
+	do := func(method string, body io.Reader) (string, os.Error) {
+		req, _ := NewRequest(method, "http://"+l.Addr().String()+"/", body)
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b), nil
+	}
+	if b, err := do("GET", nil); err != nil || b != "conn 0" {
+		t.Fatalf("first GET: got %q, %v", b, err)
+	}
+	if b, err := do("GET", nil); err != nil || b != "conn 1" {
+		t.Errorf("retried GET: got %q, %v; want %q", b, err, "conn 1")
+	}
+
+	// Requests with a body are not retried
+	tr.CloseIdleConnections()
+	if b, err := do("GET", nil); err != nil || b != "conn 2" {
+		t.Fatalf("GET on conn 2: got %q, %v", b, err)
+	}
+	if b, err := do("PUT", strings.NewReader("x")); err == nil {
+		t.Errorf("PUT with a body: got %q, want an error", b)
+	}
+}
+
 // TestTransportHeadResponses verifies that we deal with Content-Lengths
 // with no bodies properly
 func TestTransportHeadResponses(t *testing.T) {
@@ -540,6 +628,170 @@ func TestTransportProxy(t *testing.T) {
 	}
 }
 
+func TestTransportProxyAuth(t *testing.T) {
+	ch := make(chan string, 1)
+	proxy := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ch <- r.URL.String() + " " + r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	pu, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pu.RawUserinfo = "user:pass"
+	// The selector excludes hosts, as NO_PROXY would
+	selector := func(req *Request) (*url.URL, os.Error) {
+		if req.URL.Host == "direct.invalid" {
+			return nil, nil
+		}
+		return pu, nil
+	}
+	c := &Client{Transport: &Transport{Proxy: selector}}
+	res, err := c.Get("http://example.com/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if g, e := <-ch, "http://example.com/path?q=1 Basic dXNlcjpwYXNz"; g != e {
+		t.Errorf("proxy got %q, want %q", g, e)
+	}
+
+	if _, err = c.Get("http://direct.invalid/"); err == nil {
+		t.Errorf("excluded host was not dialed directly")
+	}
+	select {
+	case g := <-ch:
+		t.Errorf("proxy got request %q for an excluded host", g)
+	default:
+	}
+}
+
+func TestTransportProxyConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ch := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			ch <- err.String()
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		var head []string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			head = append(head, strings.TrimRight(line, "\r\n"))
+		}
+		ch <- strings.Join(head, "|")
+		io.WriteString(c, "HTTP/1.1 403 Tunnel Refused\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	pu, err := url.Parse("http://user:pass@" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Transport: &Transport{Proxy: ProxyURL(pu)}}
+	_, err = c.Get("https://example.com/secret")
+	head := <-ch
+	if !strings.HasPrefix(head, "CONNECT example.com:443 HTTP/1.1|") {
+		t.Errorf("proxy got request %q, want a CONNECT to example.com:443", head)
+	}
+	if !strings.Contains(head, "|Proxy-Authorization: Basic dXNlcjpwYXNz") {
+		t.Errorf("proxy got request %q without Proxy-Authorization", head)
+	}
+	if strings.Contains(head, "/secret") {
+		t.Errorf("proxy saw the tunneled request: %q", head)
+	}
+	if err == nil || !strings.Contains(err.String(), "Tunnel Refused") {
+		t.Errorf("got error %v, want the proxy's refusal", err)
+	}
+}
+
+// slowServer returns a server that writes the header and part of the body
+// of its response when asked to, then stalls until release is closed.
+func slowServer(release chan bool) *httptest.Server {
+	return httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.FormValue("body") == "" {
+			<-release
+			return
+		}
+		w.Write([]byte("partial"))
+		w.(Flusher).Flush()
+		<-release
+	}))
+}
+
+func TestTransportTimeout(t *testing.T) {
+	release := make(chan bool)
+	ts := slowServer(release)
+	defer ts.Close()
+	defer close(release)
+
+	dials := 0
+	tr := &Transport{Dial: func(netw, addr string) (net.Conn, os.Error) {
+		dials++
+		return net.Dial(netw, addr)
+	}}
+
+	// The header does not arrive in time
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Timeout = 1e8
+	t0 := time.Nanoseconds()
+	if _, err := tr.RoundTrip(req); err != ErrRequestTimeout {
+		t.Errorf("header phase: got error %v, want %v", err, ErrRequestTimeout)
+	}
+	if d := time.Nanoseconds() - t0; d > 2e9 {
+		t.Errorf("header phase took %d ns", d)
+	}
+
+	// The header arrives, but not the whole body
+	req, _ = NewRequest("GET", ts.URL+"/?body=1", nil)
+	req.Timeout = 3e8
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("body phase: %v", err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != ErrRequestTimeout {
+		t.Errorf("body phase: got error %v, want %v", err, ErrRequestTimeout)
+	}
+	if string(body) != "partial" {
+		t.Errorf("body phase: got body %q, want %q", body, "partial")
+	}
+	if dials != 2 {
+		t.Errorf("got %d dials, want 2: a timed-out connection was reused", dials)
+	}
+}
+
+func TestTransportCancel(t *testing.T) {
+	release := make(chan bool)
+	ts := slowServer(release)
+	defer ts.Close()
+	defer close(release)
+
+	cancel := make(chan bool)
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Cancel = cancel
+	go func() {
+		time.Sleep(1e8)
+		close(cancel)
+	}()
+	c := &Client{Transport: &Transport{}}
+	_, err := c.Do(req)
+	if ue, ok := err.(*url.Error); !ok || ue.Error != ErrCanceled {
+		t.Errorf("got error %v, want %v", err, ErrCanceled)
+	}
+}
+
 // TestTransportGzipRecursive sends a gzip quine and checks that the
 // client gets the same value back. This is more cute than anything,
 // but checks that we don't recurse forever, and checks that
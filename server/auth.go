@@ -0,0 +1,34 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+)
+
+// BasicAuth returns a Middleware that passes on only queries carrying HTTP
+// Basic Authentication credentials accepted by check. All other queries
+// are answered with a 401 response, challenging the client for realm.
+func BasicAuth(realm string, check func(user, pass string) bool) Middleware {
+	return func(q *Query, next func(*Query)) {
+		if q.RequireBasicAuth(realm, check) {
+			next(q)
+		}
+	}
+}
+
+// RequireBasicAuth reports whether the request carries HTTP Basic
+// Authentication credentials accepted by check. If it does not, the query
+// is answered, using ContinueAndWrite, with a 401 response challenging the
+// client for realm. Since check sees guesses at the password, it should
+// compare them in constant time, for instance with crypto/subtle.
+func (q *Query) RequireBasicAuth(realm string, check func(user, pass string) bool) bool {
+	user, pass, ok := http.ParseBasicAuth(q.Req.Header)
+	if !ok || !check(user, pass) {
+		q.ContinueAndWrite(http.NewResponse401(q.Req, realm))
+		return false
+	}
+	return true
+}
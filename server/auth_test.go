@@ -0,0 +1,45 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/subtle"
+	"testing"
+	"net/http"
+)
+
+func TestRequireBasicAuth(t *testing.T) {
+	check := func(user, pass string) bool {
+		return subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte("ünïcode:sé:cret")) == 1
+	}
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		if q.RequireBasicAuth("test", check) {
+			okHandler(q)
+		}
+	})
+	defer srv.Shutdown()
+
+	for i, pass := range []string{"", "wrong", "sé:cret"} {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+		if pass != "" {
+			req.SetBasicAuth("ünïcode", pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: get: %s", i, err)
+		}
+		resp.Body.Close()
+		want := 401
+		if i == 2 {
+			want = 200
+		}
+		if resp.StatusCode != want {
+			t.Errorf("#%d: got status %d, want %d", i, resp.StatusCode, want)
+		}
+		if want == 401 && resp.Header.Get("Www-Authenticate") != `Basic realm="test"` {
+			t.Errorf("#%d: got challenge %q", i, resp.Header.Get("Www-Authenticate"))
+		}
+	}
+}
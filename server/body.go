@@ -0,0 +1,67 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// maxBodyReader wraps a request body and fails with ErrBodyTooLarge
+// once more than n bytes have been read from it.
+type maxBodyReader struct {
+	io.ReadCloser
+	n        int64 // max bytes remaining
+	exceeded bool
+}
+
+func newMaxBodyReader(body io.ReadCloser, n int64) *maxBodyReader {
+	return &maxBodyReader{ReadCloser: body, n: n}
+}
+
+// MaxBodyReader returns a reader of body that fails with ErrBodyTooLarge
+// once more than n bytes have been read from it. Closing it closes body.
+// Subs that bound the bodies they read, beyond Config.MaxBodyBytes, use it
+// so that the limits are reported alike.
+func MaxBodyReader(body io.ReadCloser, n int64) io.ReadCloser {
+	return newMaxBodyReader(body, n)
+}
+
+func (l *maxBodyReader) Read(p []byte) (n int, err error) {
+	if l.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err = l.ReadCloser.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		l.exceeded = true
+		return n + int(l.n), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// expectContinueReader wraps the body of a request carrying
+// "Expect: 100-continue", and sends the interim 100 response
+// when the body is first read.
+type expectContinueReader struct {
+	io.ReadCloser
+	ssc  *StampedServerConn
+	sent bool
+}
+
+func (ecr *expectContinueReader) Read(p []byte) (n int, err error) {
+	if !ecr.sent {
+		ecr.sent = true
+		if err = ecr.ssc.WriteContinue(); err != nil {
+			return 0, err
+		}
+	}
+	return ecr.ReadCloser.Read(p)
+}
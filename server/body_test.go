@@ -0,0 +1,56 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"net/http"
+)
+
+func TestMaxBodyBytes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9, MaxBodyBytes: 10}, 20)
+	defer srv.Shutdown()
+	go func() {
+		for {
+			q, err := srv.Read()
+			if err != nil {
+				return
+			}
+			_, err = ioutil.ReadAll(q.Req.Body)
+			if err == ErrBodyTooLarge {
+				q.ContinueAndWrite(http.NewResponse400String(q.Req, "too large"))
+			} else {
+				q.ContinueAndWrite(http.NewResponse200(q.Req))
+			}
+		}
+	}()
+
+	url := "http://" + l.Addr().String() + "/"
+	resp, err := http.Post(url, "text/plain", strings.NewReader(strings.Repeat("x", 100)))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 400 || string(body) != "too large" {
+		t.Errorf("over-limit body: got %d %q, want 400 %q", resp.StatusCode, body, "too large")
+	}
+
+	resp, err = http.Post(url, "text/plain", strings.NewReader("small"))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("under-limit body: got %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+	"net/http"
+)
+
+// CacheConfig configures the response cache of a Server.
+type CacheConfig struct {
+	TTL           int64    // Lifetime of a cached response, in nanoseconds
+	MaxEntryBytes int      // Responses with larger bodies are not cached
+	MaxTotalBytes int      // Least recently used responses are evicted beyond this total
+	Vary          []string // Request headers whose values distinguish cached responses
+
+	// Cacheable decides whether resp, written in response to req, may be cached.
+	// If nil, only 200 responses to GET and HEAD requests that set no cookies
+	// are cached. Since the cache is consulted before middleware, such as
+	// authentication, responses to requests that carry an Authorization or
+	// Cookie header are then cached only if marked Cache-Control: public.
+	Cacheable func(req *http.Request, resp *http.Response) bool
+}
+
+const (
+	DefaultCacheTTL           = 60e9
+	DefaultCacheMaxEntryBytes = 64 << 10
+	DefaultCacheMaxTotalBytes = 8 << 20
+)
+
+// ResponseCache is an in-memory cache of responses, keyed by request method,
+// host, URL and the values of the configured Vary headers. Concurrent requests
+// that miss on the same key are collapsed: only the first is handed to the
+// application, and the others are answered with its response once it has
+// been written, or passed on themselves if the response is not cacheable.
+type ResponseCache struct {
+	sync.Mutex // protects entries, lru, total and flights
+	srv        *Server
+	config     CacheConfig
+	entries    map[string]*cacheEntry
+	lru        *list.List // Of *cacheEntry, most recently used first
+	total      int
+	flights    map[string]*cacheFlight
+}
+
+type cacheEntry struct {
+	key        string
+	path       string
+	status     string
+	statusCode int
+	header     http.Header
+	body       []byte
+	clen       int64
+	expires    int64
+	elem       *list.Element
+}
+
+// cacheFlight tracks a request that missed and is being served by the application.
+type cacheFlight struct {
+	key   string
+	path  string
+	done  chan bool   // Closed once the response has been written
+	entry *cacheEntry // Cached response, or nil if it was not cacheable
+}
+
+// EnableResponseCache makes the server consult an in-memory cache before
+// handing GET and HEAD queries to extensions, middleware, subs or Read.
+// Cached responses skip the extensions' ReadRequest, but still pass through
+// their WriteResponse on the way out. Hits and misses are counted in Stats.
+func (srv *Server) EnableResponseCache(config CacheConfig) *ResponseCache {
+	if config.TTL <= 0 {
+		config.TTL = DefaultCacheTTL
+	}
+	if config.MaxEntryBytes <= 0 {
+		config.MaxEntryBytes = DefaultCacheMaxEntryBytes
+	}
+	if config.MaxTotalBytes <= 0 {
+		config.MaxTotalBytes = DefaultCacheMaxTotalBytes
+	}
+	if config.Cacheable == nil {
+		config.Cacheable = defaultCacheable
+	}
+	rc := &ResponseCache{
+		srv:     srv,
+		config:  config,
+		entries: make(map[string]*cacheEntry),
+		lru:     list.New(),
+		flights: make(map[string]*cacheFlight),
+	}
+	srv.Lock()
+	srv.cache = rc
+	srv.Unlock()
+	return rc
+}
+
+func defaultCacheable(req *http.Request, resp *http.Response) bool {
+	if req.Method != "GET" && req.Method != "HEAD" || resp.StatusCode != 200 {
+		return false
+	}
+	if len(resp.Header["Set-Cookie"]) > 0 {
+		return false
+	}
+	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "private") {
+		return false
+	}
+	// Responses to requests with credentials may be meant for them alone
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" {
+		return strings.Contains(cc, "public")
+	}
+	return true
+}
+
+// Invalidate removes all cached responses for URL paths starting with prefix.
+func (rc *ResponseCache) Invalidate(prefix string) {
+	rc.Lock()
+	defer rc.Unlock()
+	for _, e := range rc.entries {
+		if strings.HasPrefix(e.path, prefix) {
+			rc.remove(e)
+		}
+	}
+}
+
+// Len returns the number of cached responses.
+func (rc *ResponseCache) Len() int {
+	rc.Lock()
+	defer rc.Unlock()
+	return len(rc.entries)
+}
+
+func (rc *ResponseCache) key(req *http.Request) string {
+	k := req.Method + " " + req.Host + req.URL.String()
+	for _, h := range rc.config.Vary {
+		k += "\n" + h + ": " + req.Header.Get(h)
+	}
+	return k
+}
+
+// remove deletes e from the cache. The caller must hold the lock.
+func (rc *ResponseCache) remove(e *cacheEntry) {
+	rc.entries[e.key] = nil, false
+	rc.lru.Remove(e.elem)
+	rc.total -= len(e.body)
+}
+
+// lookup returns the fresh entry for key, if any. Otherwise, if another
+// request for key is already being served, it returns its flight, and if not,
+// it returns a new flight owned by the caller.
+func (rc *ResponseCache) lookup(key, path string) (e *cacheEntry, fl *cacheFlight, owner bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	if e = rc.entries[key]; e != nil {
+		if e.expires > time.Nanoseconds() {
+			rc.lru.MoveToFront(e.elem)
+			return e, nil, false
+		}
+		rc.remove(e)
+	}
+	if fl = rc.flights[key]; fl != nil {
+		return nil, fl, false
+	}
+	fl = &cacheFlight{key: key, path: path, done: make(chan bool)}
+	rc.flights[key] = fl
+	return nil, fl, true
+}
+
+// store adds e to the cache, evicting least recently used entries as needed.
+func (rc *ResponseCache) store(e *cacheEntry) {
+	rc.Lock()
+	defer rc.Unlock()
+	if old := rc.entries[e.key]; old != nil {
+		rc.remove(old)
+	}
+	for rc.total+len(e.body) > rc.config.MaxTotalBytes && rc.lru.Len() > 0 {
+		rc.remove(rc.lru.Back().Value.(*cacheEntry))
+	}
+	e.elem = rc.lru.PushFront(e)
+	rc.entries[e.key] = e
+	rc.total += len(e.body)
+}
+
+// finish completes fl with entry, which may be nil, and releases its waiters.
+func (rc *ResponseCache) finish(fl *cacheFlight, entry *cacheEntry) {
+	rc.Lock()
+	if rc.flights[fl.key] == fl {
+		rc.flights[fl.key] = nil, false
+	}
+	rc.Unlock()
+	fl.entry = entry
+	close(fl.done)
+}
+
+// forget lets later requests for the key of fl start a new flight, while
+// the owner of fl may still complete it.
+func (rc *ResponseCache) forget(fl *cacheFlight) {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.flights[fl.key] == fl {
+		rc.flights[fl.key] = nil, false
+	}
+}
+
+// fill is called by Query.Write with the response to a query that owns fl,
+// before extensions see the response. It caches resp if possible, leaving
+// resp readable in either case, and completes fl.
+func (rc *ResponseCache) fill(fl *cacheFlight, req *http.Request, resp *http.Response) {
+	var entry *cacheEntry
+	defer func() { rc.finish(fl, entry) }()
+
+	// Responses of unknown length may be streamed, and are never cached
+	if resp.ContentLength < 0 || len(resp.TransferEncoding) > 0 || !rc.config.Cacheable(req, resp) {
+		return
+	}
+	var body []byte
+	clen := resp.ContentLength
+	if resp.Body != nil {
+		max := rc.config.MaxEntryBytes
+		buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+		if err != nil || len(buf) > max {
+			resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewBuffer(buf), resp.Body))
+			return
+		}
+		resp.Body = http.NewBodyBytes(buf)
+		body = buf
+		if req.Method != "HEAD" {
+			clen = int64(len(buf))
+		}
+	}
+	header := resp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Date")
+	entry = &cacheEntry{
+		key:        fl.key,
+		path:       fl.path,
+		status:     resp.Status,
+		statusCode: resp.StatusCode,
+		header:     header,
+		body:       body,
+		clen:       clen,
+		expires:    time.Nanoseconds() + rc.config.TTL,
+	}
+	rc.store(entry)
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	header := make(http.Header)
+	for k, vv := range e.header {
+		header[k] = append([]string(nil), vv...)
+	}
+	resp := &http.Response{
+		Status:        e.status,
+		StatusCode:    e.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        header,
+		ContentLength: e.clen,
+	}
+	if len(e.body) > 0 {
+		resp.Body = http.NewBodyBytes(e.body)
+	}
+	return resp
+}
+
+// serveCached answers q from the response cache if possible, and reports
+// whether q has been taken care of. Queries that miss while another request
+// for the same key is in flight are parked until that request is answered.
+func (srv *Server) serveCached(q *Query) bool {
+	srv.Lock()
+	rc := srv.cache
+	srv.Unlock()
+	req := q.Req
+	if rc == nil || q.uncached || req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+	e, fl, owner := rc.lookup(rc.key(req), q.origPath)
+	switch {
+	case e != nil:
+		srv.stats.IncCacheHit()
+		q.Ext = make(map[string]interface{})
+		q.ContinueAndWrite(e.response(req))
+		return true
+	case owner:
+		srv.stats.IncCacheMiss()
+		q.fill = fl
+		return false
+	}
+	go srv.awaitFlight(rc, q, fl)
+	return true
+}
+
+// awaitFlight answers q with the response of fl, a flight of rc, once it is
+// cached. If the response was not cacheable, or is not written within the
+// write timeout, q is passed on to be served like any other query. In the
+// latter case, fl is forgotten, so that further requests do not wait for it.
+func (srv *Server) awaitFlight(rc *ResponseCache, q *Query, fl *cacheFlight) {
+	var e *cacheEntry
+	select {
+	case <-fl.done:
+		e = fl.entry
+	case <-time.After(srv.config.WriteTimeout):
+		rc.forget(fl)
+	}
+	if e != nil {
+		srv.stats.IncCacheHit()
+		q.Ext = make(map[string]interface{})
+		q.ContinueAndWrite(e.response(q.Req))
+		return
+	}
+	srv.stats.IncCacheMiss()
+	q.uncached = true
+	if !srv.send(q) {
+		srv.bury(q.ssc)
+	}
+}
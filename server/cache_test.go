@@ -0,0 +1,216 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+	"net/http"
+)
+
+// countingHandler answers with the number of queries it has seen,
+// setting a cookie on paths under /cookie, and marking responses on paths
+// under /public as Cache-Control: public.
+type countingHandler struct {
+	sync.Mutex
+	n       int
+	release chan bool // If non-nil, responses wait for it to be closed
+}
+
+func (h *countingHandler) count() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.n
+}
+
+func (h *countingHandler) handle(q *Query) {
+	h.Lock()
+	h.n++
+	n := h.n
+	h.Unlock()
+	go func() {
+		if h.release != nil {
+			<-h.release
+		}
+		resp := http.NewResponse200Bytes(q.Req, []byte(fmt.Sprintf("%d", n)))
+		resp.Header = make(http.Header)
+		if len(q.Req.URL.Path) >= 7 && q.Req.URL.Path[:7] == "/cookie" {
+			resp.Header.Add("Set-Cookie", "a=b")
+		}
+		if len(q.Req.URL.Path) >= 7 && q.Req.URL.Path[:7] == "/public" {
+			resp.Header.Set("Cache-Control", "public")
+		}
+		q.ContinueAndWrite(resp)
+	}()
+}
+
+func getBody(t *testing.T, url string) string {
+	return getBodyHeader(t, url, nil)
+}
+
+func getBodyHeader(t *testing.T, url string, header http.Header) string {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("request %s: %s", url, err)
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %s: %s", url, err)
+	}
+	return string(body)
+}
+
+func TestResponseCache(t *testing.T) {
+	h := &countingHandler{}
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, h.handle)
+	defer srv.Shutdown()
+	rc := srv.EnableResponseCache(CacheConfig{})
+	base := "http://" + addr
+
+	if b := getBody(t, base+"/a"); b != "1" {
+		t.Errorf("first get: got %q, want %q", b, "1")
+	}
+	if b := getBody(t, base+"/a"); b != "1" {
+		t.Errorf("cached get: got %q, want %q", b, "1")
+	}
+	if b := getBody(t, base+"/a?x=1"); b != "2" {
+		t.Errorf("get with query: got %q, want %q", b, "2")
+	}
+	getBody(t, base+"/cookie")
+	getBody(t, base+"/cookie")
+	if n := h.count(); n != 4 {
+		t.Errorf("handler saw %d queries, want 4", n)
+	}
+	if n := rc.Len(); n != 2 {
+		t.Errorf("cache holds %d responses, want 2", n)
+	}
+
+	rc.Invalidate("/a")
+	if n := rc.Len(); n != 0 {
+		t.Errorf("after Invalidate, cache holds %d responses, want 0", n)
+	}
+	if b := getBody(t, base+"/a"); b != "5" {
+		t.Errorf("get after Invalidate: got %q, want %q", b, "5")
+	}
+
+	stats := srv.Stats()
+	if stats.CacheHitCount != 1 || stats.CacheMissCount != 5 {
+		t.Errorf("got %d hits and %d misses, want 1 and 5", stats.CacheHitCount, stats.CacheMissCount)
+	}
+}
+
+func TestResponseCacheCollapse(t *testing.T) {
+	h := &countingHandler{release: make(chan bool)}
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, h.handle)
+	defer srv.Shutdown()
+	srv.EnableResponseCache(CacheConfig{})
+
+	const n = 3
+	bodies := make(chan string, n)
+	for i := 0; i < n; i++ {
+		go func() { bodies <- getBody(t, "http://"+addr+"/slow") }()
+	}
+	time.Sleep(2e8)
+	close(h.release)
+	for i := 0; i < n; i++ {
+		if b := <-bodies; b != "1" {
+			t.Errorf("got %q, want %q", b, "1")
+		}
+	}
+	if c := h.count(); c != 1 {
+		t.Errorf("handler saw %d queries, want 1", c)
+	}
+}
+
+func TestResponseCacheCredentials(t *testing.T) {
+	h := &countingHandler{}
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, h.handle)
+	defer srv.Shutdown()
+	rc := srv.EnableResponseCache(CacheConfig{})
+	base := "http://" + addr
+
+	tests := []struct {
+		path   string
+		header http.Header
+		body   string
+	}{
+		// Responses to credentialed requests are not replayed to others
+		{"/a", http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, "1"},
+		{"/a", nil, "2"},
+		{"/a", http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, "2"},
+		{"/b", http.Header{"Cookie": {"sid=secret"}}, "3"},
+		{"/b", http.Header{"Cookie": {"sid=secret"}}, "4"},
+		// unless they are marked public
+		{"/public", http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, "5"},
+		{"/public", nil, "5"},
+	}
+	for i, tt := range tests {
+		if b := getBodyHeader(t, base+tt.path, tt.header); b != tt.body {
+			t.Errorf("#%d: %s: got %q, want %q", i, tt.path, b, tt.body)
+		}
+	}
+	if n := rc.Len(); n != 2 {
+		t.Errorf("cache holds %d responses, want 2", n)
+	}
+}
+
+// Flights whose owner is hijacked, or never answered, must not hold up
+// later requests for the same key.
+func TestResponseCacheAbandonedFlight(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, WriteTimeout: 3e8}, func(q *Query) {
+		p := q.Req.URL.Path
+		mu.Lock()
+		seen[p]++
+		n := seen[p]
+		mu.Unlock()
+		switch {
+		case p == "/hijack" && n == 1:
+			q.Hijack().Close()
+		case p == "/stuck" && n == 1:
+			// Never answered
+		default:
+			okHandler(q)
+		}
+	})
+	defer srv.Shutdown()
+	srv.EnableResponseCache(CacheConfig{})
+	base := "http://" + addr
+
+	if _, err := http.Get(base + "/hijack"); err == nil {
+		t.Errorf("hijacked request: got a response")
+	}
+	t0 := time.Now().UnixNano()
+	if b := getBody(t, base+"/hijack"); b != "ok" {
+		t.Errorf("after hijack: got %q, want %q", b, "ok")
+	}
+	if d := time.Now().UnixNano() - t0; d > 2e8 {
+		t.Errorf("after hijack: waited %dms for the abandoned flight", d/1e6)
+	}
+
+	go http.Get(base + "/stuck")
+	time.Sleep(1e8)
+	// This one waits out the write timeout, and is then passed on too late
+	http.Get(base + "/stuck")
+	t0 = time.Now().UnixNano()
+	if b := getBody(t, base+"/stuck"); b != "ok" {
+		t.Errorf("after timeout: got %q, want %q", b, "ok")
+	}
+	if d := time.Now().UnixNano() - t0; d > 2e8 {
+		t.Errorf("after timeout: waited %dms for the forgotten flight", d/1e6)
+	}
+}
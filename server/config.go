@@ -4,6 +4,142 @@
 
 package server
 
+import (
+	"errors"
+	"net/http"
+)
+
+// DefaultFDLimit is the default value of Config.FDLimit.
+const DefaultFDLimit = 200
+
+// DefaultLinger is the default value of Config.Linger.
+const DefaultLinger = 5e8
+
 type Config struct {
-	Timeout int64 // Keep-alive timeout in nanoseconds
+	Timeout int64 // Keep-alive timeout in nanoseconds; default for the timeouts below
+
+	IdleTimeout  int64 // Time an idle keep-alive connection waits for the next request
+	ReadTimeout  int64 // Time allowed for each read of a request body, once the request header is in
+	WriteTimeout int64 // Time allowed from receiving a request to writing its response, and for each write of it
+
+	// MaxBodyBytes, if positive, bounds the number of bytes that can be read
+	// from a request body. Reading beyond the limit fails with ErrBodyTooLarge,
+	// and the connection is closed after the response is written.
+	MaxBodyBytes int64
+
+	// TrustForwardedFor trusts every peer as a proxy in the model of
+	// Server.SetTrustedProxies, so that any client can claim any address.
+	//
+	// Deprecated: list the trusted proxies with Server.SetTrustedProxies.
+	TrustForwardedFor bool
+
+	// MaxHeaderBytes bounds the size of request headers. Requests with
+	// larger headers are answered with a 431 status, and their connection
+	// is closed. Defaults to http.DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+
+	// FDLimit bounds the number of file descriptors allocated to incoming
+	// connections. It is used by NewServerChecked, and defaults to DefaultFDLimit.
+	FDLimit int
+
+	// FDWaitTimeout bounds the time the server waits for a file descriptor
+	// to free up once FDLimit is reached. Connections that arrive while it
+	// has run out are answered with a 503 and closed, until a descriptor is
+	// released. Zero means wait indefinitely.
+	FDWaitTimeout int64
+
+	// MaxConnsPerIP, if positive, bounds the number of connections open at
+	// once from each remote IP. Connections beyond it are answered with a
+	// 429 and closed.
+	MaxConnsPerIP int
+
+	// Linger bounds the time, in nanoseconds, that a connection closed after
+	// a response waits for the client to close its side, discarding further
+	// input meanwhile. Closing a connection with input still unread makes
+	// the client's system reset it, which can destroy the response before
+	// the client has read it. Zero means DefaultLinger, and a negative value
+	// closes connections at once.
+	Linger int64
+
+	// DrainGrace is the time, in nanoseconds, for which Shutdown keeps
+	// accepting and serving requests after marking the server as draining,
+	// before it closes the listener. Meanwhile the readiness endpoint answers
+	// with a 503, so that load balancers stop sending new traffic. Zero closes
+	// the listener at once.
+	DrainGrace int64
+
+	// CleanPaths makes the server normalize request paths with CleanPath
+	// before routing them. Requests whose path climbs above the root are
+	// answered with a 400.
+	CleanPaths bool
+
+	// OnReadError, if non-nil, is called with the client address and the error
+	// whenever a connection is closed because a request could not be read.
+	// Clients closing their connection, or leaving it idle until it times out,
+	// are not reported.
+	OnReadError func(remoteAddr string, err error)
+
+	// Gzip enables compression of responses for clients that accept it.
+	// Only responses whose known length exceeds GzipMinBytes, which defaults
+	// to DefaultGzipMinBytes, and whose Content-Type starts with one of
+	// GzipTypes, which defaults to DefaultGzipTypes, are compressed.
+	Gzip         bool
+	GzipMinBytes int
+	GzipTypes    []string
+
+	// CORS, if non-nil, makes the server take part in cross-origin resource
+	// sharing for all request paths, as EnableCORS does with subURL "/".
+	CORS *CORSConfig
+}
+
+// fill sets the zero timeouts in c to c.Timeout, and other zero limits
+// to their defaults.
+func (c *Config) fill() {
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = c.Timeout
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = c.Timeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = c.Timeout
+	}
+	if c.MaxHeaderBytes == 0 {
+		c.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+	if c.FDLimit == 0 {
+		c.FDLimit = DefaultFDLimit
+	}
+	if c.GzipMinBytes == 0 {
+		c.GzipMinBytes = DefaultGzipMinBytes
+	}
+	if c.Linger == 0 {
+		c.Linger = DefaultLinger
+	}
+	if c.GzipTypes == nil {
+		c.GzipTypes = DefaultGzipTypes
+	}
+}
+
+// check returns an error describing the first invalid setting in c, if any.
+func (c *Config) check() error {
+	switch {
+	case c.IdleTimeout < 2 || c.ReadTimeout < 2 || c.WriteTimeout < 2:
+		return errors.New("server: timeout too small")
+	case c.MaxBodyBytes < 0:
+		return errors.New("server: negative MaxBodyBytes")
+	case c.MaxHeaderBytes < 0:
+		return errors.New("server: negative MaxHeaderBytes")
+	case c.FDLimit < 1:
+		return errors.New("server: FDLimit must be positive")
+	case c.FDWaitTimeout < 0:
+		return errors.New("server: negative FDWaitTimeout")
+	case c.DrainGrace < 0:
+		return errors.New("server: negative DrainGrace")
+	case c.MaxConnsPerIP < 0:
+		return errors.New("server: negative MaxConnsPerIP")
+	case c.GzipMinBytes < 0:
+		return errors.New("server: negative GzipMinBytes")
+	}
+	return nil
 }
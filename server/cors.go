@@ -0,0 +1,126 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strconv"
+	"strings"
+	"net/http"
+)
+
+// CORSConfig configures cross-origin resource sharing.
+type CORSConfig struct {
+	// AllowOrigins lists the origins, such as "https://example.com", that may
+	// access the server. The origin "*" allows any origin.
+	AllowOrigins []string
+
+	// AllowOriginFunc, if non-nil, decides on origins not in AllowOrigins.
+	AllowOriginFunc func(origin string) bool
+
+	AllowMethods     []string // Methods allowed in cross-origin requests; defaults to GET, HEAD and POST
+	AllowHeaders     []string // Request headers allowed in cross-origin requests
+	ExposeHeaders    []string // Response headers that scripts may read
+	AllowCredentials bool     // Whether requests may carry cookies and credentials
+	MaxAge           int      // Seconds for which a preflight may be cached; zero omits it
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+type corsExt struct {
+	subURL string
+	config CORSConfig
+}
+
+// EnableCORS makes the server take part in cross-origin resource sharing
+// for request paths starting with subURL. Preflight requests are answered
+// directly with a 204. Other responses to allowed origins are annotated with
+// Access-Control-Allow-Origin and the related headers. Requests from origins
+// that are not allowed are served as usual, without any CORS headers, which
+// makes browsers withhold the response from the requesting script.
+// Since credentialed requests may not be answered with the wildcard origin,
+// the request's origin is echoed instead when AllowCredentials is set.
+func (srv *Server) EnableCORS(subURL string, config CORSConfig) {
+	if config.AllowMethods == nil {
+		config.AllowMethods = defaultCORSMethods
+	}
+	ce := &corsExt{subURL, config}
+	srv.AddExt("cors", subURL, ce)
+	srv.Use(ce.preflight)
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for origin,
+// or the empty string if origin is not allowed.
+func (ce *corsExt) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, o := range ce.config.AllowOrigins {
+		if o == "*" && !ce.config.AllowCredentials {
+			return "*"
+		}
+		if o == "*" || o == origin {
+			return origin
+		}
+	}
+	if ce.config.AllowOriginFunc != nil && ce.config.AllowOriginFunc(origin) {
+		return origin
+	}
+	return ""
+}
+
+func (ce *corsExt) ReadRequest(req *http.Request, ext map[string]interface{}) error {
+	ext["cors"] = req.Header.Get("Origin")
+	return nil
+}
+
+func (ce *corsExt) WriteResponse(resp *http.Response, ext map[string]interface{}) error {
+	origin, _ := ext["cors"].(string)
+	if origin == "" && resp.Request != nil {
+		// Queries answered before extensions are applied, such as cache hits
+		origin = resp.Request.Header.Get("Origin")
+	}
+	if origin == "" {
+		return nil
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Add("Vary", "Origin")
+	allow := ce.allowOrigin(origin)
+	if allow == "" {
+		return nil
+	}
+	resp.Header.Set("Access-Control-Allow-Origin", allow)
+	if ce.config.AllowCredentials {
+		resp.Header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(ce.config.ExposeHeaders) > 0 {
+		resp.Header.Set("Access-Control-Expose-Headers", strings.Join(ce.config.ExposeHeaders, ", "))
+	}
+	return nil
+}
+
+// preflight is a Middleware that answers CORS preflight requests.
+// The headers common to all CORS responses are added by WriteResponse.
+func (ce *corsExt) preflight(q *Query, next func(*Query)) {
+	req := q.Req
+	if req.Method != "OPTIONS" || req.Header.Get("Access-Control-Request-Method") == "" ||
+		!strings.HasPrefix(q.origPath, ce.subURL) {
+		next(q)
+		return
+	}
+	resp := http.NewResponse204(req)
+	resp.Header = make(http.Header)
+	if ce.allowOrigin(req.Header.Get("Origin")) != "" {
+		resp.Header.Set("Access-Control-Allow-Methods", strings.Join(ce.config.AllowMethods, ", "))
+		if len(ce.config.AllowHeaders) > 0 {
+			resp.Header.Set("Access-Control-Allow-Headers", strings.Join(ce.config.AllowHeaders, ", "))
+		}
+		if ce.config.MaxAge > 0 {
+			resp.Header.Set("Access-Control-Max-Age", strconv.Itoa(ce.config.MaxAge))
+		}
+	}
+	q.ContinueAndWrite(resp)
+}
@@ -0,0 +1,152 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strings"
+	"testing"
+	"net/http"
+)
+
+var corsConfigs = []CORSConfig{
+	{
+		AllowOrigins:    []string{"https://a.example"},
+		AllowOriginFunc: func(o string) bool { return strings.HasSuffix(o, ".trusted") },
+		AllowMethods:    []string{"GET", "PUT"},
+		AllowHeaders:    []string{"X-Token"},
+		ExposeHeaders:   []string{"X-Total"},
+		MaxAge:          600,
+	},
+	{AllowOrigins: []string{"*"}},
+	{AllowOrigins: []string{"*"}, AllowCredentials: true},
+}
+
+var corsTests = []struct {
+	config         int // Index into corsConfigs
+	method, origin string
+	status         int
+	header         map[string]string
+}{
+	// Preflight from an allowed origin
+	{0, "OPTIONS", "https://a.example", 204, map[string]string{
+		"Access-Control-Allow-Origin":  "https://a.example",
+		"Access-Control-Allow-Methods": "GET, PUT",
+		"Access-Control-Allow-Headers": "X-Token",
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	}},
+	// Simple request from an allowed origin
+	{0, "GET", "https://a.example", 200, map[string]string{
+		"Access-Control-Allow-Origin":   "https://a.example",
+		"Access-Control-Expose-Headers": "X-Total",
+		"Access-Control-Allow-Methods":  "",
+		"Vary":                          "Origin",
+	}},
+	// Origin allowed by the predicate
+	{0, "GET", "https://b.trusted", 200, map[string]string{
+		"Access-Control-Allow-Origin": "https://b.trusted",
+	}},
+	// Disallowed origins are served without CORS headers
+	{0, "OPTIONS", "https://evil.example", 204, map[string]string{
+		"Access-Control-Allow-Origin":  "",
+		"Access-Control-Allow-Methods": "",
+	}},
+	{0, "GET", "https://evil.example", 200, map[string]string{
+		"Access-Control-Allow-Origin": "",
+	}},
+	// Requests without an Origin are not cross-origin
+	{0, "GET", "", 200, map[string]string{
+		"Access-Control-Allow-Origin": "",
+		"Vary":                        "",
+	}},
+	{1, "GET", "https://c.example", 200, map[string]string{
+		"Access-Control-Allow-Origin":      "*",
+		"Access-Control-Allow-Credentials": "",
+	}},
+	{1, "OPTIONS", "https://c.example", 204, map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST",
+	}},
+	// The wildcard is replaced by the origin for credentialed requests
+	{2, "GET", "https://c.example", 200, map[string]string{
+		"Access-Control-Allow-Origin":      "https://c.example",
+		"Access-Control-Allow-Credentials": "true",
+	}},
+}
+
+func TestCORS(t *testing.T) {
+	var addrs []string
+	for _, config := range corsConfigs {
+		srv, addr := startTestServer(t, Config{Timeout: 5e9}, okHandler)
+		defer srv.Shutdown()
+		srv.EnableCORS("/api/", config)
+		addrs = append(addrs, addr)
+	}
+
+	for i, tt := range corsTests {
+		req, _ := http.NewRequest(tt.method, "http://"+addrs[tt.config]+"/api/items", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("#%d: got status %d, want %d", i, resp.StatusCode, tt.status)
+		}
+		for k, v := range tt.header {
+			if g := resp.Header.Get(k); g != v {
+				t.Errorf("#%d: %s: got %q, want %q", i, k, g, v)
+			}
+		}
+	}
+}
+
+func TestConfigCORS(t *testing.T) {
+	config := Config{Timeout: 5e9, CORS: &CORSConfig{
+		AllowOrigins: []string{"https://a.example"},
+		AllowHeaders: []string{"Content-Type"},
+	}}
+	srv, addr := startTestServer(t, config, okHandler)
+	defer srv.Shutdown()
+
+	tests := []struct {
+		method, origin string
+		status         int
+		allowOrigin    string
+		allowMethods   string
+	}{
+		{"OPTIONS", "https://a.example", 204, "https://a.example", "GET, HEAD, POST"},
+		{"POST", "https://a.example", 200, "https://a.example", ""},
+		{"OPTIONS", "https://evil.example", 204, "", ""},
+		{"POST", "https://evil.example", 200, "", ""},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+"/rpc/Arith.Add", nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("#%d: got status %d, want %d", i, resp.StatusCode, tt.status)
+		}
+		if g := resp.Header.Get("Access-Control-Allow-Origin"); g != tt.allowOrigin {
+			t.Errorf("#%d: Access-Control-Allow-Origin: got %q, want %q", i, g, tt.allowOrigin)
+		}
+		if g := resp.Header.Get("Access-Control-Allow-Methods"); g != tt.allowMethods {
+			t.Errorf("#%d: Access-Control-Allow-Methods: got %q, want %q", i, g, tt.allowMethods)
+		}
+	}
+}
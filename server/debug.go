@@ -0,0 +1,54 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"runtime"
+	"net/http"
+)
+
+// DebugSub is a Sub that reports the real-time statistics of a Server,
+// together with the number of live connections and goroutines, as a
+// JSON object.
+type DebugSub struct {
+	srv  *Server
+	auth func(req *http.Request) bool
+}
+
+// NewDebugSub creates a DebugSub reporting on srv. If auth is not nil,
+// only requests for which auth returns true are served; all others are
+// answered with a 403.
+func NewDebugSub(srv *Server, auth func(req *http.Request) bool) *DebugSub {
+	return &DebugSub{srv: srv, auth: auth}
+}
+
+type debugInfo struct {
+	StatsSnapshot
+	ActiveConnCount int
+	Goroutines      int
+}
+
+func (ds *DebugSub) Serve(q *Query) {
+	req := q.Req
+	if ds.auth != nil && !ds.auth(req) {
+		q.ContinueAndWrite(http.NewResponse403(req))
+		return
+	}
+	info := debugInfo{
+		StatsSnapshot:   ds.srv.Stats(),
+		ActiveConnCount: ds.srv.ConnCount(),
+		Goroutines:      runtime.Goroutines(),
+	}
+	body, err := json.Marshal(&info)
+	if err != nil {
+		q.ContinueAndWrite(http.NewResponse500(req))
+		return
+	}
+	resp := http.NewResponse200Bytes(req, body)
+	resp.Header = make(http.Header)
+	resp.Header.Set("Content-Type", "application/json")
+	q.ContinueAndWrite(resp)
+}
@@ -0,0 +1,62 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"net/http"
+)
+
+func TestDebugSub(t *testing.T) {
+	srv, err := NewServerEasy("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting server: %s", err)
+	}
+	defer srv.Shutdown()
+	srv.AddSub("/debug/", NewDebugSub(srv, nil))
+	srv.Launch(1)
+
+	resp, err := http.Get("http://" + srv.listen.Addr().String() + "/debug/")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status: got %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type: got %q", ct)
+	}
+	var info map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if n, ok := info["ActiveConnCount"].(float64); !ok || n < 1 {
+		t.Errorf("ActiveConnCount: got %v, want at least 1", info["ActiveConnCount"])
+	}
+	if _, ok := info["Goroutines"].(float64); !ok {
+		t.Errorf("missing Goroutines")
+	}
+}
+
+func TestDebugSubAuth(t *testing.T) {
+	srv, err := NewServerEasy("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting server: %s", err)
+	}
+	defer srv.Shutdown()
+	srv.AddSub("/debug/", NewDebugSub(srv, func(req *http.Request) bool { return false }))
+	srv.Launch(1)
+
+	resp, err := http.Get("http://" + srv.listen.Addr().String() + "/debug/")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("status: got %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"net/http"
+)
+
+// DefaultGzipMinBytes is the default value of Config.GzipMinBytes.
+const DefaultGzipMinBytes = 1024
+
+// DefaultGzipTypes is the default value of Config.GzipTypes.
+var DefaultGzipTypes = []string{"text/", "application/json", "application/javascript"}
+
+// acceptsGzip reports whether the Accept-Encoding header of req admits gzip.
+func acceptsGzip(req *http.Request) bool {
+	return http.NegotiateContentEncoding(req.Header, []string{"gzip"}, "identity") == "gzip"
+}
+
+func (c *Config) gzipType(contentType string) bool {
+	for _, t := range c.GzipTypes {
+		if strings.HasPrefix(contentType, strings.TrimRight(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponse compresses the body of resp, written in response to req,
+// if the client accepts gzip and the configuration calls for it. The caller
+// remains responsible for closing the original body. HEAD responses get the
+// same Content-Encoding and Vary as the GET would; if they come without a
+// body to measure, their compressed length is unknown, and they are marked
+// chunked in place of a Content-Length. A strong ETag is made weak, as the
+// compressed body differs from the one it was computed for.
+func (srv *Server) gzipResponse(req *http.Request, resp *http.Response) {
+	config := &srv.config
+	head := req.Method == "HEAD"
+	if !config.Gzip || resp.Body == nil && !head || !acceptsGzip(req) {
+		return
+	}
+	if resp.ContentLength <= int64(config.GzipMinBytes) || len(resp.TransferEncoding) > 0 {
+		return
+	}
+	if resp.Header.Get("Content-Encoding") != "" || !config.gzipType(resp.Header.Get("Content-Type")) {
+		return
+	}
+	if resp.Body == nil {
+		resp.ContentLength = -1
+		resp.TransferEncoding = []string{"chunked"}
+	} else {
+		raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, resp.ContentLength))
+		if err != nil {
+			resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewBuffer(raw), resp.Body))
+			return
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(raw)
+		zw.Close()
+		resp.Body = http.NewBodyBytes(buf.Bytes())
+		resp.ContentLength = int64(buf.Len())
+	}
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	if etag := resp.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("Etag", "W/"+etag)
+	}
+}
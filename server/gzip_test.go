@@ -0,0 +1,149 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"net/http"
+)
+
+var gzipTests = []struct {
+	path, contentType string
+	size              int
+	gzipped           bool
+}{
+	{"/text", "text/html; charset=utf-8", 4096, true},
+	{"/json", "application/json", 4096, true},
+	{"/small", "text/plain", 100, false},
+	{"/png", "image/png", 4096, false},
+}
+
+func TestGzip(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, Gzip: true}, func(q *Query) {
+		for _, tt := range gzipTests {
+			if q.Req.URL.Path == tt.path {
+				q.Continue()
+				q.WriteString(200, tt.contentType, strings.Repeat("a", tt.size))
+				return
+			}
+		}
+		q.ContinueAndWrite(http.NewResponse404(q.Req))
+	})
+	defer srv.Shutdown()
+
+	for _, tt := range gzipTests {
+		req, _ := http.NewRequest("GET", "http://"+addr+tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.path, err)
+		}
+		gzipped := resp.Header.Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzipped {
+			t.Errorf("%s: gzipped %v, want %v", tt.path, gzipped, tt.gzipped)
+		}
+		body := resp.Body
+		if gzipped {
+			if resp.ContentLength >= int64(tt.size) {
+				t.Errorf("%s: compressed length %d", tt.path, resp.ContentLength)
+			}
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatalf("%s: gzip: %s", tt.path, err)
+			}
+		}
+		b, err := ioutil.ReadAll(body)
+		resp.Body.Close()
+		if err != nil || len(b) != tt.size {
+			t.Errorf("%s: read %d bytes, %v", tt.path, len(b), err)
+		}
+	}
+
+	// Clients that do not accept gzip get the response as is
+	req, _ := http.NewRequest("GET", "http://"+addr+"/text", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if g := resp.Header.Get("Content-Encoding"); g != "" {
+		t.Errorf("refused gzip: got Content-Encoding %q", g)
+	}
+}
+
+func TestGzipHead(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, Gzip: true}, func(q *Query) {
+		q.Continue()
+		q.WriteString(200, "text/plain", strings.Repeat("a", 4096))
+	})
+	defer srv.Shutdown()
+
+	do := func(method string) *http.Response {
+		req, _ := http.NewRequest(method, "http://"+addr+"/text", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	get, head := do("GET"), do("HEAD")
+	for _, h := range []string{"Content-Encoding", "Vary", "Content-Length"} {
+		if g, e := head.Header.Get(h), get.Header.Get(h); g != e {
+			t.Errorf("HEAD %s: got %q, want %q as for GET", h, g, e)
+		}
+	}
+	if head.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("HEAD: not gzipped")
+	}
+}
+
+func TestGzipETag(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, Gzip: true}, func(q *Query) {
+		// Like StaticSub, answer HEAD from the length alone
+		resp := &http.Response{
+			Status:        "OK",
+			StatusCode:    200,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Request:       q.Req,
+			Header:        http.Header{"Content-Type": {"text/plain"}, "Etag": {`"abc"`}},
+			ContentLength: 4096,
+		}
+		if q.Req.Method != "HEAD" {
+			resp.Body = http.NewBodyString(strings.Repeat("a", 4096))
+		}
+		q.ContinueAndWrite(resp)
+	})
+	defer srv.Shutdown()
+
+	for _, tt := range []struct {
+		method, accept, etag, encoding string
+	}{
+		{"GET", "gzip", `W/"abc"`, "gzip"},
+		{"HEAD", "gzip", `W/"abc"`, "gzip"},
+		{"GET", "identity", `"abc"`, ""},
+		{"HEAD", "identity", `"abc"`, ""},
+	} {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+"/", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", tt.method, tt.accept, err)
+		}
+		resp.Body.Close()
+		if g := resp.Header.Get("Etag"); g != tt.etag {
+			t.Errorf("%s %s: got Etag %q, want %q", tt.method, tt.accept, g, tt.etag)
+		}
+		if g := resp.Header.Get("Content-Encoding"); g != tt.encoding {
+			t.Errorf("%s %s: got Content-Encoding %q, want %q", tt.method, tt.accept, g, tt.encoding)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+)
+
+type healthcfg struct {
+	livePath  string
+	readyPath string
+	checks    []func() error
+}
+
+// EnableHealthEndpoints makes the server answer requests for livePath and
+// readyPath itself, before they reach extensions, middleware, subs or Read.
+// The liveness path is always answered with a 200. The readiness path is
+// answered with a 200 as long as all readiness checks pass, and with a 503
+// once a check fails or Shutdown has begun; see Config.DrainGrace. Either
+// path may be left empty to disable it.
+func (srv *Server) EnableHealthEndpoints(livePath, readyPath string) {
+	srv.Lock()
+	defer srv.Unlock()
+	if srv.health == nil {
+		srv.health = &healthcfg{}
+	}
+	srv.health.livePath = livePath
+	srv.health.readyPath = readyPath
+}
+
+// AddReadinessCheck registers check to be consulted on every request for the
+// readiness path. A check returning a non-nil error marks the server as not ready.
+func (srv *Server) AddReadinessCheck(check func() error) {
+	srv.Lock()
+	defer srv.Unlock()
+	if srv.health == nil {
+		srv.health = &healthcfg{}
+	}
+	srv.health.checks = append(srv.health.checks, check)
+}
+
+// serveHealth answers q if it is a request for one of the health endpoints,
+// and reports whether it did so.
+func (srv *Server) serveHealth(q *Query) bool {
+	srv.Lock()
+	h := srv.health
+	if h == nil {
+		srv.Unlock()
+		return false
+	}
+	live, ready, draining := h.livePath, h.readyPath, srv.draining
+	checks := make([]func() error, len(h.checks))
+	copy(checks, h.checks)
+	srv.Unlock()
+
+	// Health responses skip the extensions' ReadRequest, but still pass
+	// through their WriteResponse on the way out.
+	q.Ext = make(map[string]interface{})
+	req := q.Req
+	switch {
+	case live != "" && q.origPath == live:
+		q.ContinueAndWrite(http.NewResponse200Bytes(req, []byte("ok\n")))
+	case ready != "" && q.origPath == ready:
+		if draining {
+			q.ContinueAndWrite(http.NewResponse503(req))
+			return true
+		}
+		for _, check := range checks {
+			if err := check(); err != nil {
+				resp := http.NewResponse503(req)
+				body := err.Error() + "\n"
+				resp.Body = http.NewBodyString(body)
+				resp.ContentLength = int64(len(body))
+				q.ContinueAndWrite(resp)
+				return true
+			}
+		}
+		q.ContinueAndWrite(http.NewResponse200Bytes(req, []byte("ok\n")))
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"net/http"
+)
+
+func getStatus(t *testing.T, url string) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %s", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		q.ContinueAndWrite(http.NewResponse404(q.Req))
+	})
+	defer srv.Shutdown()
+	srv.EnableHealthEndpoints("/healthz", "/readyz")
+
+	base := "http://" + addr
+	if code := getStatus(t, base+"/healthz"); code != 200 {
+		t.Errorf("liveness: got %d, want 200", code)
+	}
+	if code := getStatus(t, base+"/readyz"); code != 200 {
+		t.Errorf("readiness: got %d, want 200", code)
+	}
+	if code := getStatus(t, base+"/other"); code != 404 {
+		t.Errorf("other path: got %d, want 404 from handler", code)
+	}
+
+	var mu sync.Mutex
+	ready := true
+	srv.AddReadinessCheck(func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !ready {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+	if code := getStatus(t, base+"/readyz"); code != 200 {
+		t.Errorf("readiness with passing check: got %d, want 200", code)
+	}
+	mu.Lock()
+	ready = false
+	mu.Unlock()
+	if code := getStatus(t, base+"/readyz"); code != 503 {
+		t.Errorf("readiness with failing check: got %d, want 503", code)
+	}
+	if code := getStatus(t, base+"/healthz"); code != 200 {
+		t.Errorf("liveness with failing check: got %d, want 200", code)
+	}
+}
+
+func TestHealthDraining(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, DrainGrace: 5e8}, okHandler)
+	srv.EnableHealthEndpoints("/healthz", "/readyz")
+	base := "http://" + addr
+	if code := getStatus(t, base+"/readyz"); code != 200 {
+		t.Errorf("readiness: got %d, want 200", code)
+	}
+
+	done := make(chan bool)
+	go func() {
+		srv.Shutdown()
+		close(done)
+	}()
+	time.Sleep(1e8)
+	if code := getStatus(t, base+"/readyz"); code != 503 {
+		t.Errorf("readiness while draining: got %d, want 503", code)
+	}
+	if code := getStatus(t, base+"/healthz"); code != 200 {
+		t.Errorf("liveness while draining: got %d, want 200", code)
+	}
+	if code := getStatus(t, base+"/"); code != 200 {
+		t.Errorf("request while draining: got %d, want 200", code)
+	}
+	select {
+	case <-done:
+	case <-time.After(2e9):
+		t.Fatalf("Shutdown did not return after the grace period")
+	}
+	if _, err := http.Get(base + "/"); err == nil {
+		t.Errorf("request after Shutdown: got a response")
+	}
+}
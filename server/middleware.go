@@ -0,0 +1,47 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+// A Middleware is a module of server-side logic that gets to see every
+// query after extensions have been applied, and before it is handed to a
+// sub or returned by Server.Read. A middleware passes the query on by
+// calling next, which must be done before the middleware returns.
+// A middleware that responds to the query itself, say with q.ContinueAndWrite,
+// short-circuits the chain by not calling next.
+type Middleware func(q *Query, next func(*Query))
+
+// Use appends mw to the server's middleware chain.
+// Middlewares are invoked in the order in which they were added.
+func (srv *Server) Use(mw Middleware) {
+	srv.Lock()
+	defer srv.Unlock()
+	srv.mws = append(srv.mws, mw)
+}
+
+func (srv *Server) copyMiddleware() []Middleware {
+	srv.Lock()
+	defer srv.Unlock()
+
+	mws := make([]Middleware, len(srv.mws))
+	copy(mws, srv.mws)
+	return mws
+}
+
+// applyMiddleware runs q through the middleware chain. It returns the query
+// passed on by the last middleware, or nil if the chain was short-circuited.
+func (srv *Server) applyMiddleware(q *Query) *Query {
+	mws := srv.copyMiddleware()
+	var out *Query
+	var run func(i int, q *Query)
+	run = func(i int, q *Query) {
+		if i == len(mws) {
+			out = q
+			return
+		}
+		mws[i](q, func(q *Query) { run(i+1, q) })
+	}
+	run(0, q)
+	return out
+}
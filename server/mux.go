@@ -0,0 +1,145 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"net/http"
+)
+
+// Mux is a Sub that dispatches queries to handlers registered by method and
+// path pattern. A pattern is a path whose segments are matched literally,
+// except for segments starting with ':', which match any single non-empty
+// segment. Patterns are tried in the order in which they were registered.
+//
+// Method handling comes for free: HEAD is served by the GET handler, an
+// OPTIONS query to a pattern without an OPTIONS handler is answered with a
+// 204, and a query whose method has no handler with a 405. Both responses
+// carry an Allow header listing the methods registered for the pattern.
+// Queries that match no pattern are answered with a 404. Handlers take over
+// the query as a Sub would, and must call Continue or Hijack.
+type Mux struct {
+	sync.Mutex // protects routes and their handlers
+	routes     []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]func(*Query) // By method
+}
+
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+// Handle registers handler for queries with the given method to paths
+// matching pattern, replacing any handler registered for both before.
+func (m *Mux) Handle(method, pattern string, handler func(*Query)) {
+	m.Lock()
+	defer m.Unlock()
+	for _, r := range m.routes {
+		if r.pattern == pattern {
+			r.handlers[method] = handler
+			return
+		}
+	}
+	m.routes = append(m.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]func(*Query){method: handler},
+	})
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func (r *route) match(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, ":") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// allow returns the value of the Allow header for r, whose handlers may
+// be added to by Handle at any time.
+func (m *Mux) allow(r *route) string {
+	m.Lock()
+	defer m.Unlock()
+	methods := []string{"OPTIONS"}
+	for method := range r.handlers {
+		if method != "OPTIONS" && method != "HEAD" {
+			methods = append(methods, method)
+		}
+	}
+	if r.handlers["GET"] != nil || r.handlers["HEAD"] != nil {
+		methods = append(methods, "HEAD")
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// lookup returns the route matching path and its handler for method, if any.
+func (m *Mux) lookup(method, path string) (*route, func(*Query)) {
+	segments := splitPath(path)
+	m.Lock()
+	defer m.Unlock()
+	for _, r := range m.routes {
+		if !r.match(segments) {
+			continue
+		}
+		h := r.handlers[method]
+		if h == nil && method == "HEAD" {
+			h = r.handlers["GET"]
+		}
+		return r, h
+	}
+	return nil, nil
+}
+
+func (m *Mux) Serve(q *Query) {
+	req := q.Req
+	r, h := m.lookup(req.Method, req.URL.Path)
+	if r != nil {
+		q.routePattern = r.pattern
+	}
+	switch {
+	case r == nil:
+		q.ContinueAndWrite(http.NewResponse404(req))
+	case h != nil:
+		h(q)
+	case req.Method == "OPTIONS":
+		resp := http.NewResponse204(req)
+		resp.Header = http.Header{"Allow": {m.allow(r)}}
+		q.ContinueAndWrite(resp)
+	default:
+		resp := &http.Response{
+			Status:     http.StatusText(http.StatusMethodNotAllowed),
+			StatusCode: http.StatusMethodNotAllowed,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Request:    req,
+			Header:     http.Header{"Allow": {m.allow(r)}},
+		}
+		q.ContinueAndWrite(resp)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"net/http"
+)
+
+var muxTests = []struct {
+	method, path string
+	code         int
+	allow        string
+}{
+	{"GET", "/items/7", 200, ""},
+	{"HEAD", "/items/7", 200, ""},
+	{"POST", "/items/7", 200, ""},
+	{"DELETE", "/items/7", 405, "GET, HEAD, OPTIONS, POST"},
+	{"OPTIONS", "/items/7", 204, "GET, HEAD, OPTIONS, POST"},
+	{"GET", "/items", 404, ""},
+	{"GET", "/items/7/parts", 404, ""},
+	{"OPTIONS", "/custom", 200, ""},
+	{"PUT", "/custom", 405, "OPTIONS"},
+}
+
+func TestMux(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/items/:id", okHandler)
+	mux.Handle("POST", "/items/:id", okHandler)
+	mux.Handle("OPTIONS", "/custom", okHandler)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, mux.Serve)
+	defer srv.Shutdown()
+
+	for i, tt := range muxTests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %s %s: %s", i, tt.method, tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("#%d: %s %s: got status %d, want %d", i, tt.method, tt.path, resp.StatusCode, tt.code)
+		}
+		if g := resp.Header.Get("Allow"); g != tt.allow {
+			t.Errorf("#%d: %s %s: got Allow %q, want %q", i, tt.method, tt.path, g, tt.allow)
+		}
+	}
+}
+
+func TestMuxHandleWhileServing(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/items/:id", okHandler)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, mux.Serve)
+	defer srv.Shutdown()
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			mux.Handle(fmt.Sprintf("X-%d", i), "/items/:id", okHandler)
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest("OPTIONS", "http://"+addr+"/items/7", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("OPTIONS: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 204 {
+			t.Errorf("OPTIONS: got status %d, want 204", resp.StatusCode)
+		}
+	}
+	<-done
+}
+
+func TestRoutePattern(t *testing.T) {
+	patterns := make(chan string, 1)
+	mux := NewMux()
+	mux.Handle("GET", "/users/:id", func(q *Query) {
+		patterns <- q.RoutePattern()
+		okHandler(q)
+	})
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, mux.Serve)
+	defer srv.Shutdown()
+
+	resp, err := http.Get("http://" + addr + "/users/42")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if p := <-patterns; p != "/users/:id" {
+		t.Errorf("got pattern %q, want %q", p, "/users/:id")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strings"
+	"net/http"
+)
+
+// CleanPath normalizes the request path p by collapsing runs of slashes and
+// resolving "." and ".." elements. The result always begins with a slash, and
+// keeps a trailing slash if p has one. CleanPath returns ok=false if a ".."
+// element would climb above the root.
+func CleanPath(p string) (clean string, ok bool) {
+	elems := strings.Split(p, "/")
+	out := make([]string, 0, len(elems))
+	for _, e := range elems {
+		switch e {
+		case "", ".":
+		case "..":
+			if len(out) == 0 {
+				return "", false
+			}
+			out = out[:len(out)-1]
+		default:
+			out = append(out, e)
+		}
+	}
+	clean = "/" + strings.Join(out, "/")
+	if len(out) > 0 && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		clean += "/"
+	}
+	return clean, true
+}
+
+// cleanQueryPath applies CleanPath to the path of q, if the server is so
+// configured. Queries whose path escapes the root are answered with a 400,
+// in which case cleanQueryPath returns false.
+func (srv *Server) cleanQueryPath(q *Query) bool {
+	if !srv.config.CleanPaths {
+		return true
+	}
+	p, ok := CleanPath(q.Req.URL.Path)
+	if !ok {
+		q.Ext = make(map[string]interface{})
+		q.ContinueAndWrite(http.NewResponse400(q.Req))
+		return false
+	}
+	q.Req.URL.Path = p
+	q.origPath = p
+	return true
+}
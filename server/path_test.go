@@ -0,0 +1,91 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"net/http"
+)
+
+var cleanPathTests = []struct {
+	Path, Clean string
+	Ok          bool
+}{
+	{"/", "/", true},
+	{"", "/", true},
+	{"/a//b", "/a/b", true},
+	{"//a///b/", "/a/b/", true},
+	{"/a/./b", "/a/b", true},
+	{"/a/b/../c", "/a/c", true},
+	{"/a/b/..", "/a/", true},
+	{"/a/..", "/", true},
+	{"/static/../etc/passwd", "/etc/passwd", true},
+	{"/..", "", false},
+	{"/a/../../etc/passwd", "", false},
+	{"../etc/passwd", "", false},
+}
+
+func TestCleanPath(t *testing.T) {
+	for i, tt := range cleanPathTests {
+		clean, ok := CleanPath(tt.Path)
+		if clean != tt.Clean || ok != tt.Ok {
+			t.Errorf("#%d CleanPath(%q) = %q, %v; want %q, %v", i, tt.Path, clean, ok, tt.Clean, tt.Ok)
+		}
+	}
+}
+
+type recordSub struct {
+	paths chan string
+}
+
+func (rs *recordSub) Serve(q *Query) {
+	rs.paths <- q.Req.URL.Path
+	q.ContinueAndWrite(http.NewResponse200(q.Req))
+}
+
+func getRaw(t *testing.T, addr, path string) int {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: example.com\r\n\r\n", path)
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestCleanPaths(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, CleanPaths: true}, func(q *Query) {
+		q.ContinueAndWrite(http.NewResponse404(q.Req))
+	})
+	defer srv.Shutdown()
+	rs := &recordSub{make(chan string, 1)}
+	srv.AddSub("/static/", rs)
+
+	if code := getRaw(t, addr, "/static//a/./b"); code != 200 {
+		t.Fatalf("status: got %d, want 200", code)
+	}
+	if p := <-rs.paths; p != "a/b" {
+		t.Errorf("sub path: got %q, want %q", p, "a/b")
+	}
+	if code := getRaw(t, addr, "/static/../etc/passwd"); code != 404 {
+		t.Errorf("status: got %d, want 404", code)
+	}
+	if code := getRaw(t, addr, "/static/../../etc/passwd"); code != 400 {
+		t.Errorf("status: got %d, want 400", code)
+	}
+	select {
+	case p := <-rs.paths:
+		t.Errorf("sub reached with path %q", p)
+	default:
+	}
+}
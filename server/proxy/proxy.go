@@ -0,0 +1,276 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"github.com/petar/GoHTTP/server"
+)
+
+// Hop-by-hop headers, which are meaningful only for a single
+// connection and must not be forwarded (RFC 2616, Section 13.5.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy is a Sub that forwards queries to an upstream HTTP server and
+// streams its responses back. Connections to the upstream are reused, and at
+// most maxIdle of them are kept open while idle. A query whose upstream cannot
+// be reached is answered with a 502, and one whose upstream does not respond
+// in time with a 504.
+type ReverseProxy struct {
+	upstream *url.URL
+	timeout  int64 // Upstream read and write timeout, in nanoseconds
+	maxIdle  int
+
+	sync.Mutex // protects idle
+	idle       []*httputil.ClientConn
+}
+
+// NewReverseProxy creates a ReverseProxy that forwards to upstream, whose path,
+// if any, is prepended to the paths of forwarded requests. A zero timeout
+// means upstream connections never time out.
+func NewReverseProxy(upstream *url.URL, maxIdle int, timeout int64) *ReverseProxy {
+	return &ReverseProxy{
+		upstream: upstream,
+		timeout:  timeout,
+		maxIdle:  maxIdle,
+	}
+}
+
+// IdleConnCount returns the number of idle upstream connections.
+func (rp *ReverseProxy) IdleConnCount() int {
+	rp.Lock()
+	defer rp.Unlock()
+	return len(rp.idle)
+}
+
+func (rp *ReverseProxy) Serve(q *server.Query) {
+	q.Continue()
+	go rp.serve(q)
+}
+
+func (rp *ReverseProxy) serve(q *server.Query) {
+	req := q.Req
+	outreq := rp.rewrite(q)
+	cc, resp, err := rp.roundTrip(outreq)
+	if err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			q.WriteString(http.StatusGatewayTimeout, "text/plain", "upstream timed out\n")
+		} else {
+			q.WriteString(http.StatusBadGateway, "text/plain", "upstream unavailable\n")
+		}
+		return
+	}
+
+	// Relay the upstream response, handing the connection back
+	// to the pool once its body has been read to the end.
+	resp.Body = &upstreamBody{ReadCloser: resp.Body, rp: rp, cc: cc, reuse: !resp.Close}
+	resp.Request = req
+	resp.Close = false
+	removeHopHeaders(resp.Header)
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	if resp.ContentLength < 0 && req.ProtoAtLeast(1, 1) {
+		resp.TransferEncoding = []string{"chunked"}
+	} else {
+		resp.TransferEncoding = nil
+	}
+	q.Write(resp)
+}
+
+// rewrite returns the request to send upstream for q.
+func (rp *ReverseProxy) rewrite(q *server.Query) *http.Request {
+	req := q.Req
+	outreq := new(http.Request)
+	*outreq = *req
+	outreq.Proto, outreq.ProtoMajor, outreq.ProtoMinor = "HTTP/1.1", 1, 1
+	outreq.Close = false
+
+	u := *req.URL
+	u.Scheme = rp.upstream.Scheme
+	u.Host = rp.upstream.Host
+	u.Path = singleJoiningSlash(rp.upstream.Path, req.URL.Path)
+	outreq.URL = &u
+	outreq.Host = rp.upstream.Host
+
+	outreq.Header = req.Header.Clone()
+	if outreq.Header == nil {
+		outreq.Header = make(http.Header)
+	}
+	removeHopHeaders(outreq.Header)
+
+	// Only the part of X-Forwarded-For that the server vouches for is passed
+	// on, so that clients cannot forge entries, followed by the peer
+	hops := q.ForwardedFor()
+	if peer := q.RemoteAddr(); peer != nil {
+		if host, _, err := net.SplitHostPort(peer.String()); err == nil {
+			hops = append(hops, host)
+		}
+	}
+	outreq.Header.Del("X-Forwarded-For")
+	if len(hops) > 0 {
+		outreq.Header.Set("X-Forwarded-For", strings.Join(hops, ", "))
+	}
+	// Likewise, X-Forwarded-Proto is kept only from a trusted proxy
+	if !q.ViaTrustedProxy() {
+		outreq.Header.Del("X-Forwarded-Proto")
+	}
+	if req.TLS != nil {
+		appendHeader(outreq.Header, "X-Forwarded-Proto", "https")
+	} else {
+		appendHeader(outreq.Header, "X-Forwarded-Proto", "http")
+	}
+	return outreq
+}
+
+// roundTrip sends req upstream and reads the response headers. A request
+// without a body that fails on a pooled connection, which the upstream may
+// have closed in the meantime, is retried once on a fresh connection.
+func (rp *ReverseProxy) roundTrip(req *http.Request) (*httputil.ClientConn, *http.Response, error) {
+	cc := rp.getIdle()
+	if cc != nil {
+		resp, err := cc.Do(req)
+		if err == nil {
+			return cc, resp, nil
+		}
+		cc.Close()
+		if req.Body != nil {
+			return nil, nil, err
+		}
+	}
+	cc, err := rp.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := cc.Do(req)
+	if err != nil {
+		cc.Close()
+		return nil, nil, err
+	}
+	return cc, resp, nil
+}
+
+func (rp *ReverseProxy) dial() (*httputil.ClientConn, error) {
+	addr := rp.upstream.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr += ":80"
+	}
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if rp.timeout > 0 {
+		c.SetReadTimeout(rp.timeout)
+		c.SetWriteTimeout(rp.timeout)
+	}
+	return httputil.NewClientConn(c, nil), nil
+}
+
+func (rp *ReverseProxy) getIdle() *httputil.ClientConn {
+	rp.Lock()
+	defer rp.Unlock()
+	n := len(rp.idle)
+	if n == 0 {
+		return nil
+	}
+	cc := rp.idle[n-1]
+	rp.idle = rp.idle[:n-1]
+	return cc
+}
+
+func (rp *ReverseProxy) putIdle(cc *httputil.ClientConn) {
+	rp.Lock()
+	if len(rp.idle) < rp.maxIdle {
+		rp.idle = append(rp.idle, cc)
+		cc = nil
+	}
+	rp.Unlock()
+	if cc != nil {
+		cc.Close()
+	}
+}
+
+// upstreamBody is the body of an upstream response. On Close, its connection
+// returns to the pool if the body was read to the end and the upstream agreed
+// to keep it open. Otherwise the connection is closed.
+type upstreamBody struct {
+	io.ReadCloser
+	rp    *ReverseProxy
+	cc    *httputil.ClientConn
+	reuse bool
+	eof   bool
+}
+
+func (b *upstreamBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *upstreamBody) Close() error {
+	if b.cc == nil {
+		return errors.New("proxy: body closed twice")
+	}
+	if !b.eof {
+		// Bodies that were not read, such as empty ones, may be at their end anyway
+		var buf [1]byte
+		n, err := b.ReadCloser.Read(buf[:])
+		b.eof = n == 0 && err == io.EOF
+	}
+	err := b.ReadCloser.Close()
+	if b.reuse && b.eof && err == nil {
+		b.rp.putIdle(b.cc)
+	} else {
+		b.cc.Close()
+	}
+	b.cc = nil
+	return err
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, f := range strings.Split(h.Get("Connection"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			h.Del(f)
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+func appendHeader(h http.Header, key, value string) {
+	if prior := h.Get(key); prior != "" {
+		value = prior + ", " + value
+	}
+	h.Set(key, value)
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
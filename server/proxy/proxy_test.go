@@ -0,0 +1,181 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+	"net/http"
+	"net/url"
+	"github.com/petar/GoHTTP/server"
+)
+
+// startUpstream starts a server that echoes selected request headers.
+func startUpstream(t *testing.T) (*server.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 20)
+	go func() {
+		for {
+			q, err := srv.Read()
+			if err != nil {
+				return
+			}
+			req := q.Req
+			resp := http.NewResponse200Bytes(req, []byte(req.URL.Path))
+			resp.Header = http.Header{
+				"X-Host":          {req.Host},
+				"X-Got-Forwarded": {req.Header.Get("X-Forwarded-For")},
+				"X-Got-Proto":     {req.Header.Get("X-Forwarded-Proto")},
+				"X-Got-Custom":    {req.Header.Get("X-Custom")},
+				"Keep-Alive":      {"timeout=5"},
+			}
+			q.ContinueAndWrite(resp)
+		}
+	}()
+	return srv, l.Addr().String()
+}
+
+func startProxy(t *testing.T, upstream string) (*server.Server, *ReverseProxy, string) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 20)
+	rp := NewReverseProxy(u, 2, 2e9)
+	srv.AddSub("/api/", rp)
+	srv.Launch(1)
+	return srv, rp, "http://" + l.Addr().String()
+}
+
+// waitIdle gives the proxy time to return the upstream connection
+// to its pool, which happens after the response has been relayed.
+func waitIdle(rp *ReverseProxy, n int) {
+	for i := 0; i < 100 && rp.IdleConnCount() != n; i++ {
+		time.Sleep(1e7)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	up, upaddr := startUpstream(t)
+	defer up.Shutdown()
+	srv, rp, base := startProxy(t, "http://"+upaddr+"/v1")
+	defer srv.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", base+"/api/items", nil)
+		req.Header.Set("Connection", "X-Custom")
+		req.Header.Set("X-Custom", "secret")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: get: %s", i, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != "/v1/items" {
+			t.Fatalf("#%d: got status %d, body %q", i, resp.StatusCode, body)
+		}
+		h := resp.Header
+		if h.Get("X-Host") != upaddr {
+			t.Errorf("#%d: upstream saw Host %q, want %q", i, h.Get("X-Host"), upaddr)
+		}
+		// The client is not a trusted proxy, so its X-Forwarded-For is dropped
+		if g := h.Get("X-Got-Forwarded"); g != "127.0.0.1" {
+			t.Errorf("#%d: X-Forwarded-For: got %q", i, g)
+		}
+		if g := h.Get("X-Got-Proto"); g != "http" {
+			t.Errorf("#%d: X-Forwarded-Proto: got %q", i, g)
+		}
+		if g := h.Get("X-Got-Custom"); g != "" {
+			t.Errorf("#%d: header named by Connection was forwarded: %q", i, g)
+		}
+		if g := h.Get("Keep-Alive"); g != "" {
+			t.Errorf("#%d: hop-by-hop response header was relayed: %q", i, g)
+		}
+		waitIdle(rp, 1)
+	}
+	if n := up.Stats().AcceptConnCount; n != 1 {
+		t.Errorf("upstream accepted %d connections, want 1", n)
+	}
+	if n := rp.IdleConnCount(); n != 1 {
+		t.Errorf("%d idle upstream connections, want 1", n)
+	}
+}
+
+func TestReverseProxyTrustedForwardedFor(t *testing.T) {
+	up, upaddr := startUpstream(t)
+	defer up.Shutdown()
+	srv, _, base := startProxy(t, "http://"+upaddr)
+	defer srv.Shutdown()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	srv.SetTrustedProxies([]net.IPNet{*loopback})
+
+	req, _ := http.NewRequest("GET", base+"/api/x", nil)
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 192.0.2.7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if g := resp.Header.Get("X-Got-Forwarded"); g != "192.0.2.7, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For: got %q, want %q", g, "192.0.2.7, 127.0.0.1")
+	}
+}
+
+func TestReverseProxyForwardedProto(t *testing.T) {
+	up, upaddr := startUpstream(t)
+	defer up.Shutdown()
+	srv, _, base := startProxy(t, "http://"+upaddr)
+	defer srv.Shutdown()
+
+	get := func() string {
+		req, _ := http.NewRequest("GET", base+"/api/x", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get: %s", err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("X-Got-Proto")
+	}
+	// An untrusted client cannot claim that it connected over TLS
+	if g := get(); g != "http" {
+		t.Errorf("untrusted X-Forwarded-Proto: got %q, want %q", g, "http")
+	}
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	srv.SetTrustedProxies([]net.IPNet{*loopback})
+	if g := get(); g != "https, http" {
+		t.Errorf("trusted X-Forwarded-Proto: got %q, want %q", g, "https, http")
+	}
+}
+
+func TestReverseProxyBadGateway(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	srv, _, base := startProxy(t, "http://"+addr)
+	defer srv.Shutdown()
+
+	resp, err := http.Get(base + "/api/x")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 502 {
+		t.Errorf("got status %d, want 502", resp.StatusCode)
+	}
+}
@@ -5,8 +5,11 @@
 package server
 
 import (
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"strings"
 	"time"
 	"net/http"
@@ -16,7 +19,8 @@ import (
 // Incoming requests are presented to the user as a Query object.
 // Query allows users to respond to a request or to hijack the
 // underlying ServerConn, which is typically needed for CONNECT
-// requests.
+// requests. If the Server limits body size, Req.Body is a bounded
+// reader that fails with ErrBodyTooLarge beyond the limit.
 type Query struct {
 	Req *http.Request
 	Ext map[string]interface{} // Extension-specific structures
@@ -27,14 +31,95 @@ type Query struct {
 	err      error
 	fwd      bool // If true, the user has already called either Continue() or Hijack()
 	hijacked bool
+	body     *maxBodyReader        // Bounded request body, if the server limits body size
+	ecr      *expectContinueReader // Request body, if the client expects 100-continue
+	fill     *cacheFlight          // Cache flight to complete with the response, if any
+	uncached bool                  // If true, the response cache is not consulted
 
-	t0       int64 // Time request was received
+	t0           int64       // Time request was received
+	remoteAddr   net.Addr    // Network address of the peer
+	clientAddr   string      // Address of the client, possibly forwarded
+	clientIP     string      // IP of the client, as vouched for by trusted proxies
+	forwarded    []string    // Client and trusted proxies before the peer, in X-Forwarded-For order
+	viaTrusted   bool        // If true, the peer is a trusted proxy
+	localAddr    string      // Address the request was received on
+	routePattern string      // Mux pattern that matched the request, if any
+	trailer      http.Header // Trailer values of a streamed response, set with SetTrailer
 }
 
+var ErrWriteTimeout = errors.New("response write deadline exceeded")
+
 func newQueryErr(err error) *Query { return &Query{err: err} }
 
 func (q *Query) getError() error { return q.err }
 
+// RemoteAddr returns the network address of the peer of the connection
+// that delivered the request.
+func (q *Query) RemoteAddr() net.Addr { return q.remoteAddr }
+
+// ClientAddr returns the address of the client that sent the request.
+// It is ClientIP if the request was forwarded by trusted proxies, and
+// otherwise the string form of RemoteAddr, port included.
+func (q *Query) ClientAddr() string { return q.clientAddr }
+
+// ClientIP returns the IP address of the client that sent the request.
+// It is the IP of the connection's peer, unless that peer is one of the
+// proxies set with Server.SetTrustedProxies; see there for the trust model.
+func (q *Query) ClientIP() string { return q.clientIP }
+
+// ForwardedFor returns the part of the X-Forwarded-For header that the
+// trust model of Server.SetTrustedProxies vouches for: ClientIP, followed
+// by the trusted proxies that forwarded the request to the connection's
+// peer. It is empty unless the peer is a trusted proxy.
+func (q *Query) ForwardedFor() []string {
+	return append([]string(nil), q.forwarded...)
+}
+
+// ViaTrustedProxy reports whether the connection's peer is one of the
+// proxies set with Server.SetTrustedProxies, whose forwarding headers,
+// such as X-Forwarded-Proto, can be believed.
+func (q *Query) ViaTrustedProxy() bool { return q.viaTrusted }
+
+// IsHead reports whether the query is a HEAD request, whose response is sent
+// without a body. Handlers can use it to skip generating the body.
+func (q *Query) IsHead() bool { return q.Req != nil && q.Req.Method == "HEAD" }
+
+// LocalAddr returns the local network address on which the request was received.
+func (q *Query) LocalAddr() string { return q.localAddr }
+
+// RoutePattern returns the Mux pattern, such as "/users/:id", that matched
+// the request. It is set before the Mux hands the query to a handler, and is
+// empty for queries that were not routed by a Mux.
+func (q *Query) RoutePattern() string { return q.routePattern }
+
+// ExpectsContinue reports whether the client awaits a "100 Continue" interim
+// response before sending the request body. The interim response is sent
+// automatically when the body is first read. A handler can refuse the body
+// by writing a final response, such as a 417, without reading it.
+func (q *Query) ExpectsContinue() bool { return q.ecr != nil }
+
+// TLSInfo returns the TLS version and cipher suite negotiated on the
+// connection that delivered the request. For non-TLS connections, ok is false.
+func (q *Query) TLSInfo() (version uint16, cipher uint16, ok bool) {
+	if q.Req == nil || q.Req.TLS == nil {
+		return 0, 0, false
+	}
+	return q.Req.TLS.Version, q.Req.TLS.CipherSuite, true
+}
+
+// ReadTimeout returns the time, in nanoseconds, allowed for each read of the
+// request body; see Config.ReadTimeout. It is zero once the query has been
+// written or hijacked.
+func (q *Query) ReadTimeout() int64 {
+	if q.srv == nil {
+		return 0
+	}
+	return q.srv.config.ReadTimeout
+}
+
+// Time returns the time, in nanoseconds, when the request was received.
+func (q *Query) Time() int64 { return q.t0 }
+
 // Continue() indicates to the Server that it can continue
 // listening for incoming requests on the ServerConn that
 // delivered the request underlying this Query object.
@@ -62,6 +147,7 @@ func (q *Query) Hijack() *httputil.ServerConn {
 	}
 	q.fwd = true
 	q.hijacked = true
+	q.dropFill()
 	srv := q.srv
 	q.srv = nil
 	ssc := q.ssc
@@ -70,10 +156,22 @@ func (q *Query) Hijack() *httputil.ServerConn {
 	return ssc.ServerConn
 }
 
+// dropFill releases the requests waiting for the response to q in the
+// response cache, if any, when q is not going to be answered by Write.
+func (q *Query) dropFill() {
+	if q.fill != nil {
+		q.srv.cache.finish(q.fill, nil)
+		q.fill = nil
+	}
+}
+
 // Write sends resp back on the connection that produced the request.
 // Any non-nil error returned pertains to the ServerConn and not
 // to the Server as a whole.
 func (q *Query) Write(resp *http.Response) (err error) {
+	if ssc := q.ssc; ssc != nil {
+		defer ssc.end()
+	}
 	if resp.Body != nil {
 		defer func(b io.ReadCloser) { 
 			b.Close() 
@@ -85,6 +183,12 @@ func (q *Query) Write(resp *http.Response) (err error) {
 	ext := q.Ext
 	q.Ext = nil
 
+	// Offer the response to the cache before extensions alter it
+	if q.fill != nil {
+		q.srv.cache.fill(q.fill, req, resp)
+		q.fill = nil
+	}
+
 	// Invoke extensions in reverse order
 
 	p := q.origPath
@@ -100,8 +204,65 @@ func (q *Query) Write(resp *http.Response) (err error) {
 		}
 	}
 
+	// Every response carries a Date, unless the handler provided one
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if resp.Header.Get("Date") == "" {
+		resp.Header.Set("Date", time.UTC().Format(http.TimeFormat))
+	}
+
+	q.srv.gzipResponse(req, resp)
+
+	// Responses to HEAD keep the headers the handler computed, but no body.
+	// A body of unknown length is counted, so Content-Length tells the truth.
+	if req.Method == "HEAD" {
+		if resp.Body != nil && resp.ContentLength < 0 {
+			resp.ContentLength, _ = io.Copy(ioutil.Discard, resp.Body)
+		}
+		if resp.ContentLength < 0 {
+			resp.ContentLength = 0
+		}
+		resp.Body = nil
+		resp.TransferEncoding = nil
+		resp.Request = req
+	}
+
+	// Negotiate persistence with the client. HTTP/1.0 connections are
+	// kept alive only on request, and only if the response is delimited.
+	if req.Close {
+		resp.Close = true
+	} else if !req.ProtoAtLeast(1, 1) && !resp.Close {
+		if resp.ContentLength < 0 {
+			resp.Close = true
+		} else {
+			resp.Header.Set("Connection", "keep-alive")
+		}
+	}
+
+	// A partially read, oversized body cannot be skipped to get to the next
+	// request, and neither can a body that the client was never invited to send.
+	// Connections that are to be closed are buried after the response, since a
+	// concurrent read may be stuck waiting for the next request or draining the body.
+	if q.body != nil && q.body.exceeded || q.ecr != nil && !q.ecr.sent {
+		resp.Close = true
+	}
+	drop := resp.Close
+
+	// Bury connections whose response is overdue
+	if time.Now().UnixNano()-q.t0 > q.srv.config.WriteTimeout {
+		q.srv.stats.IncWriteTimeout()
+		q.srv.bury(q.ssc)
+		q.ssc = nil
+		q.srv = nil
+		return ErrWriteTimeout
+	}
+
 	err = q.ssc.Write(req, resp)
 	if err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			q.srv.stats.IncWriteTimeout()
+		}
 		log.Printf("Response Write: %s\n", err)
 		q.srv.bury(q.ssc)
 		q.ssc = nil
@@ -110,10 +271,146 @@ func (q *Query) Write(resp *http.Response) (err error) {
 	}
 	q.srv.stats.AddReqRespTime(time.Now().UnixNano() - q.t0)
 	q.srv.stats.IncResponse()
+	if drop || q.ssc.drained() {
+		q.srv.retire(q.ssc)
+		q.ssc = nil
+		q.srv = nil
+	}
 	return
 }
 
+// ContinueAndWrite calls Continue and then Write. Note that Continue refers
+// to reading further requests on the connection, and is unrelated to the
+// "100 Continue" interim response.
 func (q *Query) ContinueAndWrite(resp *http.Response) (err error) {
 	q.Continue()
 	return q.Write(resp)
 }
+
+// WriteString writes a response with the given status code, Content-Type
+// and body, setting Content-Length accordingly. Like Write, it does not
+// call Continue.
+func (q *Query) WriteString(status int, contentType, body string) error {
+	resp := &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       q.Req,
+		Header:        make(http.Header),
+		ContentLength: int64(len(body)),
+	}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	if len(body) > 0 {
+		resp.Body = http.NewBodyString(body)
+	}
+	return q.Write(resp)
+}
+
+// StreamResponse is like StreamResponseHeader with no headers.
+func (q *Query) StreamResponse(status int) (io.WriteCloser, error) {
+	return q.StreamResponseHeader(status, nil)
+}
+
+// StreamResponseHeader starts writing a response of unknown length with the
+// given status code and header, and returns a writer for its body. On HTTP/1.1
+// connections each Write is sent to the client as one chunk of the chunked
+// transfer coding, and Close sends the last chunk, after which the connection
+// is available to further requests. HTTP/1.0 clients do not understand chunking,
+// so their body is delimited by closing the connection instead. Close returns
+// the error, if any, of writing the response. Like Write, StreamResponseHeader
+// does not call Continue.
+//
+// A Trailer header in header declares the keys of a trailer, whose values are
+// set with SetTrailer while streaming and sent after the last chunk. Trailers
+// cannot be sent to HTTP/1.0 clients and are dropped for them.
+func (q *Query) StreamResponseHeader(status int, header http.Header) (io.WriteCloser, error) {
+	if q.Req.Method == "HEAD" {
+		return nil, errors.New("server: cannot stream a response to HEAD")
+	}
+	if header == nil {
+		header = make(http.Header)
+	}
+	var declared http.Header
+	if keys := header.Get("Trailer"); keys != "" {
+		declared = make(http.Header)
+		q.trailer = make(http.Header)
+		for _, key := range strings.Split(keys, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			declared[key] = nil
+			q.trailer[key] = nil
+		}
+		header.Del("Trailer")
+	}
+	pr, pw := io.Pipe()
+	resp := &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       q.Req,
+		Header:        header,
+		ContentLength: -1,
+		Trailer:       declared,
+	}
+	resp.Body = &streamBody{pr, resp, q.trailer}
+	if q.Req.ProtoAtLeast(1, 1) {
+		resp.TransferEncoding = []string{"chunked"}
+	}
+	sw := &streamWriter{pw: pw, done: make(chan error, 1)}
+	go func() { sw.done <- q.Write(resp) }()
+	return sw, nil
+}
+
+// streamWriter feeds the body of a streamed response. Write fails once
+// Query.Write has given up on the response, since Write closes the body.
+type streamWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) { return sw.pw.Write(p) }
+
+func (sw *streamWriter) Close() error {
+	sw.pw.Close()
+	return <-sw.done
+}
+
+// streamBody is the body of a streamed response. When the handler closes
+// the stream, it copies the trailer values set with SetTrailer into the
+// response. This happens on the goroutine writing the response, after the
+// header has been written and before the trailer is.
+type streamBody struct {
+	*io.PipeReader
+	resp   *http.Response
+	values http.Header
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.PipeReader.Read(p)
+	if err == io.EOF {
+		for k, vv := range b.values {
+			if vv != nil {
+				b.resp.Trailer[k] = vv
+			}
+		}
+	}
+	return n, err
+}
+
+// SetTrailer sets the trailer value for key in a response streamed with
+// StreamResponseHeader. The key must have been declared in the Trailer
+// header of the response, and the value must be set before the stream
+// is closed.
+func (q *Query) SetTrailer(key, value string) error {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := q.trailer[key]; !ok {
+		return errors.New("server: trailer key " + key + " was not declared")
+	}
+	q.trailer[key] = []string{value}
+	return nil
+}
@@ -0,0 +1,675 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"net/http"
+)
+
+// startTestServer starts a Server on a loopback port that hands each
+// query to handle.
+func startTestServer(t *testing.T, config Config, handle func(q *Query)) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, config, 20)
+	go func() {
+		for {
+			q, err := srv.Read()
+			if err != nil {
+				return
+			}
+			handle(q)
+		}
+	}()
+	return srv, l.Addr().String()
+}
+
+func expectContinueHandler(q *Query) {
+	if q.Req.URL.Path == "/reject" {
+		resp := http.NewResponse200(q.Req)
+		resp.StatusCode, resp.Status = 417, "Expectation Failed"
+		q.ContinueAndWrite(resp)
+		return
+	}
+	body, err := ioutil.ReadAll(q.Req.Body)
+	if err != nil {
+		q.ContinueAndWrite(http.NewResponse500(q.Req))
+		return
+	}
+	q.ContinueAndWrite(http.NewResponse200Bytes(q.Req, body))
+}
+
+func TestExpectContinueAccept(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, expectContinueHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	fmt.Fprintf(c, "POST /accept HTTP/1.1\r\nHost: example.com\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("interim response: got %q, %v", line, err)
+	}
+	if line, _ = br.ReadString('\n'); line != "\r\n" {
+		t.Fatalf("interim response end: got %q", line)
+	}
+	fmt.Fprintf(c, "hello")
+	resp, err := http.ReadResponse(br, &http.Request{Method: "POST"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "hello" {
+		t.Errorf("final response: got %d %q, want 200 %q", resp.StatusCode, body, "hello")
+	}
+}
+
+func TestExpectContinueReject(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, expectContinueHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	fmt.Fprintf(c, "POST /reject HTTP/1.1\r\nHost: example.com\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	resp, err := http.ReadResponse(br, &http.Request{Method: "POST"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	if resp.StatusCode != 417 {
+		t.Errorf("status: got %d, want 417", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Errorf("expected the connection to be closed after refusing the body")
+	}
+}
+
+func TestExpectContinueHTTP10(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, expectContinueHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	fmt.Fprintf(c, "POST /accept HTTP/1.0\r\nHost: example.com\r\n"+
+		"Expect: 100-continue\r\nContent-Length: 5\r\n\r\nhello")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if line == "HTTP/1.1 100 Continue\r\n" {
+		t.Errorf("HTTP/1.0 request received a 100 Continue")
+	}
+}
+
+func okHandler(q *Query) {
+	q.ContinueAndWrite(http.NewResponse200Bytes(q.Req, []byte("ok")))
+}
+
+var keepAliveTests = []struct {
+	Proto, Connection string
+	KeepAlive         bool
+	RespConnection    string
+}{
+	{"HTTP/1.1", "", true, ""},
+	{"HTTP/1.1", "close", false, "close"},
+	{"HTTP/1.0", "", false, "close"},
+	{"HTTP/1.0", "keep-alive", true, "keep-alive"},
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, okHandler)
+	defer srv.Shutdown()
+
+	for i, tt := range keepAliveTests {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		br := bufio.NewReader(c)
+		hdr := ""
+		if tt.Connection != "" {
+			hdr = "Connection: " + tt.Connection + "\r\n"
+		}
+		fmt.Fprintf(c, "GET / %s\r\nHost: example.com\r\n%s\r\n", tt.Proto, hdr)
+		resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("#%d: read response: %s", i, err)
+		}
+		ioutil.ReadAll(resp.Body)
+		if g := resp.Header.Get("Connection"); g != tt.RespConnection && !(tt.RespConnection == "close" && resp.Close) {
+			t.Errorf("#%d: Connection header: got %q, want %q", i, g, tt.RespConnection)
+		}
+
+		// A second request succeeds only on a persistent connection
+		fmt.Fprintf(c, "GET / %s\r\nHost: example.com\r\n%s\r\n", tt.Proto, hdr)
+		_, err = http.ReadResponse(br, &http.Request{Method: "GET"})
+		if alive := err == nil; alive != tt.KeepAlive {
+			t.Errorf("#%d: connection kept alive: %v, want %v", i, alive, tt.KeepAlive)
+		}
+		c.Close()
+	}
+}
+
+func TestDateHeader(t *testing.T) {
+	const fixed = "Sun, 06 Nov 1994 08:49:37 GMT"
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		resp := http.NewResponse200(q.Req)
+		if q.Req.URL.Path == "/fixed" {
+			resp.Header = http.Header{"Date": {fixed}}
+		}
+		q.ContinueAndWrite(resp)
+	})
+	defer srv.Shutdown()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if _, err = time.Parse(http.TimeFormat, resp.Header.Get("Date")); err != nil {
+		t.Errorf("Date %q: %s", resp.Header.Get("Date"), err)
+	}
+
+	resp, err = http.Get("http://" + addr + "/fixed")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if g := resp.Header.Get("Date"); g != fixed {
+		t.Errorf("handler Date: got %q, want %q", g, fixed)
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, TrustForwardedFor: true}, func(q *Query) {
+		resp := http.NewResponse200(q.Req)
+		resp.Header = http.Header{
+			"X-Remote": {q.RemoteAddr().String()},
+			"X-Type":   {fmt.Sprintf("%T", q.RemoteAddr())},
+			"X-Client": {q.ClientAddr()},
+		}
+		q.ContinueAndWrite(resp)
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\nX-Forwarded-For: 192.0.2.7, 10.0.0.1\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	resp.Body.Close()
+	if g, e := resp.Header.Get("X-Remote"), c.LocalAddr().String(); g != e {
+		t.Errorf("RemoteAddr: got %q, want %q", g, e)
+	}
+	if g, e := resp.Header.Get("X-Type"), "*net.TCPAddr"; g != e {
+		t.Errorf("RemoteAddr type: got %s, want %s", g, e)
+	}
+	if g, e := resp.Header.Get("X-Client"), "192.0.2.7"; g != e {
+		t.Errorf("ClientAddr: got %q, want %q", g, e)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		q.Continue()
+		q.WriteString(202, "text/plain; charset=utf-8", "short and stout\n")
+	})
+	defer srv.Shutdown()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if resp.StatusCode != 202 {
+		t.Errorf("status: got %d, want 202", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("content type: got %q", ct)
+	}
+	if resp.ContentLength != 16 || string(body) != "short and stout\n" {
+		t.Errorf("body: got %q with length %d", body, resp.ContentLength)
+	}
+}
+
+func TestWriteTimeoutSlowReader(t *testing.T) {
+	werr := make(chan error, 1)
+	big := make([]byte, 16<<20)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, WriteTimeout: 3e8}, func(q *Query) {
+		werr <- q.ContinueAndWrite(http.NewResponse200Bytes(q.Req, big))
+	})
+	defer srv.Shutdown()
+
+	// The client sends a request and never reads the response
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	select {
+	case err = <-werr:
+	case <-time.After(5e9):
+		t.Fatalf("response write is still blocked")
+	}
+	if err == nil {
+		t.Errorf("write to a client that does not read succeeded")
+	}
+	if n := srv.Stats().WriteTimeoutCount; n != 1 {
+		t.Errorf("got WriteTimeoutCount %d, want 1", n)
+	}
+	if n := srv.ConnCount(); n != 0 {
+		t.Errorf("got %d connections after the write timeout, want 0", n)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, IdleTimeout: 3e8}, okHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	c.SetReadTimeout(5e9)
+	br := bufio.NewReader(c)
+
+	// Requests spaced by less than the idle timeout share the connection
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("#%d: read response: %s", i, err)
+		}
+		ioutil.ReadAll(resp.Body)
+		time.Sleep(1e8)
+	}
+
+	// Once idle for longer, the connection is closed
+	t0 := time.Now().UnixNano()
+	if _, err = br.ReadByte(); err == nil {
+		t.Fatalf("idle connection was not closed")
+	}
+	if d := time.Now().UnixNano() - t0; d > 2e9 {
+		t.Errorf("idle connection closed after %dns, want about 3e8", d)
+	}
+}
+
+// trickle writes s to c one byte at a time, pausing for gap before each.
+func trickle(c net.Conn, s string, gap int64) {
+	for i := 0; i < len(s); i++ {
+		time.Sleep(time.Duration(gap))
+		if _, err := c.Write([]byte{s[i]}); err != nil {
+			return
+		}
+	}
+}
+
+func bodyLengthHandler(errc chan error) func(q *Query) {
+	return func(q *Query) {
+		q.Continue()
+		body, err := ioutil.ReadAll(q.Req.Body)
+		errc <- err
+		if err != nil {
+			q.Write(http.NewResponse400(q.Req))
+			return
+		}
+		q.WriteString(200, "text/plain", strconv.Itoa(len(body)))
+	}
+}
+
+func TestReadTimeoutSlowUpload(t *testing.T) {
+	// The upload takes longer than the idle timeout, but no read waits
+	// for longer than the read timeout
+	errc := make(chan error, 1)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, IdleTimeout: 2e8, ReadTimeout: 6e8}, bodyLengthHandler(errc))
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 8\r\n\r\n")
+	go trickle(c, "01234567", 1e8)
+	c.SetReadTimeout(5e9)
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "POST"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err = <-errc; err != nil {
+		t.Errorf("read body: %s", err)
+	}
+	if resp.StatusCode != 200 || string(body) != "8" {
+		t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, "8")
+	}
+}
+
+func TestReadTimeoutStalledUpload(t *testing.T) {
+	errc := make(chan error, 1)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, ReadTimeout: 3e8}, bodyLengthHandler(errc))
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	// The client sends part of the body, then stalls
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 8\r\n\r\n0123")
+	select {
+	case err = <-errc:
+	case <-time.After(5e9):
+		t.Fatalf("body read is still blocked")
+	}
+	nerr, ok := err.(net.Error)
+	if !ok || !nerr.Timeout() {
+		t.Errorf("read of a stalled body: got %v, want a timeout", err)
+	}
+}
+
+func TestLingerSlowReader(t *testing.T) {
+	big := make([]byte, 1<<20)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, Linger: 2e9}, func(q *Query) {
+		q.ContinueAndWrite(http.NewResponse200Bytes(q.Req, big))
+	})
+	defer srv.Shutdown()
+
+	// The client sends input that the server never reads, and takes its
+	// time to read the response
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	c.Write(make([]byte, 64<<10))
+	time.Sleep(3e8)
+	c.SetReadTimeout(5e9)
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("read body: %s", err)
+	}
+	if len(body) != len(big) {
+		t.Errorf("got %d bytes of the body, want %d", len(body), len(big))
+	}
+}
+
+func TestSlowHandlerNotExpired(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, IdleTimeout: 2e8}, func(q *Query) {
+		q.Continue()
+		go func() {
+			time.Sleep(8e8)
+			q.Write(http.NewResponse200Bytes(q.Req, []byte("late")))
+		}()
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.SetReadTimeout(5e9)
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "late" {
+		t.Errorf("got body %q, want %q", body, "late")
+	}
+
+	// Once answered, the connection is idle and expires as usual
+	if _, err = br.ReadByte(); err == nil {
+		t.Errorf("idle connection was not closed")
+	}
+	if n := srv.ConnCount(); n != 0 {
+		t.Errorf("got %d connections after expiry, want 0", n)
+	}
+}
+
+func TestOverdueResponseRefused(t *testing.T) {
+	errc := make(chan error, 1)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, IdleTimeout: 2e8, WriteTimeout: 4e8}, func(q *Query) {
+		q.Continue()
+		go func() {
+			time.Sleep(8e8)
+			errc <- q.Write(http.NewResponse200Bytes(q.Req, []byte("late")))
+		}()
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.SetReadTimeout(5e9)
+	if _, err = bufio.NewReader(c).ReadByte(); err == nil {
+		t.Errorf("got a response past the write deadline")
+	}
+	if err = <-errc; err != ErrWriteTimeout {
+		t.Errorf("Write: got %v, want ErrWriteTimeout", err)
+	}
+	// The connection was closed by Write, not expired while busy
+	if n := srv.Stats().ExpireConnCount; n != 0 {
+		t.Errorf("got %d expired connections, want 0", n)
+	}
+}
+
+func streamHandler(q *Query) {
+	q.Continue()
+	w, err := q.StreamResponseHeader(200, http.Header{"Content-Type": {"text/plain"}})
+	if err != nil {
+		q.Write(http.NewResponse500(q.Req))
+		return
+	}
+	go func() {
+		fmt.Fprintf(w, "hello, ")
+		fmt.Fprintf(w, "world")
+		w.Close()
+	}()
+}
+
+func TestStreamResponse(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, streamHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("#%d: read response: %s", i, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("#%d: read body: %s", i, err)
+		}
+		if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("#%d: transfer encoding: got %q, want chunked", i, resp.TransferEncoding)
+		}
+		if string(body) != "hello, world" || resp.Close {
+			t.Errorf("#%d: got body %q, close %v", i, body, resp.Close)
+		}
+	}
+}
+
+func TestStreamResponseHTTP10(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, streamHandler)
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.0\r\nHost: example.com\r\nConnection: keep-alive\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if len(resp.TransferEncoding) != 0 || string(body) != "hello, world" {
+		t.Errorf("got transfer encoding %q and body %q", resp.TransferEncoding, body)
+	}
+	if !resp.Close {
+		t.Errorf("expected the connection to be closed after an undelimited body")
+	}
+}
+
+func TestStreamTrailer(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		q.Continue()
+		// Echo the request trailer, read after the request body
+		var got string
+		if _, err := q.Req.ReadTrailer(); err == nil {
+			got = "early"
+		}
+		ioutil.ReadAll(q.Req.Body)
+		if trailer, err := q.Req.ReadTrailer(); err == nil {
+			got = trailer.Get("X-Sum")
+		}
+		w, err := q.StreamResponseHeader(200, http.Header{"Trailer": {"X-Sum, X-Unset"}})
+		if err != nil {
+			q.Write(http.NewResponse500(q.Req))
+			return
+		}
+		if q.SetTrailer("X-Undeclared", "x") == nil {
+			got = "undeclared"
+		}
+		go func() {
+			fmt.Fprintf(w, "body")
+			q.SetTrailer("x-sum", got)
+			w.Close()
+		}()
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	c.SetReadTimeout(2e9)
+	br := bufio.NewReader(c)
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: example.com\r\n"+
+			"Transfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n"+
+			"2\r\nab\r\n0\r\nX-Sum: %d\r\n\r\n", i)
+		resp, err := http.ReadResponse(br, &http.Request{Method: "POST"})
+		if err != nil {
+			t.Fatalf("#%d: read response: %s", i, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		trailer, err := resp.ReadTrailer()
+		if err != nil {
+			t.Fatalf("#%d: read trailer: %s", i, err)
+		}
+		if string(body) != "body" || trailer.Get("X-Sum") != strconv.Itoa(i) {
+			t.Errorf("#%d: got body %q, trailer %v", i, body, trailer)
+		}
+		if vv, ok := trailer["X-Unset"]; !ok || len(vv) != 0 {
+			t.Errorf("#%d: unset trailer key: got %q, %v", i, vv, ok)
+		}
+	}
+}
+
+func TestHeadSuppressesBody(t *testing.T) {
+	big := make([]byte, 1<<20)
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		resp := http.NewResponse200Bytes(q.Req, big)
+		resp.Header = http.Header{"Content-Type": {"application/octet-stream"}}
+		q.ContinueAndWrite(resp)
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	c.SetReadTimeout(2e9)
+	br := bufio.NewReader(c)
+
+	// The response to the second request follows the headers of the first
+	fmt.Fprintf(c, "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\nHEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	for i := 0; i < 2; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil || line != "HTTP/1.1 200 OK\r\n" {
+			t.Fatalf("#%d: status line %q, %v", i, line, err)
+		}
+		var clen, ctype string
+		for {
+			line, err = br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("#%d: read header: %s", i, err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			if strings.HasPrefix(line, "Content-Length: ") {
+				clen = line
+			}
+			if strings.HasPrefix(line, "Content-Type: ") {
+				ctype = line
+			}
+		}
+		if clen != "Content-Length: 1048576\r\n" || ctype != "Content-Type: application/octet-stream\r\n" {
+			t.Errorf("#%d: got %q and %q", i, clen, ctype)
+		}
+	}
+	c.(*net.TCPConn).CloseWrite()
+	if rest, _ := ioutil.ReadAll(br); len(rest) != 0 {
+		t.Errorf("%d body bytes followed the headers", len(rest))
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"container/list"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"net/http"
+)
+
+// rateLimiter maintains a token bucket per key, such as a remote IP.
+// At most maxRateLimitKeys buckets are kept, forgetting the least
+// recently used ones beyond that.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64 // Bucket capacity
+	buckets map[string]*bucket
+	lru     *list.List // Of *bucket, most recently used first
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   int64 // Time of last refill in nanoseconds
+	elem   *list.Element
+}
+
+const maxRateLimitKeys = 1 << 14
+
+func newRateLimiter(perSecond, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perSecond),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		lru:     list.New(),
+	}
+}
+
+// allow takes a token from key's bucket and reports whether one was available.
+// If not, it also returns the time in nanoseconds until one will be.
+func (rl *rateLimiter) allow(key string) (bool, int64) {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Nanoseconds()
+	b, ok := rl.buckets[key]
+	if ok {
+		rl.lru.MoveToFront(b.elem)
+	} else {
+		if rl.lru.Len() >= maxRateLimitKeys {
+			rl.remove(rl.lru.Back().Value.(*bucket))
+		}
+		b = &bucket{key: key, tokens: rl.burst, last: now}
+		b.elem = rl.lru.PushFront(b)
+		rl.buckets[key] = b
+	}
+	b.tokens += float64(now-b.last) / 1e9 * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, int64((1 - b.tokens) / rl.rate * 1e9)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// remove forgets b. The caller must hold the lock.
+func (rl *rateLimiter) remove(b *bucket) {
+	rl.buckets[b.key] = nil, false
+	rl.lru.Remove(b.elem)
+}
+
+// sweep forgets buckets that have refilled completely, since they are
+// indistinguishable from new ones.
+func (rl *rateLimiter) sweep() {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Nanoseconds()
+	for _, b := range rl.buckets {
+		if b.tokens+float64(now-b.last)/1e9*rl.rate >= rl.burst {
+			rl.remove(b)
+		}
+	}
+}
+
+// SetRateLimit limits the rate at which each remote IP can open connections
+// to perSecond, allowing bursts of up to burst connections. Connections in
+// excess of the limit receive a 429 response and are closed.
+// A non-positive perSecond removes the limit.
+func (srv *Server) SetRateLimit(perSecond int, burst int) {
+	srv.Lock()
+	defer srv.Unlock()
+	if perSecond <= 0 {
+		srv.rl = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	srv.rl = newRateLimiter(perSecond, burst)
+}
+
+func (srv *Server) getRateLimiter() *rateLimiter {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.rl
+}
+
+const response429 = "HTTP/1.1 429 Too Many Requests\r\n" +
+	"Content-Length: 0\r\n" +
+	"Connection: close\r\n\r\n"
+
+// remoteHost returns the IP that c was opened from.
+func remoteHost(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		host = c.RemoteAddr().String()
+	}
+	return host
+}
+
+// rateLimited reports whether c exceeds the per-IP rate limit,
+// in which case it answers c with a 429 response.
+func (srv *Server) rateLimited(c net.Conn) bool {
+	rl := srv.getRateLimiter()
+	if rl == nil {
+		return false
+	}
+	if ok, _ := rl.allow(remoteHost(c)); ok {
+		return false
+	}
+	srv.stats.IncDroppedRateLimit()
+	io.WriteString(c, response429)
+	return true
+}
+
+// admitConn counts c against the connections open from its IP, and reports
+// whether it stays within Config.MaxConnsPerIP. If not, it answers c with
+// a 429 response. Admitted connections must be released with releaseConn.
+func (srv *Server) admitConn(host string, c net.Conn) bool {
+	max := srv.config.MaxConnsPerIP
+	if max <= 0 {
+		return true
+	}
+	srv.Lock()
+	n := srv.perIP[host]
+	if n < max {
+		srv.perIP[host] = n + 1
+	}
+	srv.Unlock()
+	if n < max {
+		return true
+	}
+	srv.stats.IncDroppedPerIP()
+	io.WriteString(c, response429)
+	return false
+}
+
+func (srv *Server) releaseConn(host string) {
+	if srv.config.MaxConnsPerIP <= 0 {
+		return
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	if n := srv.perIP[host]; n > 1 {
+		srv.perIP[host] = n - 1
+	} else {
+		srv.perIP[host] = 0, false
+	}
+}
+
+// requestLimiter limits the rate of queries per key.
+type requestLimiter struct {
+	*rateLimiter
+	key func(q *Query) string
+}
+
+// SetRequestRateLimit limits the rate at which queries sharing a key are
+// served to perSecond, allowing bursts of up to burst queries. Queries in
+// excess of the limit are answered with a 429 response carrying a Retry-After
+// header, before they reach extensions, middleware, subs or Read, and are
+// counted in Stats. If key is nil, queries are keyed by Query.ClientIP;
+// otherwise key can, say, extract an API key from a header.
+// A non-positive perSecond removes the limit.
+func (srv *Server) SetRequestRateLimit(perSecond int, burst int, key func(q *Query) string) {
+	srv.Lock()
+	defer srv.Unlock()
+	if perSecond <= 0 {
+		srv.rrl = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if key == nil {
+		key = remoteIP
+	}
+	srv.rrl = &requestLimiter{newRateLimiter(perSecond, burst), key}
+}
+
+func remoteIP(q *Query) string { return q.ClientIP() }
+
+func (srv *Server) getRequestLimiter() *requestLimiter {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.rrl
+}
+
+// limitQuery answers q with a 429 response if it exceeds the request rate
+// limit, and reports whether it did so.
+func (srv *Server) limitQuery(q *Query) bool {
+	rrl := srv.getRequestLimiter()
+	if rrl == nil {
+		return false
+	}
+	ok, wait := rrl.allow(rrl.key(q))
+	if ok {
+		return false
+	}
+	srv.stats.IncRateLimited()
+	secs := (wait + 1e9 - 1) / 1e9
+	if secs < 1 {
+		secs = 1
+	}
+	resp := &http.Response{
+		Status:     "Too Many Requests",
+		StatusCode: 429,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    q.Req,
+		Header:     http.Header{"Retry-After": {strconv.Itoa(int(secs))}},
+	}
+	q.Ext = make(map[string]interface{})
+	q.ContinueAndWrite(resp)
+	return true
+}
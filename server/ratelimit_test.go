@@ -0,0 +1,152 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"net/http"
+)
+
+func getWithKey(t *testing.T, url, key string) *http.Response {
+	req, _ := http.NewRequest("GET", url, nil)
+	if key != "" {
+		req.Header.Set("X-Api-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRequestRateLimit(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, okHandler)
+	defer srv.Shutdown()
+	srv.SetRequestRateLimit(1, 2, nil)
+	url := "http://" + addr + "/"
+
+	for i := 0; i < 2; i++ {
+		if resp := getWithKey(t, url, ""); resp.StatusCode != 200 {
+			t.Fatalf("#%d: got status %d within burst", i, resp.StatusCode)
+		}
+	}
+	resp := getWithKey(t, url, "")
+	if resp.StatusCode != 429 {
+		t.Fatalf("got status %d beyond burst, want 429", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "1" {
+		t.Errorf("Retry-After: got %q, want %q", ra, "1")
+	}
+	if n := srv.Stats().RateLimitedCount; n != 1 {
+		t.Errorf("RateLimitedCount: got %d, want 1", n)
+	}
+}
+
+// Clients cannot escape the limit by claiming other addresses
+func TestRequestRateLimitForwarded(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, okHandler)
+	defer srv.Shutdown()
+	srv.SetRequestRateLimit(1, 1, nil)
+
+	for i, fwd := range []string{"192.0.2.1", "192.0.2.2"} {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+		req.Header.Set("X-Forwarded-For", fwd)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: get: %s", i, err)
+		}
+		resp.Body.Close()
+		if want := []int{200, 429}[i]; resp.StatusCode != want {
+			t.Errorf("#%d: X-Forwarded-For %s: got status %d, want %d", i, fwd, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRequestRateLimitKey(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, okHandler)
+	defer srv.Shutdown()
+	srv.SetRequestRateLimit(1, 1, func(q *Query) string {
+		return q.Req.Header.Get("X-Api-Key")
+	})
+	url := "http://" + addr + "/"
+
+	if resp := getWithKey(t, url, "a"); resp.StatusCode != 200 {
+		t.Errorf("key a: got status %d, want 200", resp.StatusCode)
+	}
+	if resp := getWithKey(t, url, "b"); resp.StatusCode != 200 {
+		t.Errorf("key b: got status %d, want 200", resp.StatusCode)
+	}
+	if resp := getWithKey(t, url, "a"); resp.StatusCode != 429 {
+		t.Errorf("key a again: got status %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	rl := newRateLimiter(1, 1)
+	for i := 0; i < maxRateLimitKeys+10; i++ {
+		rl.allow(strconv.Itoa(i))
+	}
+	if n := len(rl.buckets); n != maxRateLimitKeys {
+		t.Errorf("got %d buckets, want %d", n, maxRateLimitKeys)
+	}
+}
+
+func TestDroppedConns(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9, MaxConnsPerIP: 1}, okHandler)
+	defer srv.Shutdown()
+	srv.SetRateLimit(10, 1)
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		c.SetReadTimeout(2e9)
+		return c
+	}
+	refused := func(what string) {
+		c := dial()
+		defer c.Close()
+		wire, _ := ioutil.ReadAll(c)
+		if !strings.HasPrefix(string(wire), "HTTP/1.1 429") {
+			t.Errorf("%s: got response:\n%s", what, wire)
+		}
+	}
+
+	// The first connection stays open, taking the token and the per-IP slot
+	c := dial()
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"}); err != nil {
+		t.Fatalf("first connection: %s", err)
+	}
+	refused("rate limit")
+	time.Sleep(150e6)
+	refused("per-IP limit")
+
+	stats := srv.Stats()
+	if stats.DroppedRateLimit != 1 || stats.DroppedPerIP != 1 || stats.DroppedOverload != 0 {
+		t.Errorf("dropped %d for rate, %d per IP and %d for overload, want 1, 1 and 0",
+			stats.DroppedRateLimit, stats.DroppedPerIP, stats.DroppedOverload)
+	}
+
+	// Closing the first connection frees its slot
+	c.Close()
+	time.Sleep(150e6)
+	c = dial()
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"}); err != nil {
+		t.Errorf("after release: %s", err)
+	}
+}
@@ -5,12 +5,23 @@
 package rpc
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"github.com/petar/GoHTTP/http"
 )
 
 var (
-	ErrArg = os.NewError("bad or missing RPC argument")
+	ErrArg          = os.NewError("bad or missing RPC argument")
+	ErrBodyDecoded  = os.NewError("RPC request body already decoded")
+	ErrBodyStreamed = os.NewError("RPC request body already streamed")
 )
 
 // Args is the argument structure for incoming RPC calls.
@@ -21,11 +32,130 @@ type Args struct {
 	// Cookies holds the cookies included in the request
 	Cookies []*http.Cookie
 
-	// Query holds the decoded arguments from the request's URL
+	// Query holds the decoded arguments from the request's URL, followed
+	// by the fields of an application/x-www-form-urlencoded or
+	// multipart/form-data body. When a key appears in both, the URL
+	// values come first, so QueryString and QueryBool see the URL value.
 	Query   map[string][]string
 
+	// Files holds the file parts of a multipart/form-data body, in the order
+	// they were received. Files spooled to disk are removed once the call returns.
+	Files   []UploadedFile
+
 	// Body is the generic JSON-decoded version of the request body, or an empty map otherwise
 	Body    map[string]interface{}
+
+	// RawBody holds the bytes of the request body exactly as received
+	RawBody []byte
+
+	// Header holds the request header
+	Header  http.Header
+
+	body      io.Reader     // Unread request body, for methods registered with StreamRequestBody
+	streamed  bool          // Set once BodyReader has handed out body
+	maxMemory int64         // Bound on file contents held in memory, for ReadBody
+	req       *http.Request // Request, for its Content-Type and trailer
+}
+
+// BodyReader returns the request body as a stream, bounded by the maximum
+// body size of the RPC server. Body decoding and BodyReader are mutually
+// exclusive. For methods registered with StreamRequestBody, the body is left
+// unread, and Body and RawBody stay empty until ReadBody is called, directly
+// or through Decode or VerifyHMAC. Whichever of BodyReader and ReadBody is
+// called first wins: reading from BodyReader after ReadBody fails with
+// ErrBodyDecoded, and ReadBody after BodyReader fails with ErrBodyStreamed.
+// For all other methods, the body has already been decoded, and reading from
+// BodyReader fails with ErrBodyDecoded.
+func (a *Args) BodyReader() io.Reader {
+	if a.body == nil {
+		return errReader{ErrBodyDecoded}
+	}
+	a.streamed = true
+	return a.body
+}
+
+// ReadBody reads and decodes a request body left unread for a method
+// registered with StreamRequestBody into Body, RawBody and, for forms, Query
+// and Files, as the body of other methods is before they are called. It does
+// nothing if the body has been decoded already, and fails with
+// ErrBodyStreamed if it has been handed out by BodyReader.
+func (a *Args) ReadBody() os.Error {
+	if a.streamed {
+		return ErrBodyStreamed
+	}
+	if a.body == nil {
+		return nil
+	}
+	body := a.body
+	a.body = nil
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	a.RawBody = raw
+	return a.decodeBody(a.maxMemory)
+}
+
+// Trailer returns the trailer of a chunked request body. For methods
+// registered with StreamRequestBody, it fails with http.ErrTrailerUnread
+// until BodyReader has been read to its end.
+func (a *Args) Trailer() (http.Header, os.Error) {
+	if a.req == nil {
+		return nil, nil
+	}
+	return a.req.ReadTrailer()
+}
+
+type errReader struct {
+	err os.Error
+}
+
+func (r errReader) Read(p []byte) (int, os.Error) { return 0, r.err }
+
+// BasicAuth returns the username and password carried in the request's
+// Authorization header, if it uses HTTP Basic Authentication.
+// A missing or malformed header yields ok == false.
+func (a *Args) BasicAuth() (username, password string, ok bool) {
+	if a.Header == nil {
+		return "", "", false
+	}
+	return http.ParseBasicAuth(a.Header)
+}
+
+// VerifyHMAC reports whether the request header named header carries the
+// HMAC of RawBody keyed by secret, computed with the hash algo, which is one
+// of "sha1" or "sha256". The header value is the hex-encoded MAC, optionally
+// prefixed by the algorithm name and an equals sign, as in "sha256=9f86d0...".
+// The comparison takes constant time.
+func (a *Args) VerifyHMAC(header string, secret []byte, algo string) (bool, os.Error) {
+	var h hash.Hash
+	switch algo {
+	case "sha1":
+		h = hmac.New(sha1.New, secret)
+	case "sha256":
+		h = hmac.New(sha256.New, secret)
+	default:
+		return false, os.NewError("unsupported HMAC algorithm " + algo)
+	}
+	if a.Header == nil {
+		return false, ErrArg
+	}
+	if err := a.ReadBody(); err != nil {
+		return false, err
+	}
+	sig := a.Header.Get(header)
+	if sig == "" {
+		return false, ErrArg
+	}
+	if strings.HasPrefix(sig, algo+"=") {
+		sig = sig[len(algo)+1:]
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil {
+		return false, nil
+	}
+	h.Write(a.RawBody)
+	return subtle.ConstantTimeCompare(h.Sum(), mac) == 1, nil
 }
 
 func (a *Args) QueryBool(key string) (bool, os.Error) {
@@ -56,10 +186,77 @@ func (a *Args) QueryString(key string) (string, os.Error) {
 	return v[0], nil
 }
 
+// Cookie returns the first cookie in the request named name.
+func (a *Args) Cookie(name string) (*http.Cookie, os.Error) {
+	for _, c := range a.Cookies {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrArg
+}
+
+// CookieValue returns the value of the first cookie in the request named name.
+func (a *Args) CookieValue(name string) (string, os.Error) {
+	c, err := a.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
 // Ret is the return valyes structure of RPC calls
 type Ret struct {
 	SetCookies []*http.Cookie
 	Value      map[string]interface{}
+
+	// ETag, if non-empty, is sent with successful responses. Requests whose
+	// If-None-Match lists it are answered with 304 and no body instead.
+	ETag string
+
+	noContent bool
+	realm     string // Basic Authentication realm to challenge the client for, if non-empty
+
+	preEncoded  []byte // Response body to be sent verbatim, if non-nil
+	contentType string // Content-Type of preEncoded
+
+	stream *StreamEncoder // Streamed response body, if non-nil
+}
+
+// RequireBasicAuth instructs the RPC server to respond with a 401 status,
+// challenging the client to authenticate for realm with HTTP Basic
+// Authentication. Set-Cookies are still sent.
+func (r *Ret) RequireBasicAuth(realm string) {
+	r.realm = realm
+}
+
+// NoContent instructs the RPC server to respond with a 204 status and no body,
+// regardless of Value. Set-Cookies are still sent.
+func (r *Ret) NoContent() {
+	r.noContent = true
+}
+
+// SetPreEncoded instructs the RPC server to respond with body, written
+// verbatim with the given Content-Type, in place of the JSON encoding
+// of Value. Set-Cookies are still sent.
+func (r *Ret) SetPreEncoded(contentType string, body []byte) {
+	if body == nil {
+		body = []byte{}
+	}
+	r.preEncoded = body
+	r.contentType = contentType
+}
+
+// Stream returns an encoder for a result that is sent as a JSON array
+// of the encoded elements, in place of the JSON encoding of Value or a
+// body given to SetPreEncoded. Repeated calls return the same encoder.
+// Set-Cookies, as well as a 401 or 204 status requested with RequireBasicAuth
+// or NoContent, take effect as usual, in which case encoding fails.
+func (r *Ret) Stream() *StreamEncoder {
+	if r.stream == nil {
+		r.stream = &StreamEncoder{}
+	}
+	return r.stream
 }
 
 func (r *Ret) initIfZero() {
@@ -96,3 +293,51 @@ func (r *Ret) AddSetCookie(setCookie *http.Cookie) {
 	r.initIfZero()
 	r.SetCookies = append(r.SetCookies, setCookie)
 }
+
+// withCookieDefaults returns copies of cookies, with the attributes they leave
+// unset taken from d. If d is nil, cookies is returned as is.
+func withCookieDefaults(cookies []*http.Cookie, d *http.Cookie) []*http.Cookie {
+	if d == nil || len(cookies) == 0 {
+		return cookies
+	}
+	merged := make([]*http.Cookie, len(cookies))
+	for i, c := range cookies {
+		m := c.Clone()
+		if m.Path == "" {
+			m.Path = d.Path
+		}
+		if m.Domain == "" {
+			m.Domain = d.Domain
+		}
+		if m.SameSite == "" {
+			m.SameSite = d.SameSite
+		}
+		m.Secure = m.Secure || d.Secure
+		m.HttpOnly = m.HttpOnly || d.HttpOnly
+		merged[i] = m
+	}
+	return merged
+}
+
+// SetSessionCookie sends a cookie with the given name and value, valid for
+// the whole site. A positive maxAge bounds its lifetime in seconds, while a
+// zero maxAge makes it last for the browser session.
+func (r *Ret) SetSessionCookie(name, value string, maxAge int, secure, httpOnly bool) {
+	r.AddSetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+	})
+}
+
+// DeleteCookie instructs the client to delete the site-wide cookie named name.
+func (r *Ret) DeleteCookie(name string) {
+	r.AddSetCookie(&http.Cookie{
+		Name:   name,
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
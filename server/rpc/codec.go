@@ -5,13 +5,14 @@
 package rpc
 
 import (
-	//"log"
+	"bytes"
+	"io/ioutil"
 	"json"
+	"log"
 	"os"
 	"path"
 	"rpc"
 	"strings"
-	"url"
 	"github.com/petar/GoHTTP/http"
 	"github.com/petar/GoHTTP/server"
 )
@@ -27,6 +28,18 @@ type queryCodec struct {
 	// the read methods, which are guaranteed to be called sequentially
 	// by rpc.Server
 	seq uint64
+
+	maxBody   int64 // Maximum number of request body bytes to read
+	maxMemory int64 // Maximum number of uploaded file bytes to hold in memory
+
+	// readTimeout bounds the time for receiving a request body that is
+	// decoded, rather than streamed; see timedReader
+	readTimeout int64
+
+	args *Args // Decoded arguments, whose temporary files are removed after the response
+
+	streamBody     bool         // If true, the request body is left for Args.BodyReader
+	cookieDefaults *http.Cookie // Attributes for Set-Cookies that leave them unset, if non-nil
 }
 
 var ErrCodec = os.NewError("http/rpc codec")
@@ -68,24 +81,32 @@ func (qx *queryCodec) ReadRequestBody(args interface{}) (err os.Error) {
 	}
 
 	a := args.(*Args)
+	qx.args = a
 
 	// Save request method (GET, POST, PUT, UPDATE, etc.)
 	a.Method = qx.Query.Req.Method
 
-	// Decode URL arguments
-	a.Query, err = url.ParseQuery(qx.Query.Req.URL.RawQuery)
-	if err != nil {
-		return err
-	}
+	// Decode URL arguments, skipping malformed pairs
+	a.Query, _ = http.ParseQuery(qx.Query.Req.URL.RawQuery)
+
+	// Save request header
+	a.Header = qx.Query.Req.Header
+	a.req = qx.Query.Req
 
-	// Decode JSON body
+	// Read raw body, then decode form or JSON body
 	a.Body = make(map[string]interface{})
-	if qx.Query.Req.Body != nil {
-		dec := json.NewDecoder(qx.Query.Req.Body)
-		// We don't care if the decode is successful.
-		// The user will do their own complaining if they are missing expected arguments.
-		dec.Decode(a.Body)
+	if qx.streamBody && qx.Query.Req.Body != nil {
+		a.body = server.MaxBodyReader(qx.Query.Req.Body, qx.maxBody)
+		a.maxMemory = qx.maxMemory
+	} else if qx.Query.Req.Body != nil {
+		a.RawBody, err = ioutil.ReadAll(newTimedReader(server.MaxBodyReader(qx.Query.Req.Body, qx.maxBody), qx.readTimeout))
 		qx.Query.Req.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err = a.decodeBody(qx.maxMemory); err != nil {
+			return err
+		}
 	}
 
 	// Read the cookies associated with the request
@@ -94,9 +115,39 @@ func (qx *queryCodec) ReadRequestBody(args interface{}) (err os.Error) {
 	return nil
 }
 
+// decodeBody decodes RawBody according to the Content-Type of the request:
+// forms into Query and Files, and anything else as JSON into Body.
+func (a *Args) decodeBody(maxMemory int64) (err os.Error) {
+	body := bytes.NewBuffer(a.RawBody)
+	switch d, params := mediaType(a.req); d {
+	case "application/x-www-form-urlencoded":
+		err = readURLEncoded(body, a)
+	case "multipart/form-data":
+		err = readMultipart(body, params, a, maxMemory)
+	default:
+		dec := json.NewDecoder(body)
+		// We don't care if the decode is successful.
+		// The user will do their own complaining if they are missing expected arguments.
+		dec.Decode(a.Body)
+	}
+	if err != nil {
+		a.removeFiles()
+	}
+	return err
+}
+
 func (qx *queryCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os.Error) {
+	if qx.args != nil {
+		defer qx.args.removeFiles()
+	}
 
 	if resp.Error != "" {
+		if r, ok := ret.(*Ret); ok && r.stream != nil {
+			r.stream.abandon()
+		}
+		if resp.Error == ErrBodyTimeout.String() {
+			return qx.Query.Write(http.NewResponse408(qx.Query.Req))
+		}
 		return qx.Query.Write(http.NewResponse400String(qx.Query.Req, resp.Error))
 	}
 
@@ -105,20 +156,11 @@ func (qx *queryCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os
 	}
 
 	r := ret.(*Ret)
-
-	var body []byte
-	if r.Value != nil {
-		body, err = json.Marshal(r.Value)
-		if err != nil {
-			qx.Query.Write(http.NewResponse500(qx.Query.Req))
-			return err
-		}
-	}
-
-	httpResp := http.NewResponse200Bytes(qx.Query.Req, body)
-	httpResp.Header = make(http.Header)
-	for _, setCookie := range r.SetCookies {
-		httpResp.Header.Add("Set-Cookie", setCookie.String())
+	r.SetCookies = withCookieDefaults(r.SetCookies, qx.cookieDefaults)
+	httpResp, err := r.response(qx.Query.Req)
+	if err != nil {
+		qx.Query.Write(http.NewResponse500(qx.Query.Req))
+		return err
 	}
 
 	//dump, _ := http.DumpResponse(httpResp, true)
@@ -127,4 +169,63 @@ func (qx *queryCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os
 	return qx.Query.Write(httpResp)
 }
 
+// response builds the HTTP response to req that carries r.
+func (r *Ret) response(req *http.Request) (*http.Response, os.Error) {
+	var httpResp *http.Response
+	if r.realm != "" {
+		httpResp = http.NewResponse401(req, r.realm)
+	} else if r.noContent {
+		httpResp = http.NewResponse204(req)
+	} else if r.stream != nil {
+		httpResp = http.NewResponse200(req)
+		httpResp.Header = make(http.Header)
+		httpResp.Header.Set("Content-Type", "application/json")
+		httpResp.Body = r.stream.body()
+		httpResp.ContentLength = -1
+		httpResp.TransferEncoding = []string{"chunked"}
+	} else if r.preEncoded != nil {
+		httpResp = http.NewResponse200Bytes(req, r.preEncoded)
+		httpResp.Header = make(http.Header)
+		if r.contentType != "" {
+			httpResp.Header.Set("Content-Type", r.contentType)
+		}
+	} else {
+		var body []byte
+		if r.Value != nil {
+			mediaType, enc := negotiate(req.Header.Get("Accept"))
+			var err os.Error
+			body, err = enc(r.Value)
+			if err != nil {
+				return nil, err
+			}
+			httpResp = http.NewResponse200Bytes(req, body)
+			httpResp.Header = make(http.Header)
+			httpResp.Header.Set("Content-Type", mediaType)
+			httpResp.Header.Set("Vary", "Accept")
+		} else {
+			httpResp = http.NewResponse200(req)
+		}
+	}
+	if r.ETag != "" && httpResp.StatusCode == 200 {
+		if http.IfNoneMatch(req.Header, r.ETag) {
+			httpResp = http.NewResponse304(req, r.ETag)
+		} else {
+			if httpResp.Header == nil {
+				httpResp.Header = make(http.Header)
+			}
+			httpResp.Header.Set("Etag", r.ETag)
+		}
+	}
+	if r.stream != nil && (r.realm != "" || r.noContent || httpResp.StatusCode == 304) {
+		r.stream.abandon()
+	}
+	if httpResp.Header == nil {
+		httpResp.Header = make(http.Header)
+	}
+	if err := http.WriteSetCookies(httpResp.Header, r.SetCookies); err != nil {
+		log.Printf("RPC: %s\n", err)
+	}
+	return httpResp, nil
+}
+
 func (qx *queryCodec) Close() os.Error { return nil }
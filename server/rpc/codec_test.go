@@ -0,0 +1,456 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+func TestNoContent(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/s/Do", nil)
+	ret := &Ret{}
+	ret.SetString("ignored", "value")
+	ret.AddSetCookie(&http.Cookie{Name: "c", Value: "v"})
+	ret.NoContent()
+
+	resp, err := ret.response(req)
+	if err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if resp.StatusCode != 204 {
+		t.Errorf("status: got %d, want 204", resp.StatusCode)
+	}
+	if resp.Body != nil {
+		t.Errorf("expected no body")
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		t.Errorf("expected no Content-Type, got %q", ct)
+	}
+	if sc := resp.Header.Get("Set-Cookie"); sc != "c=v" {
+		t.Errorf("Set-Cookie: got %q, want %q", sc, "c=v")
+	}
+
+	var buf bytes.Buffer
+	if err = resp.Write(&buf); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	wire := buf.String()
+	if !strings.HasPrefix(wire, "HTTP/1.1 204 No Content\r\n") {
+		t.Errorf("bad status line in:\n%s", wire)
+	}
+	if !strings.HasSuffix(wire, "\r\n\r\n") {
+		t.Errorf("expected empty body in:\n%s", wire)
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	body := []byte(`{"event":"push"}`)
+	secret := []byte("s3cret")
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	good := "sha256=" + hex.EncodeToString(h.Sum())
+
+	a := &Args{RawBody: body, Header: http.Header{}}
+	a.Header.Set("X-Signature", good)
+	ok, err := a.VerifyHMAC("X-Signature", secret, "sha256")
+	if err != nil || !ok {
+		t.Errorf("correct signature: got (%v, %v), want (true, nil)", ok, err)
+	}
+
+	a.Header.Set("X-Signature", "sha256="+strings.Repeat("00", sha256.Size))
+	ok, err = a.VerifyHMAC("X-Signature", secret, "sha256")
+	if err != nil || ok {
+		t.Errorf("incorrect signature: got (%v, %v), want (false, nil)", ok, err)
+	}
+
+	ok, err = a.VerifyHMAC("X-Missing", secret, "sha256")
+	if err == nil || ok {
+		t.Errorf("missing header: got (%v, %v), want (false, error)", ok, err)
+	}
+}
+
+const testMultipartBody = "--b\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+	"hello\r\n" +
+	"--b\r\n" +
+	"Content-Disposition: form-data; name=\"small\"; filename=\"a.txt\"\r\n" +
+	"Content-Type: text/plain\r\n\r\n" +
+	"tiny\r\n" +
+	"--b\r\n" +
+	"Content-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n" +
+	"larger than the memory limit\r\n" +
+	"--b--\r\n"
+
+func TestReadMultipart(t *testing.T) {
+	a := &Args{Query: make(map[string][]string)}
+	err := readMultipart(strings.NewReader(testMultipartBody), map[string]string{"boundary": "b"}, a, 8)
+	if err != nil {
+		t.Fatalf("readMultipart: %s", err)
+	}
+	defer a.removeFiles()
+	if v := a.Query["title"]; len(v) != 1 || v[0] != "hello" {
+		t.Errorf("title: got %q", v)
+	}
+	if len(a.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(a.Files))
+	}
+	want := []struct {
+		Name, Filename, ContentType, Content string
+		Spooled                              bool
+	}{
+		{"small", "a.txt", "text/plain", "tiny", false},
+		{"big", "b.bin", "", "larger than the memory limit", true},
+	}
+	for i, w := range want {
+		f := &a.Files[i]
+		if f.Name != w.Name || f.Filename != w.Filename || f.ContentType != w.ContentType {
+			t.Errorf("file #%d: got %q %q %q", i, f.Name, f.Filename, f.ContentType)
+		}
+		if (f.tmpfile != "") != w.Spooled {
+			t.Errorf("file #%d: spooled %v, want %v", i, f.tmpfile != "", w.Spooled)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("file #%d: open: %s", i, err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(b) != w.Content || f.Size != int64(len(w.Content)) {
+			t.Errorf("file #%d: got %q (size %d), want %q", i, b, f.Size, w.Content)
+		}
+	}
+}
+
+func TestReadMultipartMalformed(t *testing.T) {
+	a := &Args{Query: make(map[string][]string)}
+	if err := readMultipart(strings.NewReader(testMultipartBody), map[string]string{}, a, 8); err != ErrArg {
+		t.Errorf("missing boundary: got %v, want ErrArg", err)
+	}
+	if err := readMultipart(strings.NewReader("garbage"), map[string]string{"boundary": "b"}, a, 8); err != ErrArg {
+		t.Errorf("bad body: got %v, want ErrArg", err)
+	}
+}
+
+func TestReadMultipartBodyErrors(t *testing.T) {
+	params := map[string]string{"boundary": "b"}
+	a := &Args{Query: make(map[string][]string)}
+	body := server.MaxBodyReader(ioutil.NopCloser(strings.NewReader(testMultipartBody)), 64)
+	if err := readMultipart(body, params, a, 8); err != ErrBodyTooLarge {
+		t.Errorf("beyond limit: got %v, want ErrBodyTooLarge", err)
+	}
+	a.removeFiles()
+
+	a = &Args{Query: make(map[string][]string)}
+	stalled := io.MultiReader(strings.NewReader(testMultipartBody[:64]), timeoutReader{})
+	if err := readMultipart(newTimedReader(stalled, 0), params, a, 8); err != ErrBodyTimeout {
+		t.Errorf("read timeout: got %v, want ErrBodyTimeout", err)
+	}
+	a.removeFiles()
+}
+
+func TestPreEncoded(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/s/Do", nil)
+	cached := []byte(`{"b":2, "a":[1,2,3]}`)
+	ret := &Ret{}
+	ret.SetString("ignored", "value")
+	ret.AddSetCookie(&http.Cookie{Name: "c", Value: "v"})
+	ret.SetPreEncoded("application/json; charset=utf-8", cached)
+
+	resp, err := ret.response(req)
+	if err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("status: got %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	if sc := resp.Header.Get("Set-Cookie"); sc != "c=v" {
+		t.Errorf("Set-Cookie: got %q, want %q", sc, "c=v")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %s", err)
+	}
+	if !bytes.Equal(body, cached) {
+		t.Errorf("body: got %q, want %q", body, cached)
+	}
+}
+
+func TestCookieHelpers(t *testing.T) {
+	a := &Args{Cookies: []*http.Cookie{
+		&http.Cookie{Name: "a", Value: "1"},
+		&http.Cookie{Name: "sid", Value: "xyz"},
+	}}
+	if v, err := a.CookieValue("sid"); err != nil || v != "xyz" {
+		t.Errorf("CookieValue(sid): got (%q, %v)", v, err)
+	}
+	if c, err := a.Cookie("missing"); err != ErrArg || c != nil {
+		t.Errorf("Cookie(missing): got (%v, %v), want (nil, ErrArg)", c, err)
+	}
+
+	ret := &Ret{}
+	ret.SetSessionCookie("sid", "xyz", 3600, true, true)
+	ret.DeleteCookie("old")
+	want := []string{
+		"sid=xyz; Path=/; Max-Age=3600; HttpOnly; Secure",
+		"old=; Path=/; Max-Age=0",
+	}
+	if len(ret.SetCookies) != len(want) {
+		t.Fatalf("got %d Set-Cookies, want %d", len(ret.SetCookies), len(want))
+	}
+	for i, c := range ret.SetCookies {
+		if g := c.String(); g != want[i] {
+			t.Errorf("Set-Cookie #%d: got %q, want %q", i, g, want[i])
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/s/Do", nil)
+	ret := &Ret{}
+	ret.SetString("ignored", "value")
+	enc := ret.Stream()
+	if err := enc.Encode(1); err != nil {
+		t.Fatalf("encode before response: %s", err)
+	}
+	go func() {
+		for i := 2; i <= 3; i++ {
+			enc.Encode(map[string]int{"i": i})
+		}
+		enc.Close()
+	}()
+
+	resp, err := ret.response(req)
+	if err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("transfer encoding: got %q, want chunked", resp.TransferEncoding)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read body: %s", err)
+	}
+	if want := `[1,{"i":2},{"i":3}]`; string(body) != want {
+		t.Errorf("body: got %s, want %s", body, want)
+	}
+}
+
+func TestStreamAbandoned(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/s/Do", nil)
+	ret := &Ret{}
+	enc := ret.Stream()
+	ret.NoContent()
+	if _, err := ret.response(req); err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if err := enc.Encode(1); err == nil {
+		t.Errorf("expected Encode to fail once the stream was abandoned")
+	}
+}
+
+func TestBodyReader(t *testing.T) {
+	read := func(body string, stream bool) (string, os.Error) {
+		req, _ := http.NewRequest("POST", "http://example.com/s/Upload", strings.NewReader(body))
+		qx := &queryCodec{Query: &server.Query{Req: req}, seq: 1, maxBody: 10, streamBody: stream}
+		a := &Args{}
+		if err := qx.ReadRequestBody(a); err != nil {
+			t.Fatalf("ReadRequestBody: %s", err)
+		}
+		if stream && (len(a.Body) != 0 || a.RawBody != nil) {
+			t.Errorf("streamed body was also decoded")
+		}
+		b, err := ioutil.ReadAll(a.BodyReader())
+		return string(b), err
+	}
+	if b, err := read("0123456789", true); err != nil || b != "0123456789" {
+		t.Errorf("within limit: got %q, %v", b, err)
+	}
+	if _, err := read("0123456789abc", true); err != ErrBodyTooLarge {
+		t.Errorf("beyond limit: got %v, want ErrBodyTooLarge", err)
+	}
+	if _, err := read(`{"a":1}`, false); err != ErrBodyDecoded {
+		t.Errorf("decoded body: got %v, want ErrBodyDecoded", err)
+	}
+}
+
+func TestBodyFirstAccessWins(t *testing.T) {
+	args := func() *Args {
+		req, _ := http.NewRequest("POST", "http://example.com/s/Upload", strings.NewReader(`{"user":{"name":"ann"}}`))
+		qx := &queryCodec{Query: &server.Query{Req: req}, seq: 1, maxBody: 100, streamBody: true}
+		a := &Args{}
+		if err := qx.ReadRequestBody(a); err != nil {
+			t.Fatalf("ReadRequestBody: %s", err)
+		}
+		return a
+	}
+	var v struct {
+		Name string `rpc:"body=user.name"`
+	}
+
+	// Decoding first reads the body, which can no longer be streamed
+	a := args()
+	if err := a.Decode(&v); err != nil || v.Name != "ann" {
+		t.Errorf("Decode: got %q, %v", v.Name, err)
+	}
+	if string(a.RawBody) != `{"user":{"name":"ann"}}` {
+		t.Errorf("RawBody: got %q", a.RawBody)
+	}
+	if _, err := ioutil.ReadAll(a.BodyReader()); err != ErrBodyDecoded {
+		t.Errorf("BodyReader after Decode: got %v, want ErrBodyDecoded", err)
+	}
+
+	// Streaming first leaves nothing to decode
+	a = args()
+	if b, err := ioutil.ReadAll(a.BodyReader()); err != nil || len(b) == 0 {
+		t.Errorf("BodyReader: got %q, %v", b, err)
+	}
+	if err := a.Decode(&v); err != ErrBodyStreamed {
+		t.Errorf("Decode after BodyReader: got %v, want ErrBodyStreamed", err)
+	}
+	if _, err := a.VerifyHMAC("X-Sig", []byte("k"), "sha256"); err != ErrBodyStreamed {
+		t.Errorf("VerifyHMAC after BodyReader: got %v, want ErrBodyStreamed", err)
+	}
+}
+
+func TestReadQuery(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/s/Do", strings.NewReader("d=%&e=x+y&a=3"))
+	req.URL.RawQuery = "a=1&b=%zz&c=2"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	qx := &queryCodec{Query: &server.Query{Req: req}, seq: 1, maxBody: 100}
+	a := &Args{}
+	if err := qx.ReadRequestBody(a); err != nil {
+		t.Fatalf("ReadRequestBody: %s", err)
+	}
+	want := map[string][]string{"a": {"1", "3"}, "c": {"2"}, "e": {"x y"}}
+	if !reflect.DeepEqual(a.Query, want) {
+		t.Errorf("got %v, want %v", a.Query, want)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) String() string  { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type timeoutReader struct{}
+
+func (timeoutReader) Read(p []byte) (int, os.Error) { return 0, timeoutError{} }
+
+func TestTimedReader(t *testing.T) {
+	r := newTimedReader(strings.NewReader("abc"), 1e9)
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "abc" {
+		t.Errorf("before deadline: got %q, %v", b, err)
+	}
+	r.deadline = time.Nanoseconds() - 1
+	if _, err := r.Read(make([]byte, 1)); err != ErrBodyTimeout {
+		t.Errorf("after deadline: got %v, want ErrBodyTimeout", err)
+	}
+	if _, err := newTimedReader(timeoutReader{}, 0).Read(make([]byte, 1)); err != ErrBodyTimeout {
+		t.Errorf("read timeout: got %v, want ErrBodyTimeout", err)
+	}
+}
+
+func TestETag(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/s/Get", nil)
+	ret := &Ret{Value: map[string]interface{}{"a": 1}, ETag: `"v1"`}
+	resp, err := ret.response(req)
+	if err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Etag") != `"v1"` {
+		t.Errorf("got status %d, ETag %q", resp.StatusCode, resp.Header.Get("Etag"))
+	}
+
+	req.Header.Set("If-None-Match", `"v0", W/"v1"`)
+	ret.SetCookies = []*http.Cookie{&http.Cookie{Name: "a", Value: "b"}}
+	if resp, err = ret.response(req); err != nil {
+		t.Fatalf("response: %s", err)
+	}
+	if resp.StatusCode != 304 || resp.Body != nil || resp.ContentLength != 0 {
+		t.Errorf("conditional: got status %d, length %d", resp.StatusCode, resp.ContentLength)
+	}
+	if resp.Header.Get("Etag") != `"v1"` || resp.Header.Get("Set-Cookie") == "" {
+		t.Errorf("conditional: got header %v", resp.Header)
+	}
+}
+
+func TestCookieDefaults(t *testing.T) {
+	d := &http.Cookie{Path: "/", Domain: "example.com", SameSite: "Lax", Secure: true, HttpOnly: true}
+	plain := &http.Cookie{Name: "a", Value: "1"}
+	own := &http.Cookie{Name: "b", Value: "2", Path: "/app", SameSite: "Strict"}
+	merged := withCookieDefaults([]*http.Cookie{plain, own}, d)
+	if g := merged[0].String(); g != "a=1; Path=/; Domain=example.com; HttpOnly; Secure; SameSite=Lax" {
+		t.Errorf("inherited: got %q", g)
+	}
+	if g := merged[1].String(); g != "b=2; Path=/app; Domain=example.com; HttpOnly; Secure; SameSite=Strict" {
+		t.Errorf("explicit: got %q", g)
+	}
+	if plain.Path != "" || plain.Secure {
+		t.Errorf("defaults were applied to the handler's cookie")
+	}
+	if c := withCookieDefaults([]*http.Cookie{plain}, nil); c[0] != plain {
+		t.Errorf("nil defaults changed the cookies")
+	}
+}
+
+func TestEncoderNegotiation(t *testing.T) {
+	RegisterEncoder("text/plain", func(value map[string]interface{}) ([]byte, os.Error) {
+		return []byte(fmt.Sprint(value["n"])), nil
+	})
+	ret := &Ret{Value: map[string]interface{}{
+		"n":    1,
+		"list": []interface{}{"a<b", true},
+		"a b":  map[string]interface{}{"x": nil},
+	}}
+	tests := []struct {
+		accept, contentType, body string
+	}{
+		{"", "application/json", ""},
+		{"image/png, */*", "application/json", ""},
+		{"text/xml;q=0.5, application/json;q=0.9", "application/json", ""},
+		{"text/html, application/xml", "application/xml", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<response><entry name="a b"><x></x></entry><list><item>a&lt;b</item><item>true</item></list><n>1</n></response>`},
+		{"application/json;q=0, text/plain", "text/plain", "1"},
+		{"text/*;q=0.2, application/*;q=0.5", "application/json", ""},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/s/Get", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := ret.response(req)
+		if err != nil {
+			t.Fatalf("#%d: response: %s", i, err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("#%d: Content-Type: got %q, want %q", i, ct, tt.contentType)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("#%d: body: got %q, want %q", i, body, tt.body)
+		}
+	}
+}
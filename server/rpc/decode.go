@@ -0,0 +1,263 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MissingArgsError is returned by Args.Decode when required arguments
+// are absent from the request. Keys lists them in the form of their tags.
+type MissingArgsError struct {
+	Keys []string
+}
+
+func (e *MissingArgsError) String() string {
+	return "missing RPC arguments: " + strings.Join(e.Keys, ", ")
+}
+
+// Decode populates the fields of the struct pointed to by v from the request,
+// as directed by field tags of the form
+//
+//	`rpc:"query=limit"`      first value of the URL or form argument "limit"
+//	`rpc:"body=user.name"`   field "name" of object "user" in the JSON body
+//	`rpc:"cookie=sid"`       value of the cookie "sid"
+//
+// Fields of kind string, bool, int, uint and float are converted from
+// the argument's value, and slices of such kinds receive all values of
+// a repeated query argument or the elements of a JSON array. Untagged
+// fields are left alone. An argument is required unless its tag ends in
+// ",omitempty"; Decode reports all missing required arguments together in
+// a *MissingArgsError. A body argument makes Decode call ReadBody first.
+func (a *Args) Decode(v interface{}) os.Error {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.Elem().Kind() != reflect.Struct {
+		return os.NewError("rpc: Decode needs a pointer to a struct")
+	}
+	sv := pv.Elem()
+	st := sv.Type()
+	var missing []string
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		tag := f.Tag.Get("rpc")
+		if tag == "" || f.PkgPath != "" {
+			continue
+		}
+		omitempty := false
+		if j := strings.Index(tag, ","); j >= 0 {
+			omitempty = tag[j+1:] == "omitempty"
+			tag = tag[:j]
+		}
+		j := strings.Index(tag, "=")
+		if j < 0 {
+			return os.NewError("rpc: malformed tag on field " + f.Name)
+		}
+		if tag[:j] == "body" {
+			if err := a.ReadBody(); err != nil {
+				return err
+			}
+		}
+		vals, ok := a.lookup(tag[:j], tag[j+1:])
+		if !ok {
+			if !omitempty {
+				missing = append(missing, tag)
+			}
+			continue
+		}
+		if err := setField(sv.Field(i), vals); err != nil {
+			return os.NewError("rpc: bad argument " + tag + ": " + err.String())
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingArgsError{missing}
+	}
+	return nil
+}
+
+// lookup returns the values of the argument key from the given source.
+func (a *Args) lookup(source, key string) ([]interface{}, bool) {
+	switch source {
+	case "query":
+		vs := a.Query[key]
+		if len(vs) == 0 {
+			return nil, false
+		}
+		vals := make([]interface{}, len(vs))
+		for i, s := range vs {
+			vals[i] = s
+		}
+		return vals, true
+	case "body":
+		var x interface{} = a.Body
+		for _, k := range strings.Split(key, ".") {
+			m, ok := x.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if x, ok = m[k]; !ok || x == nil {
+				return nil, false
+			}
+		}
+		if arr, ok := x.([]interface{}); ok {
+			return arr, true
+		}
+		return []interface{}{x}, true
+	case "cookie":
+		s, err := a.CookieValue(key)
+		if err != nil {
+			return nil, false
+		}
+		return []interface{}{s}, true
+	}
+	return nil, false
+}
+
+// setField stores vals in f, converting them to f's type. Non-slice
+// fields receive the first value, and cannot take an empty JSON array.
+func setField(f reflect.Value, vals []interface{}) os.Error {
+	if f.Kind() != reflect.Slice {
+		if len(vals) == 0 {
+			return ErrArg
+		}
+		return setScalar(f, vals[0])
+	}
+	s := reflect.MakeSlice(f.Type(), len(vals), len(vals))
+	for i, x := range vals {
+		if err := setScalar(s.Index(i), x); err != nil {
+			return err
+		}
+	}
+	f.Set(s)
+	return nil
+}
+
+func setScalar(f reflect.Value, x interface{}) os.Error {
+	switch f.Kind() {
+	case reflect.String:
+		switch y := x.(type) {
+		case string:
+			f.SetString(y)
+		case float64, bool:
+			f.SetString(fmt.Sprint(y))
+		default:
+			return ErrArg
+		}
+	case reflect.Bool:
+		switch y := x.(type) {
+		case bool:
+			f.SetBool(y)
+		case string:
+			b, err := parseBool(y)
+			if err != nil {
+				return err
+			}
+			f.SetBool(b)
+		default:
+			return ErrArg
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch y := x.(type) {
+		case float64:
+			if y != float64(int64(y)) {
+				return ErrArg
+			}
+			n = int64(y)
+		case string:
+			var err os.Error
+			if n, err = strconv.Atoi64(y); err != nil {
+				return err
+			}
+		default:
+			return ErrArg
+		}
+		// Values that do not fit a narrower field would wrap around
+		if f.OverflowInt(n) {
+			return ErrArg
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		switch y := x.(type) {
+		case float64:
+			if y < 0 || y != float64(uint64(y)) {
+				return ErrArg
+			}
+			n = uint64(y)
+		case string:
+			var err os.Error
+			if n, err = strconv.Atoui64(y); err != nil {
+				return err
+			}
+		default:
+			return ErrArg
+		}
+		if f.OverflowUint(n) {
+			return ErrArg
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch y := x.(type) {
+		case float64:
+			f.SetFloat(y)
+		case string:
+			g, err := strconv.Atof64(y)
+			if err != nil {
+				return err
+			}
+			f.SetFloat(g)
+		default:
+			return ErrArg
+		}
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// parseBool accepts the "0" and "1" of QueryBool, as well as true and false.
+func parseBool(s string) (bool, os.Error) {
+	switch s {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return strconv.Atob(s)
+}
+
+// Encode stores the exported fields of the struct v, or of the struct v
+// points to, in r.Value. Each field is stored under its name, or under the
+// name given by a tag of the form `rpc:"value=name"`. Fields tagged
+// `rpc:"-"` are skipped.
+func (r *Ret) Encode(v interface{}) os.Error {
+	sv := reflect.ValueOf(v)
+	if sv.Kind() == reflect.Ptr {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		return os.NewError("rpc: Encode needs a struct")
+	}
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := f.Name
+		switch tag := f.Tag.Get("rpc"); {
+		case tag == "-":
+			continue
+		case strings.HasPrefix(tag, "value="):
+			key = tag[len("value="):]
+		}
+		r.SetInterface(key, sv.Field(i).Interface())
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+type searchArgs struct {
+	Limit   int      `rpc:"query=limit"`
+	Tags    []string `rpc:"query=tag,omitempty"`
+	Exact   bool     `rpc:"query=exact,omitempty"`
+	Name    string   `rpc:"body=user.name"`
+	Score   float64  `rpc:"body=user.score,omitempty"`
+	IDs     []uint   `rpc:"body=ids,omitempty"`
+	Session string   `rpc:"cookie=sid"`
+	Ignored string
+}
+
+func newDecodeArgs() *Args {
+	return &Args{
+		Query: map[string][]string{
+			"limit": {"10"},
+			"tag":   {"a", "b"},
+			"exact": {"1"},
+		},
+		Body: map[string]interface{}{
+			"user": map[string]interface{}{"name": "ann", "score": 2.5},
+			"ids":  []interface{}{float64(3), float64(5)},
+		},
+		Cookies: []*http.Cookie{&http.Cookie{Name: "sid", Value: "xyz"}},
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var v searchArgs
+	if err := newDecodeArgs().Decode(&v); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	want := searchArgs{
+		Limit:   10,
+		Tags:    []string{"a", "b"},
+		Exact:   true,
+		Name:    "ann",
+		Score:   2.5,
+		IDs:     []uint{3, 5},
+		Session: "xyz",
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+}
+
+func TestDecodeMissing(t *testing.T) {
+	var v searchArgs
+	err := (&Args{}).Decode(&v)
+	merr, ok := err.(*MissingArgsError)
+	if !ok {
+		t.Fatalf("got %v, want a *MissingArgsError", err)
+	}
+	want := []string{"query=limit", "body=user.name", "cookie=sid"}
+	if !reflect.DeepEqual(merr.Keys, want) {
+		t.Errorf("missing keys: got %q, want %q", merr.Keys, want)
+	}
+}
+
+func TestDecodeBadValue(t *testing.T) {
+	a := newDecodeArgs()
+	a.Query["limit"] = []string{"ten"}
+	var v searchArgs
+	if err := a.Decode(&v); err == nil {
+		t.Errorf("expected an error for a non-numeric limit")
+	}
+}
+
+func TestDecodeEmptyArray(t *testing.T) {
+	a := newDecodeArgs()
+	a.Body["user"].(map[string]interface{})["name"] = []interface{}{}
+	var v searchArgs
+	if err := a.Decode(&v); err == nil {
+		t.Errorf("expected an error for an empty array in a string field")
+	}
+
+	// Slices take empty arrays
+	a = newDecodeArgs()
+	a.Body["ids"] = []interface{}{}
+	v = searchArgs{}
+	if err := a.Decode(&v); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if v.IDs == nil || len(v.IDs) != 0 {
+		t.Errorf("got IDs %#v, want an empty slice", v.IDs)
+	}
+}
+
+func TestDecodeOverflow(t *testing.T) {
+	type narrow struct {
+		Small int8   `rpc:"query=small,omitempty"`
+		Port  uint16 `rpc:"body=port,omitempty"`
+	}
+	tests := []struct {
+		query string
+		body  interface{}
+		ok    bool
+	}{
+		{"-128", float64(65535), true},
+		{"300", nil, false},
+		{"-129", nil, false},
+		{"", float64(65536), false},
+		{"", "70000", false},
+		{"", float64(300), true},
+	}
+	for i, tt := range tests {
+		a := &Args{Query: map[string][]string{}, Body: map[string]interface{}{}}
+		if tt.query != "" {
+			a.Query["small"] = []string{tt.query}
+		}
+		if tt.body != nil {
+			a.Body["port"] = tt.body
+		}
+		var v narrow
+		err := a.Decode(&v)
+		if tt.ok && err != nil {
+			t.Errorf("#%d: Decode: %s", i, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.String(), ErrArg.String())) {
+			t.Errorf("#%d: got %v (%+v), want ErrArg", i, err, v)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	v := struct {
+		Count  int
+		Name   string `rpc:"value=name"`
+		Secret string `rpc:"-"`
+	}{3, "ann", "s3cret"}
+	ret := &Ret{}
+	if err := ret.Encode(&v); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	want := map[string]interface{}{"Count": 3, "name": "ann"}
+	if !reflect.DeepEqual(ret.Value, want) {
+		t.Errorf("got %v, want %v", ret.Value, want)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	a := newDecodeArgs()
+	for i := 0; i < b.N; i++ {
+		var v searchArgs
+		if err := a.Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManualAccessors(b *testing.B) {
+	a := newDecodeArgs()
+	for i := 0; i < b.N; i++ {
+		var v searchArgs
+		s, _ := a.QueryString("limit")
+		v.Limit, _ = strconv.Atoi(s)
+		v.Tags = a.Query["tag"]
+		v.Exact, _ = a.QueryBool("exact")
+		user, _ := a.Body["user"].(map[string]interface{})
+		v.Name, _ = user["name"].(string)
+		v.Score, _ = user["score"].(float64)
+		v.Session, _ = a.CookieValue("sid")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"github.com/petar/GoHTTP/http"
+)
+
+// An Encoder renders the Value of a Ret as a response body.
+type Encoder func(value map[string]interface{}) ([]byte, os.Error)
+
+var (
+	encLock  sync.Mutex
+	encoders = map[string]Encoder{
+		"application/json": encodeJSON,
+		"application/xml":  encodeXML,
+		"text/xml":         encodeXML,
+	}
+)
+
+// RegisterEncoder makes enc available for responses to requests whose
+// Accept header asks for mediaType, such as "application/x-yaml".
+// Registering a media type again replaces its encoder.
+func RegisterEncoder(mediaType string, enc Encoder) {
+	encLock.Lock()
+	defer encLock.Unlock()
+	encoders[strings.ToLower(mediaType)] = enc
+}
+
+// negotiate returns the media type and encoder for a response to a request
+// with the given Accept header, as chosen by http.NegotiateContentType among
+// the media types with a registered encoder. Among the types matched by the
+// same wildcard range, such as */*, JSON comes first. Without any acceptable
+// media type, JSON is used.
+func negotiate(accept string) (string, Encoder) {
+	encLock.Lock()
+	defer encLock.Unlock()
+	offered := make([]string, 0, len(encoders))
+	for mediaType := range encoders {
+		if mediaType != "application/json" {
+			offered = append(offered, mediaType)
+		}
+	}
+	sort.Strings(offered)
+	offered = append([]string{"application/json"}, offered...)
+	best := http.NegotiateContentType(http.Header{"Accept": {accept}}, offered, "application/json")
+	return best, encoders[best]
+}
+
+func encodeJSON(value map[string]interface{}) ([]byte, os.Error) {
+	return json.Marshal(value)
+}
+
+var xmlEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\"", "&quot;",
+	"'", "&apos;",
+)
+
+// encodeXML renders value as a <response> element holding one element per
+// key, in sorted order. Maps nest, and the elements of slices are rendered
+// as <item> elements. Keys that are not valid XML names are rendered as
+// <entry name="key"> elements.
+func encodeXML(value map[string]interface{}) ([]byte, os.Error) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	writeXMLElement(&buf, "response", value)
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, v interface{}) {
+	if xmlName(name) {
+		buf.WriteString("<" + name + ">")
+	} else {
+		buf.WriteString(`<entry name="` + xmlEscaper.Replace(name) + `">`)
+		name = "entry"
+	}
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeXMLElement(buf, k, v[k])
+		}
+	case []interface{}:
+		for _, e := range v {
+			writeXMLElement(buf, "item", e)
+		}
+	case string:
+		buf.WriteString(xmlEscaper.Replace(v))
+	default:
+		buf.WriteString(xmlEscaper.Replace(fmt.Sprint(v)))
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+// xmlName reports whether s can be used as an XML element name.
+// It is restrictive, admitting only ASCII names without colons.
+func xmlName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case i > 0 && ('0' <= c && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"os"
+	"time"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+var (
+	// ErrBodyTooLarge is the error of the server package, so that the limit
+	// of its Config.MaxBodyBytes and that of SetMaxBodyBytes read alike.
+	ErrBodyTooLarge = server.ErrBodyTooLarge
+	ErrBodyTimeout  = os.NewError("RPC request body not received in time")
+)
+
+// DefaultMaxBodyBytes is the default limit on the size of RPC request bodies.
+const DefaultMaxBodyBytes = 10 << 20
+
+// DefaultMaxMemoryBytes is the default number of bytes of uploaded files
+// that are held in memory. Files beyond it are spooled to temporary files.
+const DefaultMaxMemoryBytes = 1 << 20
+
+// UploadedFile describes a file part of a multipart/form-data request body.
+type UploadedFile struct {
+	Name        string // Form field name
+	Filename    string
+	ContentType string
+	Size        int64
+
+	content []byte // Contents of the file, when held in memory
+	tmpfile string // Name of the temporary file holding the contents, otherwise
+}
+
+// Open returns a reader for the contents of the file.
+func (f *UploadedFile) Open() (io.ReadCloser, os.Error) {
+	if f.tmpfile != "" {
+		return os.Open(f.tmpfile)
+	}
+	return ioutil.NopCloser(bytes.NewBuffer(f.content)), nil
+}
+
+// timedReader reads from r, but returns ErrBodyTimeout once its deadline,
+// in nanoseconds since the epoch, has passed, or when a read from r times out.
+// It bounds the time taken by a client that trickles in a body, each read of
+// which would otherwise beat the connection's read timeout.
+type timedReader struct {
+	r        io.Reader
+	deadline int64 // Zero for none
+}
+
+// newTimedReader returns a timedReader with a deadline timeout nanoseconds
+// from now, or with none if timeout is not positive.
+func newTimedReader(r io.Reader, timeout int64) *timedReader {
+	t := &timedReader{r: r}
+	if timeout > 0 {
+		t.deadline = time.Nanoseconds() + timeout
+	}
+	return t
+}
+
+func (t *timedReader) Read(p []byte) (n int, err os.Error) {
+	if t.deadline > 0 && time.Nanoseconds() > t.deadline {
+		return 0, ErrBodyTimeout
+	}
+	n, err = t.r.Read(p)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		err = ErrBodyTimeout
+	}
+	return n, err
+}
+
+// mediaType returns the media type and parameters of the request's
+// Content-Type header, or an empty media type if it is missing or malformed.
+func mediaType(req *http.Request) (string, map[string]string) {
+	d, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil
+	}
+	return d, params
+}
+
+// readURLEncoded appends the fields of an application/x-www-form-urlencoded
+// body to a.Query, after any values that came from the request's URL.
+// Malformed fields are skipped, as in the URL's query.
+func readURLEncoded(body io.Reader, a *Args) os.Error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	form, _ := http.ParseQuery(string(b))
+	for k, vs := range form {
+		a.Query[k] = append(a.Query[k], vs...)
+	}
+	return nil
+}
+
+// readErrRecorder remembers the first error, other than os.EOF, returned
+// by reads from r. It tells failures of the body apart from malformed content.
+type readErrRecorder struct {
+	r   io.Reader
+	err os.Error
+}
+
+func (r *readErrRecorder) Read(p []byte) (n int, err os.Error) {
+	n, err = r.r.Read(p)
+	if err != nil && err != os.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// argError returns the error with which reading the body failed, such as
+// ErrBodyTooLarge or ErrBodyTimeout, or ErrArg if the body was read fine,
+// in which case it was malformed.
+func (r *readErrRecorder) argError() os.Error {
+	if r.err != nil {
+		return r.err
+	}
+	return ErrArg
+}
+
+// readMultipart places the text fields of a multipart/form-data body into
+// a.Query, after any values that came from the request's URL, and its file
+// parts into a.Files. Up to maxMemory bytes of file contents are kept in
+// memory, the rest is spooled to temporary files. A malformed body yields
+// ErrArg, while errors reading it are returned unchanged.
+func readMultipart(body io.Reader, params map[string]string, a *Args, maxMemory int64) os.Error {
+	boundary, ok := params["boundary"]
+	if !ok || boundary == "" {
+		return ErrArg
+	}
+	rec := &readErrRecorder{r: body}
+	mr := multipart.NewReader(rec, boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == os.EOF {
+			return nil
+		}
+		if err != nil {
+			return rec.argError()
+		}
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+		var b bytes.Buffer
+		if p.FileName() == "" {
+			if _, err = io.Copy(&b, p); err != nil {
+				return rec.argError()
+			}
+			a.Query[name] = append(a.Query[name], b.String())
+			continue
+		}
+		f := UploadedFile{
+			Name:        name,
+			Filename:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+		}
+		n, err := io.CopyN(&b, p, maxMemory+1)
+		if err != nil && err != os.EOF {
+			return rec.argError()
+		}
+		if n > maxMemory {
+			tf, err := ioutil.TempFile("", "rpc-upload-")
+			if err != nil {
+				return err
+			}
+			n, err = io.Copy(tf, io.MultiReader(&b, p))
+			tf.Close()
+			if err != nil {
+				os.Remove(tf.Name())
+				return rec.argError()
+			}
+			f.tmpfile = tf.Name()
+		} else {
+			maxMemory -= n
+			f.content = b.Bytes()
+		}
+		f.Size = n
+		a.Files = append(a.Files, f)
+	}
+	panic("unreach")
+}
+
+// removeFiles deletes the temporary files backing a.Files.
+func (a *Args) removeFiles() {
+	for _, f := range a.Files {
+		if f.tmpfile != "" {
+			os.Remove(f.tmpfile)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"json"
+	"os"
+	"rpc"
+	"strings"
+	"sync"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+// JSON-RPC 2.0 is spoken by POSTing to the root path of the RPC sub.
+// The body holds a single call object, or an array of call objects for a
+// batch. Each call names a registered method as "Service.Method" and may
+// carry its params as a JSON object, which is presented to the method in
+// Args.Body, the raw params being in Args.RawBody. The method's Ret.Value
+// becomes the call's result. Ret.NoContent and Ret.RequireBasicAuth are
+// not meaningful here and are ignored. Results streamed with Ret.Stream
+// cannot be sent either: the stream is abandoned, and the call is answered
+// with a server error. Ret.SetCookies of all calls are sent with the HTTP
+// response, in the order of the calls in the batch. Should several calls
+// set a cookie of the same name, domain and path, the one set by the call
+// that comes last in the batch is sent. Calls without an id are
+// notifications: they are executed, but elicit no entry in the response.
+// A call with a null id is not one, and is answered with a null id. When
+// no entries remain, the response is a 204.
+
+// Error codes defined by the JSON-RPC 2.0 specification
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcServerError    = -32000
+)
+
+var (
+	errInvalidParams = os.NewError("rpc: params must be a JSON object")
+	errStreamJSONRPC = os.NewError("rpc: streamed results cannot be sent over JSON-RPC")
+)
+
+type jsonrpcRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	Version string           `json:"jsonrpc"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError    `json:"error,omitempty"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+func newJSONRPCError(id *json.RawMessage, code int, msg string) *jsonrpcResponse {
+	return &jsonrpcResponse{
+		Version: "2.0",
+		Error:   &jsonrpcError{Code: code, Message: msg},
+		Id:      id,
+	}
+}
+
+type jsonrpcCall struct {
+	req     *jsonrpcRequest
+	notify  bool           // The call has no id, as opposed to a null one
+	entry   int            // Index of the call's response in jsonrpcCodec.entries
+	cookies []*http.Cookie // Set by the call, protected by the codec's mutex
+}
+
+// jsonrpcCodec is an rpc.ServerCodec that hands the calls of a JSON-RPC 2.0
+// request to rpc.Server one after the other, and writes the HTTP response
+// once all of them have been answered.
+type jsonrpcCodec struct {
+	*server.Query
+	calls []*jsonrpcCall
+	next  int // Index of the next call to be read; accessed only by the read methods
+	batch bool
+
+	sync.Mutex // protects entries, pending and the cookies of calls
+	entries    []*jsonrpcResponse
+	pending    int
+
+	cookieDefaults *http.Cookie
+}
+
+// newJSONRPCCodec reads and parses the body of q, which must be received
+// within readTimeout, if positive. Malformed calls are answered right away,
+// in the entries of the returned codec.
+func newJSONRPCCodec(q *server.Query, maxBody, readTimeout int64) (*jsonrpcCodec, os.Error) {
+	jc := &jsonrpcCodec{Query: q}
+	if q.Req.Body == nil {
+		jc.entries = append(jc.entries, newJSONRPCError(nil, jsonrpcInvalidRequest, "missing request body"))
+		return jc, nil
+	}
+	body, err := ioutil.ReadAll(newTimedReader(server.MaxBodyReader(q.Req.Body, maxBody), readTimeout))
+	q.Req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+
+	var raws []*json.RawMessage
+	if len(body) > 0 && body[0] == '[' {
+		jc.batch = true
+		err = json.Unmarshal(body, &raws)
+	} else {
+		raw := &json.RawMessage{}
+		raws = []*json.RawMessage{raw}
+		err = json.Unmarshal(body, raw)
+	}
+	if err != nil {
+		jc.batch = false
+		jc.entries = append(jc.entries, newJSONRPCError(nil, jsonrpcParseError, "parse error"))
+		return jc, nil
+	}
+	if len(raws) == 0 {
+		jc.batch = false
+		jc.entries = append(jc.entries, newJSONRPCError(nil, jsonrpcInvalidRequest, "empty batch"))
+		return jc, nil
+	}
+
+	for _, raw := range raws {
+		req := &jsonrpcRequest{}
+		if raw == nil || json.Unmarshal(*raw, req) != nil || req.Version != "2.0" || req.Method == "" || !isValidID(req.Id) {
+			jc.entries = append(jc.entries, newJSONRPCError(nil, jsonrpcInvalidRequest, "invalid request"))
+			continue
+		}
+		// A null id unmarshals as nil, just like a missing one
+		var fields map[string]*json.RawMessage
+		json.Unmarshal(*raw, &fields)
+		_, hasId := fields["id"]
+		jc.calls = append(jc.calls, &jsonrpcCall{req: req, notify: !hasId, entry: len(jc.entries)})
+		jc.entries = append(jc.entries, nil)
+	}
+	jc.pending = len(jc.calls)
+	return jc, nil
+}
+
+// isValidID reports whether id is absent or null, or a JSON string or number.
+func isValidID(id *json.RawMessage) bool {
+	if id == nil || len(*id) == 0 || string(*id) == "null" {
+		return true
+	}
+	c := (*id)[0]
+	return c == '"' || c == '-' || ('0' <= c && c <= '9')
+}
+
+func (jc *jsonrpcCodec) ReadRequestHeader(req *rpc.Request) os.Error {
+	if jc.next >= len(jc.calls) {
+		return os.EOF
+	}
+	req.Seq = uint64(jc.next + 1)
+	req.ServiceMethod = jc.calls[jc.next].req.Method
+	jc.next++
+	return nil
+}
+
+func (jc *jsonrpcCodec) ReadRequestBody(args interface{}) (err os.Error) {
+	if args == nil {
+		return nil
+	}
+	call := jc.calls[jc.next-1]
+	a := args.(*Args)
+	a.Method = jc.Query.Req.Method
+	a.Header = jc.Query.Req.Header
+	a.Cookies = jc.Query.Req.Cookies()
+	a.Query, _ = http.ParseQuery(jc.Query.Req.URL.RawQuery)
+	a.Body = make(map[string]interface{})
+	if call.req.Params != nil {
+		a.RawBody = []byte(*call.req.Params)
+		if json.Unmarshal(a.RawBody, &a.Body) != nil {
+			return errInvalidParams
+		}
+	}
+	return nil
+}
+
+func (jc *jsonrpcCodec) WriteResponse(resp *rpc.Response, ret interface{}) os.Error {
+	call := jc.calls[resp.Seq-1]
+	msg := resp.Error
+	// The entries are sent in one body, which a stream cannot be part of
+	if r, ok := ret.(*Ret); ok && r.stream != nil {
+		r.stream.abandon()
+		if msg == "" {
+			msg = errStreamJSONRPC.String()
+		}
+	}
+	var entry *jsonrpcResponse
+	var cookies []*http.Cookie
+	switch {
+	case msg == errInvalidParams.String():
+		entry = newJSONRPCError(call.req.Id, jsonrpcInvalidParams, msg)
+	case strings.HasPrefix(msg, "rpc: can't find"),
+		strings.HasPrefix(msg, "rpc: service/method request ill-formed"):
+		entry = newJSONRPCError(call.req.Id, jsonrpcMethodNotFound, msg)
+	case msg != "":
+		entry = newJSONRPCError(call.req.Id, jsonrpcServerError, msg)
+	default:
+		r := ret.(*Ret)
+		cookies = r.SetCookies
+		result, err := json.Marshal(r.Value)
+		if err != nil {
+			entry = newJSONRPCError(call.req.Id, jsonrpcServerError, err.String())
+			break
+		}
+		raw := json.RawMessage(result)
+		entry = &jsonrpcResponse{Version: "2.0", Result: &raw, Id: call.req.Id}
+	}
+
+	jc.Lock()
+	if !call.notify {
+		jc.entries[call.entry] = entry
+	}
+	call.cookies = cookies
+	jc.pending--
+	done := jc.pending == 0
+	jc.Unlock()
+	if done {
+		return jc.flush()
+	}
+	return nil
+}
+
+// flush writes the HTTP response carrying all entries.
+func (jc *jsonrpcCodec) flush() os.Error {
+	req := jc.Query.Req
+	var entries []*jsonrpcResponse
+	for _, e := range jc.entries {
+		if e != nil {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return jc.Query.Write(jc.setCookies(http.NewResponse204(req)))
+	}
+	var body []byte
+	var err os.Error
+	if jc.batch {
+		body, err = json.Marshal(entries)
+	} else {
+		body, err = json.Marshal(entries[0])
+	}
+	if err != nil {
+		jc.Query.Write(http.NewResponse500(req))
+		return err
+	}
+	httpResp := http.NewResponse200Bytes(req, body)
+	httpResp.Header = make(http.Header)
+	httpResp.Header.Set("Content-Type", "application/json")
+	return jc.Query.Write(jc.setCookies(httpResp))
+}
+
+func (jc *jsonrpcCodec) setCookies(httpResp *http.Response) *http.Response {
+	if httpResp.Header == nil {
+		httpResp.Header = make(http.Header)
+	}
+	http.WriteSetCookies(httpResp.Header, jc.batchCookies())
+	return httpResp
+}
+
+// batchCookies merges the cookies set by the calls, in the order of the calls.
+// A cookie set again by a later call, with the same name, domain and path,
+// replaces the earlier one in place.
+func (jc *jsonrpcCodec) batchCookies() []*http.Cookie {
+	var cookies []*http.Cookie
+	index := make(map[string]int)
+	for _, call := range jc.calls {
+		for _, c := range withCookieDefaults(call.cookies, jc.cookieDefaults) {
+			key := c.Name + ";" + strings.ToLower(c.Domain) + ";" + c.Path
+			if i, ok := index[key]; ok {
+				cookies[i] = c
+				continue
+			}
+			index[key] = len(cookies)
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
+
+func (jc *jsonrpcCodec) Close() os.Error { return nil }
+
+// serveJSONRPC answers q, which holds a JSON-RPC 2.0 request.
+func (rpcsub *RPC) serveJSONRPC(q *server.Query, maxBody, readTimeout int64, cookieDefaults *http.Cookie) {
+	jc, err := newJSONRPCCodec(q, maxBody, readTimeout)
+	if err == ErrBodyTimeout {
+		q.Write(http.NewResponse408(q.Req))
+		return
+	}
+	if err != nil {
+		q.Write(http.NewResponse400String(q.Req, err.String()))
+		return
+	}
+	jc.cookieDefaults = cookieDefaults
+	if jc.pending == 0 {
+		jc.flush()
+		return
+	}
+	rpcsub.rpcs.ServeCodec(jc)
+}
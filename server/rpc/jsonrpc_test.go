@@ -0,0 +1,322 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+type Arith struct{}
+
+func (a *Arith) Add(args *Args, ret *Ret) os.Error {
+	x, _ := args.Body["x"].(float64)
+	y, _ := args.Body["y"].(float64)
+	ret.SetInterface("sum", x+y)
+	return nil
+}
+
+func (a *Arith) Fail(args *Args, ret *Ret) os.Error {
+	return os.NewError("failed")
+}
+
+func (a *Arith) Count(args *Args, ret *Ret) os.Error {
+	return ret.Stream().Encode(1)
+}
+
+type Jar struct{}
+
+func (j *Jar) Set(args *Args, ret *Ret) os.Error {
+	name, _ := args.Body["name"].(string)
+	value, _ := args.Body["value"].(string)
+	ret.AddSetCookie(&http.Cookie{Name: name, Value: value})
+	return nil
+}
+
+func startJSONRPCServer(t *testing.T) (*server.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 20)
+	rpcs := NewRPC()
+	if err = rpcs.RegisterName("arith", &Arith{}); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	if err = rpcs.RegisterName("jar", &Jar{}); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	srv.AddSub("/rpc/", rpcs)
+	srv.Launch(1)
+	return srv, "http://" + l.Addr().String() + "/rpc/"
+}
+
+func postJSONRPC(t *testing.T, url, body string, v interface{}) int {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func errorCode(entry map[string]interface{}) float64 {
+	e, _ := entry["error"].(map[string]interface{})
+	code, _ := e["code"].(float64)
+	return code
+}
+
+func TestJSONRPC(t *testing.T) {
+	srv, url := startJSONRPCServer(t)
+	defer srv.Shutdown()
+
+	var single map[string]interface{}
+	code := postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"arith.Add","params":{"x":1,"y":2},"id":7}`, &single)
+	if code != 200 {
+		t.Fatalf("single: status %d", code)
+	}
+	result, _ := single["result"].(map[string]interface{})
+	if single["jsonrpc"] != "2.0" || single["id"] != float64(7) || result["sum"] != float64(3) {
+		t.Errorf("single: got %v", single)
+	}
+
+	var parse map[string]interface{}
+	postJSONRPC(t, url, `{"jsonrpc":`, &parse)
+	if errorCode(parse) != -32700 || parse["id"] != nil {
+		t.Errorf("parse error: got %v", parse)
+	}
+
+	var empty map[string]interface{}
+	postJSONRPC(t, url, `[]`, &empty)
+	if errorCode(empty) != -32600 {
+		t.Errorf("empty batch: got %v", empty)
+	}
+
+	if code = postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"arith.Add"}`, nil); code != 204 {
+		t.Errorf("notification: got status %d, want 204", code)
+	}
+
+	// A null id is not a notification
+	var null map[string]interface{}
+	code = postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"arith.Add","params":{"x":1,"y":1},"id":null}`, &null)
+	id, present := null["id"]
+	result, _ = null["result"].(map[string]interface{})
+	if code != 200 || !present || id != nil || result["sum"] != float64(2) {
+		t.Errorf("null id: got status %d, %v", code, null)
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	srv, url := startJSONRPCServer(t)
+	defer srv.Shutdown()
+
+	var batch []map[string]interface{}
+	code := postJSONRPC(t, url, `[
+		{"jsonrpc":"2.0","method":"arith.Add","params":{"x":2,"y":3},"id":"a"},
+		{"jsonrpc":"2.0","method":"arith.Add","params":{"x":1,"y":1}},
+		{"jsonrpc":"2.0","method":"arith.Missing","id":2},
+		{"jsonrpc":"2.0","method":"arith.Add","params":[1,2],"id":3},
+		{"jsonrpc":"2.0","method":"arith.Fail","id":4},
+		{"foo":"bar"}
+	]`, &batch)
+	if code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if len(batch) != 5 {
+		t.Fatalf("batch: got %d entries, want 5: %v", len(batch), batch)
+	}
+	byID := make(map[interface{}]map[string]interface{})
+	for _, e := range batch {
+		byID[e["id"]] = e
+	}
+	if r, _ := byID["a"]["result"].(map[string]interface{}); r["sum"] != float64(5) {
+		t.Errorf("call a: got %v", byID["a"])
+	}
+	if c := errorCode(byID[float64(2)]); c != -32601 {
+		t.Errorf("unknown method: got code %v, want -32601", c)
+	}
+	if c := errorCode(byID[float64(3)]); c != -32602 {
+		t.Errorf("invalid params: got code %v, want -32602", c)
+	}
+	if c := errorCode(byID[float64(4)]); c != -32000 {
+		t.Errorf("failing method: got code %v, want -32000", c)
+	}
+	if c := errorCode(byID[nil]); c != -32600 {
+		t.Errorf("invalid request: got code %v, want -32600", c)
+	}
+}
+
+func TestJSONRPCStream(t *testing.T) {
+	srv, url := startJSONRPCServer(t)
+	defer srv.Shutdown()
+
+	var entry map[string]interface{}
+	code := postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"arith.Count","id":1}`, &entry)
+	if code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	e, _ := entry["error"].(map[string]interface{})
+	if errorCode(entry) != -32000 || e["message"] != errStreamJSONRPC.String() {
+		t.Errorf("streamed result: got %v", entry)
+	}
+}
+
+func TestJSONRPCBatchCookies(t *testing.T) {
+	srv, url := startJSONRPCServer(t)
+	defer srv.Shutdown()
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(`[
+		{"jsonrpc":"2.0","method":"jar.Set","params":{"name":"a","value":"1"},"id":1},
+		{"jsonrpc":"2.0","method":"jar.Set","params":{"name":"b","value":"2"}},
+		{"jsonrpc":"2.0","method":"arith.Fail","id":2},
+		{"jsonrpc":"2.0","method":"jar.Set","params":{"name":"a","value":"3"},"id":3}
+	]`))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	resp.Body.Close()
+	got := resp.Header["Set-Cookie"]
+	if len(got) != 2 || got[0] != "a=3" || got[1] != "b=2" {
+		t.Errorf("Set-Cookie: got %q, want [a=3 b=2]", got)
+	}
+}
+
+func TestRPCCORS(t *testing.T) {
+	srv, url := startJSONRPCServer(t)
+	defer srv.Shutdown()
+	srv.EnableCORS("/rpc/", server.CORSConfig{
+		AllowOrigins:     []string{"https://app.example"},
+		AllowHeaders:     []string{"Content-Type"},
+		AllowCredentials: true,
+	})
+
+	do := func(method, path, origin string) *http.Response {
+		req, _ := http.NewRequest(method, url+path, nil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	resp := do("OPTIONS", "", "https://app.example")
+	if resp.StatusCode != 204 || resp.Header.Get("Access-Control-Allow-Headers") != "Content-Type" {
+		t.Errorf("preflight: got status %d, header %v", resp.StatusCode, resp.Header)
+	}
+	resp = do("GET", "arith/Add", "https://app.example")
+	if resp.StatusCode != 200 || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example" ||
+		resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("allowed origin: got status %d, header %v", resp.StatusCode, resp.Header)
+	}
+	resp = do("GET", "arith/Add", "https://evil.example")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin: got header %v", resp.Header)
+	}
+}
+
+func TestBodyTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9, ReadTimeout: 3e8}, 20)
+	defer srv.Shutdown()
+	rpcs := NewRPC()
+	rpcs.RegisterName("arith", &Arith{})
+	srv.AddSub("/rpc/", rpcs)
+	srv.Launch(1)
+
+	for _, path := range []string{"/rpc/arith.Add", "/rpc/"} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		fmt.Fprintf(c, "POST %s HTTP/1.1\r\nHost: example.com\r\n"+
+			"Content-Type: application/json\r\nContent-Length: 100\r\n\r\n", path)
+		// Each byte beats the read timeout, the whole body does not
+		go func() {
+			for i := 0; i < 100; i++ {
+				if _, err := c.Write([]byte(" ")); err != nil {
+					return
+				}
+				time.Sleep(5e7)
+			}
+		}()
+		req, _ := http.NewRequest("POST", "http://example.com"+path, nil)
+		resp, err := http.ReadResponse(bufio.NewReader(c), req)
+		if err != nil {
+			t.Fatalf("%s: read response: %s", path, err)
+		}
+		if resp.StatusCode != 408 {
+			t.Errorf("%s: status %d, want 408", path, resp.StatusCode)
+		}
+		c.Close()
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 20)
+	defer srv.Shutdown()
+	rpcs := NewRPC()
+	rpcs.RegisterName("arith", &Arith{})
+	rpcs.AllowMethods("arith.Add", "post", "GET")
+	srv.AddSub("/rpc/", rpcs)
+	srv.Launch(1)
+	url := "http://" + l.Addr().String() + "/rpc/arith.Add"
+
+	for _, method := range []string{"GET", "HEAD", "POST"} {
+		req, _ := http.NewRequest(method, url, strings.NewReader(`{"x":1}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: status %d, want 200", method, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest("PUT", url, strings.NewReader(`{"x":1}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 405 || resp.Header.Get("Allow") != "GET, HEAD, POST" {
+		t.Errorf("PUT: status %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	rpcs.AllowMethods("arith.Add")
+	req, _ = http.NewRequest("PUT", url, strings.NewReader(`{"x":1}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT after lifting: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("PUT after lifting: status %d, want 200", resp.StatusCode)
+	}
+}
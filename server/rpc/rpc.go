@@ -7,7 +7,10 @@ package rpc
 import (
 	"os"
 	"rpc"
+	"sort"
+	"strings"
 	"sync"
+	"github.com/petar/GoHTTP/http"
 	"github.com/petar/GoHTTP/server"
 )
 
@@ -16,20 +19,124 @@ import (
 // parameters in the URL, just like the ones produced by jQuery's
 // AJAX calls. Responses are returned in the form of HTTP responses
 // with return values in the form of a JSON object in the response
-// body.
+// body, or an XML document or another registered encoding if the
+// request's Accept header asks for one. POST requests to the root path of the sub are treated as
+// JSON-RPC 2.0 calls instead. To serve browsers on other origins,
+// enable CORS for the URL of the sub with Server.EnableCORS, which
+// answers preflights and annotates the RPC responses.
 type RPC struct {
-	rpcs       *rpc.Server // does not need locking, since re-entrant
-	sync.Mutex             // protects auto
-	auto       uint64
+	rpcs           *rpc.Server // does not need locking, since re-entrant
+	sync.Mutex                 // protects all fields below
+	auto           uint64
+	maxBody        int64
+	maxMemory      int64
+	streams        map[string]StreamFunc
+	streamBodies   map[string]bool
+	methods        map[string][]string // Allowed HTTP methods, by service method name
+	cookieDefaults *http.Cookie
 }
 
 func NewRPC() *RPC {
 	return &RPC{
 		rpcs: rpc.NewServer(),
 		auto: 1, // Start seq numbers from 1, so that 0 is always an invalid seq number
+		maxBody: DefaultMaxBodyBytes,
+		maxMemory: DefaultMaxMemoryBytes,
 	}
 }
 
+// SetMaxBodyBytes sets the maximum size of request bodies that will be
+// decoded. Requests with larger bodies are answered with an error.
+func (rpcsub *RPC) SetMaxBodyBytes(n int64) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.maxBody = n
+}
+
+// SetMaxMemoryBytes sets the number of bytes of files uploaded in a
+// multipart/form-data body that are held in memory. The contents of
+// files beyond the limit are spooled to temporary files.
+func (rpcsub *RPC) SetMaxMemoryBytes(n int64) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.maxMemory = n
+}
+
+// StreamRequestBody makes the service method name, given in the form
+// "Service.Method", receive its request body through Args.BodyReader
+// instead of having it decoded into Args.Body, unless the method asks for
+// the decoding with Args.ReadBody.
+func (rpcsub *RPC) StreamRequestBody(name string) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	if rpcsub.streamBodies == nil {
+		rpcsub.streamBodies = make(map[string]bool)
+	}
+	rpcsub.streamBodies[name] = true
+}
+
+// AllowMethods restricts the service method name, given in the form
+// "Service.Method", to queries with one of the given HTTP methods, such as
+// "GET" or "POST". HEAD is allowed along with GET. Queries with any other
+// method are answered with a 405 and an Allow header, without reaching the
+// service method. Calling AllowMethods without any methods lifts the
+// restriction. JSON-RPC calls to the root path are not affected.
+func (rpcsub *RPC) AllowMethods(name string, methods ...string) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	if len(methods) == 0 {
+		rpcsub.methods[name] = nil, false
+		return
+	}
+	if rpcsub.methods == nil {
+		rpcsub.methods = make(map[string][]string)
+	}
+	var allow []string
+	for _, m := range methods {
+		if m = strings.ToUpper(m); !allowed(allow, m) {
+			allow = append(allow, m)
+		}
+	}
+	if allowed(allow, "GET") && !allowed(allow, "HEAD") {
+		allow = append(allow, "HEAD")
+	}
+	sort.Strings(allow)
+	rpcsub.methods[name] = allow
+}
+
+func allowed(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// newResponse405 returns a 405 response to req whose Allow header lists methods.
+func newResponse405(req *http.Request, methods []string) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(http.StatusMethodNotAllowed),
+		StatusCode: http.StatusMethodNotAllowed,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     http.Header{"Allow": {strings.Join(methods, ", ")}},
+	}
+}
+
+// SetCookieDefaults sets attributes that are applied to every cookie in
+// Ret.SetCookies that does not specify them itself. Path, Domain and SameSite
+// are taken from defaults when empty. Secure and HttpOnly, whose false values
+// cannot be told apart from unset ones, can only be turned on. The other
+// fields of defaults are ignored, and a nil defaults removes them.
+func (rpcsub *RPC) SetCookieDefaults(defaults *http.Cookie) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.cookieDefaults = defaults.Clone()
+}
+
 func (rpcsub *RPC) Register(rcvr interface{}) os.Error {
 	return rpcsub.rpcs.Register(rcvr)
 }
@@ -38,12 +145,34 @@ func (rpcsub *RPC) RegisterName(name string, rcvr interface{}) os.Error {
 	return rpcsub.rpcs.RegisterName(name, rcvr)
 }
 
+func isRootPath(p string) bool { return p == "" || p == "/" }
+
 func (rpcsub *RPC) Serve(q *server.Query) {
 	qx := &queryCodec{Query: q}
 	rpcsub.Lock()
 	qx.seq = rpcsub.auto
 	rpcsub.auto++
+	qx.maxBody = rpcsub.maxBody
+	qx.maxMemory = rpcsub.maxMemory
+	qx.readTimeout = q.ReadTimeout()
+	name := pathToServiceMethod(q.Req.URL.Path)
+	fn := rpcsub.streams[name]
+	qx.streamBody = rpcsub.streamBodies[name]
+	qx.cookieDefaults = rpcsub.cookieDefaults
+	methods := rpcsub.methods[name]
 	rpcsub.Unlock()
+	if methods != nil && !isRootPath(q.Req.URL.Path) && !allowed(methods, q.Req.Method) {
+		q.ContinueAndWrite(newResponse405(q.Req, methods))
+		return
+	}
 	q.Continue()
+	if fn != nil {
+		qx.serveStream(fn)
+		return
+	}
+	if q.Req.Method == "POST" && isRootPath(q.Req.URL.Path) {
+		rpcsub.serveJSONRPC(q, qx.maxBody, qx.readTimeout, qx.cookieDefaults)
+		return
+	}
 	rpcsub.rpcs.ServeCodec(qx)
 }
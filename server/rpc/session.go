@@ -0,0 +1,176 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionStore keeps server-side session state, indexed by opaque
+// session ids that are handed to clients in a cookie. Each cookie value
+// carries an HMAC of the session id keyed by a server secret, so that
+// clients cannot forge sessions.
+type SessionStore struct {
+	name   string // Name of the session cookie
+	secret []byte
+	maxAge int // Session lifetime in seconds
+
+	sync.Mutex // protects sessions and nload
+	sessions   map[string]*sessionEntry
+	nload      int
+}
+
+type sessionEntry struct {
+	values  map[string]interface{}
+	expires int64 // Expiry time in nanoseconds
+}
+
+// sweepPeriod is the number of Load calls between sweeps of expired sessions.
+const sweepPeriod = 128
+
+// NewSessionStore creates a SessionStore that uses a cookie named cookieName,
+// signs session ids with secret and expires sessions that have not been saved
+// for maxAge seconds.
+func NewSessionStore(cookieName string, secret []byte, maxAge int) *SessionStore {
+	if maxAge <= 0 {
+		panic("session max age must be positive")
+	}
+	return &SessionStore{
+		name:     cookieName,
+		secret:   secret,
+		maxAge:   maxAge,
+		sessions: make(map[string]*sessionEntry),
+	}
+}
+
+// Session is the state associated with a single client.
+type Session struct {
+	store  *SessionStore
+	id     string
+	values map[string]interface{}
+}
+
+// Load returns the session identified by the session cookie in args.
+// If the cookie is missing, has a bad signature or refers to an expired
+// session, a new empty session is returned.
+func (ss *SessionStore) Load(args *Args) *Session {
+	ss.Lock()
+	defer ss.Unlock()
+	ss.nload++
+	if ss.nload%sweepPeriod == 0 {
+		ss.sweep()
+	}
+	for _, c := range args.Cookies {
+		if c.Name != ss.name {
+			continue
+		}
+		id, ok := ss.verify(c.Value)
+		if !ok {
+			continue
+		}
+		e, ok := ss.sessions[id]
+		if !ok {
+			continue
+		}
+		if e.expires < time.Nanoseconds() {
+			ss.sessions[id] = nil, false
+			continue
+		}
+		return &Session{store: ss, id: id, values: copyValues(e.values)}
+	}
+	return &Session{store: ss, id: newSessionID(), values: make(map[string]interface{})}
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
+
+// sweep removes expired sessions. The caller must hold ss.
+func (ss *SessionStore) sweep() {
+	now := time.Nanoseconds()
+	for id, e := range ss.sessions {
+		if e.expires < now {
+			ss.sessions[id] = nil, false
+		}
+	}
+}
+
+func (ss *SessionStore) sign(id string) string {
+	h := hmac.New(sha1.New, ss.secret)
+	h.Write([]byte(id))
+	return id + "." + hex.EncodeToString(h.Sum())
+}
+
+// verify checks the signature on a cookie value and returns the session id it carries.
+func (ss *SessionStore) verify(value string) (id string, ok bool) {
+	i := strings.Index(value, ".")
+	if i < 0 {
+		return "", false
+	}
+	id = value[:i]
+	if subtle.ConstantTimeCompare([]byte(ss.sign(id)), []byte(value)) != 1 {
+		return "", false
+	}
+	return id, true
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("session id: " + err.String())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Get returns the value associated with key, or nil if there is none.
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+// Set associates value with key. Changes are not visible to other
+// requests until Save is called.
+func (s *Session) Set(key string, value interface{}) {
+	s.values[key] = value
+}
+
+// Delete removes the value associated with key.
+func (s *Session) Delete(key string) {
+	s.values[key] = nil, false
+}
+
+// Save stores a copy of the session, extends its expiry by the store's max
+// age, and sends the signed session cookie with ret. Later changes to s are
+// not stored until Save is called again.
+func (s *Session) Save(ret *Ret) {
+	ss := s.store
+	ss.Lock()
+	ss.sessions[s.id] = &sessionEntry{
+		values:  copyValues(s.values),
+		expires: time.Nanoseconds() + int64(ss.maxAge)*1e9,
+	}
+	ss.Unlock()
+	ret.SetSessionCookie(ss.name, ss.sign(s.id), ss.maxAge, false, true)
+}
+
+// Destroy removes the session from the store and instructs the client
+// to delete the session cookie.
+func (s *Session) Destroy(ret *Ret) {
+	ss := s.store
+	ss.Lock()
+	ss.sessions[s.id] = nil, false
+	ss.Unlock()
+	ret.DeleteCookie(ss.name)
+}
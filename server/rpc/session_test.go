@@ -0,0 +1,105 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+// sessionArgs returns Args carrying the session cookie set in ret.
+func sessionArgs(t *testing.T, ret *Ret) *Args {
+	if len(ret.SetCookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(ret.SetCookies))
+	}
+	c := ret.SetCookies[0]
+	return &Args{Cookies: []*http.Cookie{&http.Cookie{Name: c.Name, Value: c.Value}}}
+}
+
+func TestSessionSaveLoad(t *testing.T) {
+	ss := NewSessionStore("sid", []byte("secret"), 60)
+	s := ss.Load(&Args{})
+	s.Set("user", "ann")
+	ret := &Ret{}
+	s.Save(ret)
+	if c := ret.SetCookies[0]; c.Name != "sid" || c.MaxAge != 60 || !c.HttpOnly {
+		t.Errorf("got session cookie %s", c)
+	}
+
+	// Changes after Save are not stored
+	s.Set("user", "bob")
+	s.Set("admin", true)
+	s2 := ss.Load(sessionArgs(t, ret))
+	if s2.id != s.id {
+		t.Fatalf("loaded session %q, want %q", s2.id, s.id)
+	}
+	if u := s2.Get("user"); u != "ann" {
+		t.Errorf("user: got %v, want %q", u, "ann")
+	}
+	if a := s2.Get("admin"); a != nil {
+		t.Errorf("admin: got %v, want nil", a)
+	}
+
+	// Nor are changes to a loaded session
+	s2.Delete("user")
+	if u := ss.Load(sessionArgs(t, ret)).Get("user"); u != "ann" {
+		t.Errorf("after Delete without Save: got %v, want %q", u, "ann")
+	}
+
+	s2.Destroy(&Ret{})
+	if s3 := ss.Load(sessionArgs(t, ret)); s3.id == s.id || s3.Get("user") != nil {
+		t.Errorf("destroyed session was loaded again")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ss := NewSessionStore("sid", []byte("secret"), 60)
+	s := ss.Load(&Args{})
+	s.Set("n", 1)
+	ret := &Ret{}
+	s.Save(ret)
+
+	ss.Lock()
+	ss.sessions[s.id].expires = 0
+	ss.Unlock()
+	if s2 := ss.Load(sessionArgs(t, ret)); s2.id == s.id || s2.Get("n") != nil {
+		t.Errorf("expired session was loaded")
+	}
+	ss.Lock()
+	n := len(ss.sessions)
+	ss.Unlock()
+	if n != 0 {
+		t.Errorf("store holds %d sessions, want 0", n)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	ss := NewSessionStore("sid", []byte("secret"), 60)
+	s := ss.Load(&Args{})
+	s.Set("user", "ann")
+	ret := &Ret{}
+	s.Save(ret)
+	value := ret.SetCookies[0].Value
+
+	flip := func(c byte) string {
+		if c == '0' {
+			return "1"
+		}
+		return "0"
+	}
+	other := NewSessionStore("sid", []byte("other secret"), 60)
+	for i, v := range []string{
+		value[:len(value)-1] + flip(value[len(value)-1]),
+		flip(value[0]) + value[1:],
+		s.id,
+		s.id + ".",
+		other.sign(s.id),
+	} {
+		args := &Args{Cookies: []*http.Cookie{&http.Cookie{Name: "sid", Value: v}}}
+		if s2 := ss.Load(args); s2.id == s.id {
+			t.Errorf("#%d: loaded session from tampered value %q", i, v)
+		}
+	}
+}
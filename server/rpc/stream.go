@@ -0,0 +1,209 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"json"
+	"os"
+	"sync"
+	"github.com/petar/GoHTTP/http"
+)
+
+// StreamFunc is the signature of streaming RPC handlers. Instead of filling
+// in a Ret, a streaming handler writes the response body incrementally to w.
+// If the handler returns an error before writing anything, the client
+// receives a 400 response carrying the error, just like regular RPC methods.
+// An error returned after writing has begun aborts the connection.
+type StreamFunc func(args *Args, w *StreamWriter) os.Error
+
+// StreamWriter is the response writer handed to streaming RPC handlers.
+// The response header and content length must be set before the first Write.
+type StreamWriter struct {
+	header  http.Header
+	length  int64
+	started bool
+	ready   chan os.Error // Signals that the response can be written
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+}
+
+func newStreamWriter() *StreamWriter {
+	pr, pw := io.Pipe()
+	return &StreamWriter{
+		header: make(http.Header),
+		length: -1,
+		ready:  make(chan os.Error, 1),
+		pr:     pr,
+		pw:     pw,
+	}
+}
+
+// Header returns the header of the response.
+func (sw *StreamWriter) Header() http.Header { return sw.header }
+
+// SetContentLength declares the length of the response body in bytes.
+// If it is not called, the response is sent with chunked transfer encoding.
+func (sw *StreamWriter) SetContentLength(n int64) { sw.length = n }
+
+// Write sends p to the client as part of the response body.
+func (sw *StreamWriter) Write(p []byte) (n int, err os.Error) {
+	if !sw.started {
+		sw.started = true
+		sw.ready <- nil
+	}
+	return sw.pw.Write(p)
+}
+
+func (sw *StreamWriter) finish(err os.Error) {
+	if !sw.started {
+		sw.started = true
+		sw.ready <- err
+		if err != nil {
+			return
+		}
+	}
+	if err != nil {
+		sw.pw.CloseWithError(err)
+	} else {
+		sw.pw.Close()
+	}
+}
+
+// RegisterStream registers fn as the handler for the service method name,
+// given in the form "Service.Method". Streaming handlers take precedence over
+// methods registered with Register and RegisterName.
+func (rpcsub *RPC) RegisterStream(name string, fn StreamFunc) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	if rpcsub.streams == nil {
+		rpcsub.streams = make(map[string]StreamFunc)
+	}
+	rpcsub.streams[name] = fn
+}
+
+func (qx *queryCodec) serveStream(fn StreamFunc) {
+	req := qx.Query.Req
+	args := &Args{}
+	if err := qx.ReadRequestBody(args); err != nil {
+		qx.Query.Write(http.NewResponse400String(req, err.String()))
+		return
+	}
+	sw := newStreamWriter()
+	go func() {
+		sw.finish(fn(args, sw))
+		args.removeFiles()
+	}()
+	if err := <-sw.ready; err != nil {
+		qx.Query.Write(http.NewResponse400String(req, err.String()))
+		return
+	}
+	resp := http.NewResponse200(req)
+	resp.Header = sw.header
+	resp.Body = sw.pr
+	resp.ContentLength = sw.length
+	if sw.length < 0 {
+		resp.TransferEncoding = []string{"chunked"}
+	}
+	// Write closes resp.Body, which unblocks the handler if the client went away
+	qx.Query.Write(resp)
+}
+
+// StreamEncoder writes the result of an RPC method as a JSON array, one
+// element at a time, and is obtained from Ret.Stream. The response, sent with
+// chunked transfer encoding, begins once the method returns. Elements encoded
+// before then are buffered, so large results should be encoded by a goroutine
+// that the method starts before returning. Close ends the array, and must be
+// called for the response to complete.
+type StreamEncoder struct {
+	lk     sync.Mutex
+	n      int            // Number of elements encoded so far
+	buf    bytes.Buffer   // Output preceding the start of the response
+	pw     *io.PipeWriter // Output after the start of the response
+	closed bool
+}
+
+// Encode appends the JSON encoding of v to the array. It blocks until
+// the client has accepted the preceding output, and fails if the response
+// has been abandoned.
+func (se *StreamEncoder) Encode(v interface{}) os.Error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	se.lk.Lock()
+	defer se.lk.Unlock()
+	if se.closed {
+		return os.NewError("rpc: Encode on closed StreamEncoder")
+	}
+	sep := ","
+	if se.n == 0 {
+		sep = "["
+	}
+	se.n++
+	return se.write(append([]byte(sep), b...))
+}
+
+// Close ends the array.
+func (se *StreamEncoder) Close() os.Error {
+	se.lk.Lock()
+	defer se.lk.Unlock()
+	if se.closed {
+		return nil
+	}
+	se.closed = true
+	end := "]"
+	if se.n == 0 {
+		end = "[]"
+	}
+	err := se.write([]byte(end))
+	if se.pw != nil {
+		se.pw.Close()
+	}
+	return err
+}
+
+// write sends p to the response. The caller must hold the lock.
+func (se *StreamEncoder) write(p []byte) os.Error {
+	if se.pw == nil {
+		se.buf.Write(p)
+		return nil
+	}
+	_, err := se.pw.Write(p)
+	return err
+}
+
+// body returns the response body carrying the array.
+func (se *StreamEncoder) body() io.ReadCloser {
+	se.lk.Lock()
+	defer se.lk.Unlock()
+	pr, pw := io.Pipe()
+	se.pw = pw
+	if se.closed {
+		pw.Close()
+	}
+	return &streamBody{io.MultiReader(bytes.NewBuffer(se.buf.Bytes()), pr), pr}
+}
+
+// abandon fails further use of se, whose output will never be sent.
+func (se *StreamEncoder) abandon() {
+	se.lk.Lock()
+	defer se.lk.Unlock()
+	if se.pw == nil {
+		pr, pw := io.Pipe()
+		pr.Close()
+		se.pw = pw
+	}
+	se.buf.Reset()
+}
+
+type streamBody struct {
+	io.Reader
+	pr *io.PipeReader
+}
+
+// Close unblocks the encoder if the client goes away.
+func (sb *streamBody) Close() os.Error { return sb.pr.Close() }
@@ -0,0 +1,83 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+func startStreamServer(t *testing.T) (*server.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 20)
+	rpcs := NewRPC()
+	rpcs.RegisterStream("s.Chunked", func(args *Args, w *StreamWriter) os.Error {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 3; i++ {
+			if _, err := fmt.Fprintf(w, "part%d;", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	rpcs.RegisterStream("s.Sized", func(args *Args, w *StreamWriter) os.Error {
+		w.SetContentLength(5)
+		_, err := fmt.Fprintf(w, "sized")
+		return err
+	})
+	rpcs.RegisterStream("s.Fail", func(args *Args, w *StreamWriter) os.Error {
+		return os.NewError("refused")
+	})
+	srv.AddSub("/rpc/", rpcs)
+	srv.Launch(1)
+	return srv, "http://" + l.Addr().String() + "/rpc/"
+}
+
+var streamTests = []struct {
+	method  string
+	code    int
+	body    string
+	chunked bool
+	length  int64 // Content-Length, when not chunked
+}{
+	{"s/Chunked", 200, "part0;part1;part2;", true, -1},
+	{"s/Sized", 200, "sized", false, 5},
+	{"s/Fail", 400, "refused", false, 7},
+}
+
+func TestStreamWriter(t *testing.T) {
+	srv, url := startStreamServer(t)
+	defer srv.Shutdown()
+
+	for _, tt := range streamTests {
+		resp, err := http.Get(url + tt.method)
+		if err != nil {
+			t.Fatalf("%s: get: %s", tt.method, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s: read body: %s", tt.method, err)
+		}
+		if resp.StatusCode != tt.code || string(body) != tt.body {
+			t.Errorf("%s: got %d %q, want %d %q", tt.method, resp.StatusCode, body, tt.code, tt.body)
+		}
+		chunked := len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked"
+		if chunked != tt.chunked {
+			t.Errorf("%s: got Transfer-Encoding %q, want chunked %v", tt.method, resp.TransferEncoding, tt.chunked)
+		}
+		if !tt.chunked && resp.ContentLength != tt.length {
+			t.Errorf("%s: got Content-Length %d, want %d", tt.method, resp.ContentLength, tt.length)
+		}
+	}
+}
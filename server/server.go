@@ -6,7 +6,9 @@ package server
 
 import (
 	//"fmt"
-	"container/list"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -14,6 +16,7 @@ import (
 	"sync"
 	"time"
 	"net/http"
+	"net/http/httputil"
 	"github.com/petar/GoHTTP/util"
 )
 
@@ -23,15 +26,28 @@ import (
 // makes sure that a pre-specified limit of active connections (i.e.
 // file descriptors) is not exceeded.
 type Server struct {
-	sync.Mutex // protects listen and conns
+	sync.Mutex // protects listen, conns, perIP, subs, exts, mws, rl, rrl, health, cache, trusted, draining and config.MaxHeaderBytes
 
 	// Real-time state
 	listen net.Listener
 	conns  map[*StampedServerConn]int
+	perIP  map[string]int // Open connections per remote IP, if Config.MaxConnsPerIP is set
 	qch    chan *Query
+	stop   chan bool // Closed by Shutdown to release expireLoop, Read and senders on qch
+	exited chan bool // Closed once expireLoop has returned
 	fdl    util.FDLimiter
 	subs   []*subcfg
 	exts   []*extcfg
+	mws    []Middleware
+	rl     *rateLimiter
+	rrl    *requestLimiter
+	health *healthcfg
+	cache  *ResponseCache
+
+	trusted []net.IPNet // Proxies whose forwarding headers are believed
+
+	draining bool           // Set once Shutdown has begun
+	workers  sync.WaitGroup // Counts the queries being handled by ServeWorkers
 
 	config Config // Server configuration
 	stats  Stats  // Real-time statistics
@@ -39,24 +55,48 @@ type Server struct {
 
 // NewServer creates a new Server which listens for connections on l.
 // New connections are automatically managed by ServerConn objects with
-// timout set to tmo nanoseconds. The Server object ensures that at no
-// time more than fdlim file descriptors are allocated to incoming connections.
+// timeouts given by config. Timeouts that are left zero in config default
+// to config.Timeout. The Server object ensures that at no time more than
+// fdlim file descriptors are allocated to incoming connections.
+// NewServer panics if the configuration is invalid.
 func NewServer(l net.Listener, config Config, fdlim int) *Server {
-	if config.Timeout < 2 {
-		panic("timeout too small")
+	config.FDLimit = fdlim
+	srv, err := NewServerChecked(l, config)
+	if err != nil {
+		panic(err.Error())
+	}
+	return srv
+}
+
+// NewServerChecked is like NewServer, except that the file descriptor limit
+// is taken from config.FDLimit, and an invalid configuration is reported
+// as an error rather than a panic.
+func NewServerChecked(l net.Listener, config Config) (*Server, error) {
+	if l == nil {
+		return nil, errors.New("server: nil listener")
+	}
+	config.fill()
+	if err := config.check(); err != nil {
+		return nil, err
 	}
 	// TODO(petar): Perhaps a better design passes the FDLimiter as a parameter
 	srv := &Server{
 		config: config,
 		listen: l,
 		conns:  make(map[*StampedServerConn]int),
+		perIP:  make(map[string]int),
 		qch:    make(chan *Query),
+		stop:   make(chan bool),
+		exited: make(chan bool),
 	}
-	srv.fdl.Init(fdlim)
+	srv.fdl.Init(config.FDLimit)
 	srv.stats.Init()
+	if config.CORS != nil {
+		srv.EnableCORS("/", *config.CORS)
+	}
 	go srv.acceptLoop()
 	go srv.expireLoop()
-	return srv
+	return srv, nil
 }
 
 func NewServerEasy(addr string) (*Server, error) {
@@ -64,12 +104,58 @@ func NewServerEasy(addr string) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewServer(l, Config{5e9}, 200), nil
+	return NewServer(l, Config{Timeout: 5e9}, DefaultFDLimit), nil
 }
 
 func (srv *Server) GetFDLimiter() *util.FDLimiter { return &srv.fdl }
 
+// SetMaxHeaderBytes changes the limit on the size of request headers, set
+// initially by Config.MaxHeaderBytes, for connections accepted from now on.
+// Requests with larger headers are answered with a 431 status, and their
+// connection is closed. A non-positive n restores http.DefaultMaxHeaderBytes.
+func (srv *Server) SetMaxHeaderBytes(n int) {
+	if n <= 0 {
+		n = http.DefaultMaxHeaderBytes
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	srv.config.MaxHeaderBytes = n
+}
+
+func (srv *Server) getMaxHeaderBytes() int {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.config.MaxHeaderBytes
+}
+
+// Stats returns a snapshot of the server's real-time statistics.
+func (srv *Server) Stats() StatsSnapshot {
+	s := srv.stats.Copy()
+	s.FDInUse, s.FDLimit = srv.fdl.Count()
+	return s
+}
+
+// ConnCount returns the number of connections currently managed by the server.
+func (srv *Server) ConnCount() int {
+	srv.Lock()
+	defer srv.Unlock()
+	return len(srv.conns)
+}
+
+// Addr returns the address the server listens on, such as the port picked
+// for a listener on port 0, or nil once the server has been shut down.
+func (srv *Server) Addr() net.Addr {
+	srv.Lock()
+	defer srv.Unlock()
+	if srv.listen == nil {
+		return nil
+	}
+	return srv.listen.Addr()
+}
+
 func (srv *Server) expireLoop() {
+	defer close(srv.exited)
+	var kills []*StampedServerConn
 	for i := 0; ; i++ {
 		srv.Lock()
 		if srv.listen == nil {
@@ -77,30 +163,58 @@ func (srv *Server) expireLoop() {
 			return
 		}
 		now := time.Now().UnixNano()
-		kills := list.New()
 		for ssc, _ := range srv.conns {
-			if now-ssc.GetStamp() >= srv.config.Timeout {
-				kills.PushBack(ssc)
+			// Connections serving a request are not idle; the deadline of
+			// their response is enforced by Query.Write
+			if ssc.isBusy() {
+				continue
+			}
+			if now-ssc.GetStamp() >= srv.config.IdleTimeout {
+				kills = append(kills, ssc)
 				srv.stats.IncExpireConn()
 			}
 		}
 		srv.Unlock()
-		elm := kills.Front()
-		for elm != nil {
-			ssc := elm.Value.(*StampedServerConn)
+		for j, ssc := range kills {
 			srv.bury(ssc)
-			elm = elm.Next()
+			kills[j] = nil
+		}
+		kills = kills[:0]
+		if rl := srv.getRateLimiter(); rl != nil {
+			rl.sweep()
+		}
+		if rrl := srv.getRequestLimiter(); rrl != nil {
+			rrl.sweep()
+		}
+		// Wait for the next pass, without leaving the timer behind on shutdown
+		t := time.NewTimer(time.Duration(srv.config.IdleTimeout))
+		select {
+		case <-srv.stop:
+			t.Stop()
+			return
+		case <-t.C:
 		}
-		kills.Init()
-		kills = nil
-		time.Sleep(time.Duration(srv.config.Timeout))
 		if i%4 == 0 {
 			log.Println(srv.stats.SummaryLine())
 		}
 	}
 }
 
+const response503 = "HTTP/1.1 503 Service Unavailable\r\n" +
+	"Content-Length: 0\r\n" +
+	"Connection: close\r\n\r\n"
+
+// shed answers c, which was accepted without an fd allocated to it,
+// with a 503 response and closes it.
+func (srv *Server) shed(c net.Conn) {
+	srv.stats.IncDroppedOverload()
+	c.SetWriteTimeout(1e9)
+	io.WriteString(c, response503)
+	c.Close()
+}
+
 func (srv *Server) acceptLoop() {
+	shedding := false
 	for {
 		srv.Lock()
 		l := srv.listen
@@ -108,36 +222,75 @@ func (srv *Server) acceptLoop() {
 		if l == nil {
 			return
 		}
-		srv.fdl.Lock()
+		// Once a wait for an fd has timed out, load is shed without
+		// waiting again, until an fd is released
+		locked := srv.fdl.TryLock()
+		if !locked && !shedding {
+			log.Printf("Accept stalled on fd limit: %d in use, %d waiting\n",
+				srv.fdl.InUse(), srv.fdl.Waiting())
+			if srv.config.FDWaitTimeout == 0 {
+				srv.fdl.Lock()
+				locked = true
+			} else {
+				locked = srv.fdl.LockTimeout(srv.config.FDWaitTimeout)
+			}
+		}
+		shedding = !locked
 		c, err := l.Accept()
 		if err != nil {
 			if c != nil {
 				c.Close()
 			}
-			srv.fdl.Unlock()
-			srv.qch <- newQueryErr(err)
+			if locked {
+				srv.fdl.Unlock()
+			}
+			srv.send(newQueryErr(err))
 			return
 		}
 		srv.stats.IncAcceptConn()
-		c.(*net.TCPConn).SetKeepAlive(true)
-		err = c.SetReadTimeout(srv.config.Timeout)
+		if !locked {
+			srv.shed(c)
+			continue
+		}
+		if srv.rateLimited(c) {
+			c.Close()
+			srv.fdl.Unlock()
+			continue
+		}
+		if tc, ok := c.(*net.TCPConn); ok {
+			tc.SetKeepAlive(true)
+		}
+		tlsConn, _ := c.(*tls.Conn)
+		err = c.SetReadTimeout(srv.config.IdleTimeout)
 		if err != nil {
 			log.Printf("Set read timeout: %s\n", err)
 			c.Close()
 			srv.fdl.Unlock()
-			srv.qch <- newQueryErr(err)
+			srv.send(newQueryErr(err))
 			return
 		}
-		err = c.SetWriteTimeout(srv.config.Timeout)
+		err = c.SetWriteTimeout(srv.config.WriteTimeout)
 		if err != nil {
 			log.Printf("Set write timeout: %s\n", err)
 			c.Close()
 			srv.fdl.Unlock()
-			srv.qch <- newQueryErr(err)
+			srv.send(newQueryErr(err))
 			return
 		}
-		c = util.NewRunOnCloseConn(c, func() { srv.fdl.Unlock() })
+		host := remoteHost(c)
+		if !srv.admitConn(host, c) {
+			c.Close()
+			srv.fdl.Unlock()
+			continue
+		}
+		c = util.NewRunOnCloseConn(c, func() {
+			srv.releaseConn(host)
+			srv.fdl.Unlock()
+		})
 		ssc := NewStampedServerConn(c, nil)
+		ssc.tlsConn = tlsConn
+		ssc.SetTimeouts(srv.config.IdleTimeout, srv.config.ReadTimeout, srv.config.WriteTimeout)
+		ssc.SetMaxHeaderBytes(srv.getMaxHeaderBytes())
 		srv.register(ssc)
 		go srv.read(ssc)
 	}
@@ -152,16 +305,18 @@ func (srv *Server) Read() (query *Query, err error) {
 	// TODO: This loop processes requests in sequence. And does not process a new one
 	// until the old one has processed in process(). Need to parallelize this.
 	for {
-		q, ok := <-srv.qch
-		srv.Lock()
-		if !ok {
-			srv.Unlock()
+		var q *Query
+		select {
+		case q = <-srv.qch:
+		case <-srv.stop:
 			return nil, os.EBADF
 		}
-		srv.Unlock()
 		if err = q.getError(); err != nil {
 			return nil, err
 		}
+		if !srv.cleanQueryPath(q) || srv.serveHealth(q) || srv.limitQuery(q) || srv.serveCached(q) {
+			continue
+		}
 		q = srv.process(q)
 		if q != nil {
 			return q, nil
@@ -188,6 +343,72 @@ func (srv *Server) Launch(parallel int) {
 	}
 }
 
+// Serve reads queries until the Server is shut down, invoking handler
+// for each one in its own goroutine. A handler that panics is logged, and
+// its query, unless already answered or hijacked, receives a 500 response.
+func (srv *Server) Serve(handler func(*Query)) {
+	for {
+		q, err := srv.Read()
+		if err != nil {
+			return
+		}
+		go serveQuery(q, handler)
+	}
+}
+
+// ServeWorkers is like Serve, but handles queries in a pool of n goroutines,
+// so that at most n queries are handled at once. Further requests wait on
+// their connections for a worker to free up. Shutdown waits, up to
+// Config.WriteTimeout, for the queries the pool is handling to be answered
+// before closing their connections. ServeWorkers returns once all of its
+// workers have finished.
+func (srv *Server) ServeWorkers(n int, handler func(*Query)) {
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for k := 0; k < n; k++ {
+		go func() {
+			defer wg.Done()
+			for {
+				q, err := srv.Read()
+				if err != nil {
+					return
+				}
+				// Queries read once the listener is closed are not waited for
+				srv.Lock()
+				counted := srv.listen != nil
+				if counted {
+					srv.workers.Add(1)
+				}
+				srv.Unlock()
+				serveQuery(q, handler)
+				if counted {
+					srv.workers.Done()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func serveQuery(q *Query, handler func(*Query)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Handler panic serving %s: %v\n", q.origPath, r)
+			if q.Req == nil || q.hijacked {
+				return
+			}
+			if !q.fwd {
+				q.Continue()
+			}
+			q.Write(http.NewResponse500(q.Req))
+		}
+	}()
+	handler(q)
+}
+
 func (srv *Server) AddSub(url string, sub Sub) {
 	srv.Lock()
 	defer srv.Unlock()
@@ -238,11 +459,17 @@ func (srv *Server) process(q *Query) *Query {
 	for _, ec := range exts {
 		if strings.HasPrefix(p, ec.SubURL) {
 			if err := ec.Ext.ReadRequest(q.Req, q.Ext); err != nil {
+				q.dropFill()
 				return nil
 			}
 		}
 	}
 
+	// Apply middleware
+	if q = srv.applyMiddleware(q); q == nil {
+		return nil
+	}
+
 	// Serve using a sub?
 	p = q.Req.URL.Path
 	subs := srv.copySub()
@@ -266,6 +493,19 @@ func (srv *Server) read(ssc *StampedServerConn) {
 			srv.bury(ssc)
 			return
 		}
+		if err == io.EOF || err == httputil.ErrPersistEOF {
+			// The client sends no further requests, perhaps having half-closed
+			// the connection. Outstanding requests, including one that came with
+			// the EOF, are answered before the connection is closed.
+			ssc.closeRead()
+			if req == nil {
+				if ssc.drained() {
+					srv.retire(ssc)
+				}
+				return
+			}
+			err = nil
+		}
 		if err != nil {
 			// TODO(petar): Technically, a read side error should not terminate
 			// the ServerConn if there are outstanding requests to be answered,
@@ -276,21 +516,71 @@ func (srv *Server) read(ssc *StampedServerConn) {
 			// NOTE(petar): 'tcp read ... resource temporarily unavailable' errors 
 			// received here, I think, correspond to when the remote side has closed
 			// the connection. This is OK.
+			if hook := srv.config.OnReadError; hook != nil && !isIdleTimeout(err) {
+				hook(ssc.conn.RemoteAddr().String(), err)
+			}
 			srv.bury(ssc)
 			return
 		}
-		srv.qch <- &Query{
-			Req:      req,
-			srv:      srv,
-			ssc:      ssc,
-			origPath: req.URL.Path,
-			t0:       time.Nanoseconds(),
+		q := &Query{
+			Req:        req,
+			srv:        srv,
+			ssc:        ssc,
+			origPath:   req.URL.Path,
+			t0:         time.Nanoseconds(),
+			remoteAddr: ssc.conn.RemoteAddr(),
+			clientAddr: ssc.conn.RemoteAddr().String(),
+			localAddr:  ssc.conn.LocalAddr().String(),
+		}
+		q.clientIP, q.forwarded = srv.clientIP(q.remoteAddr, req)
+		q.viaTrusted = srv.trusts(srv.getTrustedProxies(), peerIP(q.remoteAddr))
+		if len(q.forwarded) > 0 {
+			q.clientAddr = q.clientIP
+		}
+		if ssc.tlsConn != nil {
+			st := ssc.tlsConn.ConnectionState()
+			req.TLS = &st
+		}
+		if req.ProtoAtLeast(1, 1) && req.Body != nil &&
+			strings.ToLower(req.Header.Get("Expect")) == "100-continue" {
+			q.ecr = &expectContinueReader{ReadCloser: req.Body, ssc: ssc}
+			req.Body = q.ecr
+		}
+		if srv.config.MaxBodyBytes > 0 && req.Body != nil {
+			q.body = newMaxBodyReader(req.Body, srv.config.MaxBodyBytes)
+			req.Body = q.body
+		}
+		ssc.begin()
+		if !srv.send(q) {
+			srv.bury(ssc)
+			return
 		}
 		srv.stats.IncRequest()
 		return
 	}
 }
 
+// isIdleTimeout reports whether err is the timeout of a connection that
+// sat idle waiting for its next request. Clients that close their connection
+// between requests are not seen here, since that is reported as ErrPersistEOF
+// and handled as a half-close. Other errors, including io.ErrUnexpectedEOF,
+// mean that a request was cut short or malformed.
+func isIdleTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+// send hands q to Read. It returns false, dropping q, if the server
+// has been shut down.
+func (srv *Server) send(q *Query) bool {
+	select {
+	case srv.qch <- q:
+		return true
+	case <-srv.stop:
+	}
+	return false
+}
+
 func (srv *Server) register(ssc *StampedServerConn) {
 	srv.Lock()
 	defer srv.Unlock()
@@ -311,20 +601,50 @@ func (srv *Server) bury(ssc *StampedServerConn) {
 	ssc.Close()
 }
 
+// retire is like bury, for connections whose last response has been written.
+// They are closed gracefully, so that the client can read the response.
+func (srv *Server) retire(ssc *StampedServerConn) {
+	srv.unregister(ssc)
+	ssc.closeGracefully(srv.config.Linger)
+}
+
 // Shutdown closes the Server by closing the underlying
-// net.Listener object. The user should not use any Server
-// or Query methods after a call to Shutdown.
+// net.Listener object, after the grace period of Config.DrainGrace.
+// The user should not use any Server or Query methods after Shutdown
+// has returned, except to answer queries handled by ServeWorkers,
+// which Shutdown waits for.
 func (srv *Server) Shutdown() (err error) {
-	// First, close the listener
+	// First, let readiness checks fail while requests are still served
+	srv.Lock()
+	already := srv.draining
+	srv.draining = true
+	srv.Unlock()
+	if grace := srv.config.DrainGrace; grace > 0 && !already {
+		time.Sleep(time.Duration(grace))
+	}
+
+	// Then, close the listener
 	srv.Lock()
 	var l net.Listener
 	l, srv.listen = srv.listen, nil
-	close(srv.qch)
+	if l != nil {
+		close(srv.stop)
+	}
 	srv.Unlock()
 	if l != nil {
 		err = l.Close()
 	}
-	// Then, force-close all open connections
+	// Then, give queries handled by ServeWorkers a chance to be answered
+	done := make(chan bool)
+	go func() {
+		srv.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Duration(srv.config.WriteTimeout)):
+	}
+	// Finally, force-close all open connections
 	srv.Lock()
 	for ssc, _ := range srv.conns {
 		ssc.Close()
@@ -0,0 +1,335 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"net/http"
+)
+
+func TestMaxHeaderBytes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9, MaxHeaderBytes: 1 << 16}, 20)
+	defer srv.Shutdown()
+	srv.SetMaxHeaderBytes(1024)
+	go func() {
+		for {
+			q, err := srv.Read()
+			if err != nil {
+				return
+			}
+			q.ContinueAndWrite(http.NewResponse200Bytes(q.Req, []byte("ok")))
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	go func() {
+		// The first request is answered before the oversized one is refused
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(c, "X-Filler-%d: %s\r\n", i, strings.Repeat("x", 100))
+		}
+		fmt.Fprintf(c, "\r\n")
+	}()
+	resp, _ := ioutil.ReadAll(c)
+	parts := strings.SplitAfter(string(resp), "\r\n\r\n")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "HTTP/1.1 200 OK\r\n") ||
+		!strings.HasPrefix(parts[1], "okHTTP/1.1 431 Request Header Fields Too Large\r\n") || parts[2] != "" {
+		t.Errorf("expected a 200 and a 431 response, got:\n%s", resp)
+	}
+}
+
+func TestShutdownWithPendingQuery(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9}, 20)
+
+	// Nobody calls Read, so the query stays pending in the connection's reader
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	for srv.ConnCount() == 0 {
+		time.Sleep(1e6)
+	}
+	time.Sleep(10e6)
+
+	srv.Shutdown()
+	srv.Shutdown()
+	if _, err = srv.Read(); err == nil {
+		t.Errorf("Read after Shutdown: expected an error")
+	}
+}
+
+func TestNewServerChecked(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	srv, err := NewServerChecked(l, Config{Timeout: 1})
+	if err == nil || srv != nil {
+		t.Errorf("timeout 1: got (%v, %v), want an error", srv, err)
+	}
+	if _, err = NewServerChecked(nil, Config{Timeout: 5e9}); err == nil {
+		t.Errorf("nil listener: expected an error")
+	}
+	srv, err = NewServerChecked(l, Config{Timeout: 5e9})
+	if err != nil {
+		t.Fatalf("valid config: %s", err)
+	}
+	if n := srv.GetFDLimiter().Limit(); n != DefaultFDLimit {
+		t.Errorf("fd limit: got %d, want %d", n, DefaultFDLimit)
+	}
+	srv.Shutdown()
+}
+
+func TestHalfClose(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		q.Continue()
+		go func() {
+			// Answer after the client has half-closed
+			time.Sleep(1e8)
+			q.Write(http.NewResponse200Bytes(q.Req, []byte("still here")))
+		}()
+	})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if err = c.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("close write: %s", err)
+	}
+	c.SetReadTimeout(2e9)
+	wire, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if !strings.HasPrefix(string(wire), "HTTP/1.1 200") || !strings.HasSuffix(string(wire), "still here") {
+		t.Errorf("got response:\n%s", wire)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9}, 20)
+	defer srv.Shutdown()
+	go srv.Serve(func(q *Query) {
+		if q.Req.URL.Path == "/panic" {
+			panic("handler failure")
+		}
+		okHandler(q)
+	})
+
+	base := "http://" + l.Addr().String()
+	for _, tt := range []struct {
+		path string
+		code int
+	}{{"/", 200}, {"/panic", 500}, {"/again", 200}} {
+		resp, err := http.Get(base + tt.path)
+		if err != nil {
+			t.Fatalf("get %s: %s", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.path, resp.StatusCode, tt.code)
+		}
+	}
+}
+
+func TestFDWaitTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9, FDWaitTimeout: 1e8}, 1)
+	defer srv.Shutdown()
+	go srv.Serve(okHandler)
+
+	// The first connection holds the only fd while it is kept alive
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c1.Close()
+	fmt.Fprintf(c1, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	c1.SetReadTimeout(2e9)
+	if _, err = c1.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("first connection: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("#%d: dial: %s", i, err)
+		}
+		c.SetReadTimeout(2e9)
+		wire, _ := ioutil.ReadAll(c)
+		c.Close()
+		if !strings.HasPrefix(string(wire), "HTTP/1.1 503") {
+			t.Errorf("#%d: got response:\n%s", i, wire)
+		}
+	}
+	stats := srv.Stats()
+	if stats.DroppedOverload != 2 {
+		t.Errorf("shed %d connections, want 2", stats.DroppedOverload)
+	}
+	if stats.FDInUse != 1 || stats.FDLimit != 1 {
+		t.Errorf("got %d of %d fds in use, want 1 of 1", stats.FDInUse, stats.FDLimit)
+	}
+}
+
+func TestOnReadError(t *testing.T) {
+	errs := make(chan error, 10)
+	config := Config{Timeout: 5e9, OnReadError: func(addr string, err error) { errs <- err }}
+	srv, addr := startTestServer(t, config, okHandler)
+	defer srv.Shutdown()
+
+	for _, wire := range []string{
+		"",                                  // Closed without a request
+		"GET / HTTP/1.1\r\nHost: a\r\n\r\n", // Closed after a complete request
+		"GET / HTTP/1.1\r\nHost:",           // Closed part way through a request
+		"HELLO\r\n\r\n",                     // Malformed request
+	} {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		fmt.Fprint(c, wire)
+		c.(*net.TCPConn).CloseWrite()
+		c.SetReadTimeout(2e9)
+		ioutil.ReadAll(c)
+		c.Close()
+	}
+	time.Sleep(1e8)
+	if n := len(errs); n != 2 {
+		t.Errorf("reported %d read errors, want 2", n)
+	}
+}
+
+func TestShutdownStopsExpireLoop(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 60e9}, 20)
+	srv.Shutdown()
+	select {
+	case <-srv.exited:
+	case <-time.After(1e9):
+		t.Errorf("expire loop still running after Shutdown")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 60e9}, 20)
+	if a := srv.Addr(); a == nil || a.String() != l.Addr().String() {
+		t.Errorf("got address %v, want %s", a, l.Addr())
+	}
+	srv.Shutdown()
+	if a := srv.Addr(); a != nil {
+		t.Errorf("got address %v after Shutdown, want nil", a)
+	}
+}
+
+func TestServeWorkers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, Config{Timeout: 5e9}, 20)
+	var mu sync.Mutex
+	active, peak := 0, 0
+	returned := make(chan bool)
+	go func() {
+		srv.ServeWorkers(2, func(q *Query) {
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+			time.Sleep(1e8)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			okHandler(q)
+		})
+		close(returned)
+	}()
+
+	base := "http://" + l.Addr().String()
+	codes := make(chan int)
+	for i := 0; i < 5; i++ {
+		go func() {
+			resp, err := http.Get(base + "/")
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if code := <-codes; code != 200 {
+			t.Errorf("#%d: got status %d, want 200", i, code)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("got %d queries handled at once, want 2", peak)
+	}
+
+	// Shutdown waits for the query being handled to be answered
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	time.Sleep(5e7)
+	srv.Shutdown()
+	if code := <-codes; code != 200 {
+		t.Errorf("query in flight during Shutdown: got status %d, want 200", code)
+	}
+	select {
+	case <-returned:
+	case <-time.After(1e9):
+		t.Errorf("ServeWorkers still running after Shutdown")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package servertest provides utilities for testing handlers built on
+// the server package, without opening a network connection.
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"net/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+// NewTestQuery returns a Query for req, as a Server with the default
+// configuration would hand it to a handler, and a ResponseRecorder that
+// captures the response written to the query. The Query carries a copy of
+// req, read back from its serialization, so its Body may be read as usual;
+// the Body of req itself is consumed.
+// A req without a host is sent to example.com, from 192.0.2.1:1234.
+//
+// The query is answered as on a real connection, so the handler must call
+// Continue or Hijack, and Write. NewTestQuery panics if req cannot be
+// serialized or the server does not accept it.
+func NewTestQuery(req *http.Request) (*server.Query, *ResponseRecorder) {
+	r := *req
+	if r.Host == "" && r.URL.Host == "" {
+		r.Host = "example.com"
+	}
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		panic("servertest: cannot write request: " + err.Error())
+	}
+	c := &conn{in: &buf}
+	l := &listener{ch: make(chan net.Conn, 1)}
+	l.ch <- c
+	srv := server.NewServer(l, server.Config{Timeout: 60e9}, 2)
+	c.onClose = func() { go srv.Shutdown() }
+	q, err := srv.Read()
+	if err != nil {
+		panic("servertest: cannot read request: " + err.Error())
+	}
+	return q, &ResponseRecorder{req: &r, c: c}
+}
+
+// A ResponseRecorder captures the response written to a test query.
+// Its methods reflect what has been written so far, which is all of it
+// once Query.Write has returned, or the body writer of a streamed
+// response has been closed.
+type ResponseRecorder struct {
+	req *http.Request
+	c   *conn
+}
+
+// Response parses the response recorded so far. Interim 100 (Continue)
+// responses are skipped, and the body is read into memory.
+func (rec *ResponseRecorder) Response() (*http.Response, error) {
+	br := bufio.NewReader(bytes.NewBuffer(rec.c.written()))
+	for {
+		resp, err := http.ReadResponse(br, rec.req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusContinue {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body = http.NewBodyBytes(body)
+		return resp, err
+	}
+	panic("unreach")
+}
+
+// Code returns the status code of the response, or 0 if none was written.
+func (rec *ResponseRecorder) Code() int {
+	resp, _ := rec.Response()
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// Header returns the header of the response, or nil if none was written.
+func (rec *ResponseRecorder) Header() http.Header {
+	resp, _ := rec.Response()
+	if resp == nil {
+		return nil
+	}
+	return resp.Header
+}
+
+// Body returns the body of the response received so far, decoded from
+// the chunked transfer coding if necessary.
+func (rec *ResponseRecorder) Body() []byte {
+	resp, _ := rec.Response()
+	if resp == nil {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return body
+}
+
+var errClosed = errors.New("servertest: connection closed")
+
+// conn is a net.Conn that reads a request from memory and records
+// whatever is written to it.
+type conn struct {
+	in      io.Reader
+	onClose func()
+
+	lk     sync.Mutex // protects the following fields
+	out    bytes.Buffer
+	closed bool
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.lk.Lock()
+	closed := c.closed
+	c.lk.Unlock()
+	if closed {
+		return 0, errClosed
+	}
+	return c.in.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.closed {
+		return 0, errClosed
+	}
+	return c.out.Write(p)
+}
+
+func (c *conn) written() []byte {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return append([]byte(nil), c.out.Bytes()...)
+}
+
+func (c *conn) Close() error {
+	c.lk.Lock()
+	closed := c.closed
+	c.closed = true
+	c.lk.Unlock()
+	if !closed && c.onClose != nil {
+		c.onClose()
+	}
+	return nil
+}
+
+var (
+	localAddr  = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	remoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}
+)
+
+func (c *conn) LocalAddr() net.Addr              { return localAddr }
+func (c *conn) RemoteAddr() net.Addr             { return remoteAddr }
+func (c *conn) SetTimeout(nsec int64) error      { return nil }
+func (c *conn) SetReadTimeout(nsec int64) error  { return nil }
+func (c *conn) SetWriteTimeout(nsec int64) error { return nil }
+
+// listener is a net.Listener that accepts the connections sent on ch.
+type listener struct {
+	ch   chan net.Conn
+	once sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, ok := <-l.ch
+	if !ok {
+		return nil, errClosed
+	}
+	return c, nil
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.ch) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return localAddr }
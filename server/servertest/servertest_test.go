@@ -0,0 +1,70 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package servertest
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"net/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+func echoHandler(q *server.Query) {
+	body, err := ioutil.ReadAll(q.Req.Body)
+	if err != nil {
+		q.ContinueAndWrite(http.NewResponse500(q.Req))
+		return
+	}
+	resp := http.NewResponse200Bytes(q.Req, body)
+	resp.Header = http.Header{"X-Remote": {q.RemoteAddr().String()}}
+	q.ContinueAndWrite(resp)
+}
+
+func TestNewTestQuery(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/echo", strings.NewReader("hello"))
+	q, rec := NewTestQuery(req)
+	if q.Req.URL.Path != "/echo" || q.Req.Method != "POST" {
+		t.Fatalf("got query for %s %s, want POST /echo", q.Req.Method, q.Req.URL.Path)
+	}
+	if rec.Code() != 0 {
+		t.Errorf("got code %d before the response was written, want 0", rec.Code())
+	}
+	echoHandler(q)
+	if rec.Code() != 200 {
+		t.Errorf("got code %d, want 200", rec.Code())
+	}
+	if g, e := rec.Header().Get("X-Remote"), "192.0.2.1:1234"; g != e {
+		t.Errorf("got X-Remote %q, want %q", g, e)
+	}
+	if g := string(rec.Body()); g != "hello" {
+		t.Errorf("got body %q, want %q", g, "hello")
+	}
+}
+
+func TestNewTestQueryStream(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.org/stream", nil)
+	q, rec := NewTestQuery(req)
+	q.Continue()
+	w, err := q.StreamResponse(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rec.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("got TransferEncoding %v, want chunked", resp.TransferEncoding)
+	}
+	if g := string(rec.Body()); g != "ab" {
+		t.Errorf("got body %q, want %q", g, "ab")
+	}
+}
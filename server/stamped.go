@@ -6,6 +6,9 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
 	"net"
 	"sync"
 	"time"
@@ -17,17 +20,42 @@ import (
 // keeps track of the last time the connection performed I/O.
 type StampedServerConn struct {
 	*httputil.ServerConn
+	conn    net.Conn
+	tlsConn *tls.Conn // Underlying TLS connection, or nil
 	stamp int64
 	lk    sync.Mutex
+
+	idleTimeout  int64 // Read timeout while waiting for a request
+	readTimeout  int64 // Read timeout while the handler reads the request body
+	writeTimeout int64 // Write timeout for responses
+
+	readClosed bool // Set once the client has sent its last request
+	closing    bool // Set once closeGracefully has been called
+	busy       int  // Number of requests handed to the user and not yet answered
 }
 
 func NewStampedServerConn(c net.Conn, r *bufio.Reader) *StampedServerConn {
 	return &StampedServerConn{
 		ServerConn: http.NewServerConn(c, r),
+		conn:       c,
 		stamp:      time.Nanoseconds(),
 	}
 }
 
+// SetTimeouts sets the timeouts, in nanoseconds, that Read and Write apply
+// to the underlying connection. A zero timeout leaves the connection unchanged.
+func (ssc *StampedServerConn) SetTimeouts(idle, read, write int64) {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	ssc.idleTimeout, ssc.readTimeout, ssc.writeTimeout = idle, read, write
+}
+
+func (ssc *StampedServerConn) getTimeouts() (idle, read, write int64) {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	return ssc.idleTimeout, ssc.readTimeout, ssc.writeTimeout
+}
+
 func (ssc *StampedServerConn) touch() {
 	ssc.lk.Lock()
 	defer ssc.lk.Unlock()
@@ -40,15 +68,119 @@ func (ssc *StampedServerConn) GetStamp() int64 {
 	return ssc.stamp
 }
 
+// begin marks the connection busy with a request that is being served.
+func (ssc *StampedServerConn) begin() {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	ssc.busy++
+}
+
+// end marks the response to a request passed to begin as written.
+func (ssc *StampedServerConn) end() {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	ssc.busy--
+	ssc.stamp = time.Nanoseconds()
+}
+
+// isBusy reports whether a request received on the connection is still
+// being served. Busy connections are not idle, however old their stamp.
+func (ssc *StampedServerConn) isBusy() bool {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	return ssc.busy > 0
+}
+
+// closeRead records that the client will send no further requests,
+// typically because it has half-closed the connection.
+func (ssc *StampedServerConn) closeRead() {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	ssc.readClosed = true
+}
+
+// drained reports whether the client has sent its last request, and
+// all requests have been answered.
+func (ssc *StampedServerConn) drained() bool {
+	ssc.lk.Lock()
+	defer ssc.lk.Unlock()
+	return ssc.readClosed && ssc.Pending() == 0
+}
+
+// maxLingerBytes bounds the input discarded by closeGracefully.
+const maxLingerBytes = 256 << 10
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeGracefully closes the connection, once its last response has been
+// written. The write side is shut down first, so that the client sees the
+// end of the response, and input is discarded until the client closes its
+// side, a read takes longer than linger, or maxLingerBytes have been read.
+// Connections that cannot be half-closed, such as TLS connections, are closed
+// at once, as are all connections if linger is not positive. Only the first
+// call has an effect.
+func (ssc *StampedServerConn) closeGracefully(linger int64) {
+	ssc.lk.Lock()
+	closing := ssc.closing
+	ssc.closing = true
+	ssc.lk.Unlock()
+	if closing {
+		return
+	}
+	c, _ := ssc.ServerConn.Hijack()
+	if c == nil {
+		return
+	}
+	cw, ok := c.(closeWriter)
+	if linger <= 0 || !ok || cw.CloseWrite() != nil {
+		c.Close()
+		return
+	}
+	go func() {
+		c.SetReadTimeout(linger)
+		io.Copy(ioutil.Discard, io.LimitReader(c, maxLingerBytes))
+		c.Close()
+	}()
+}
+
+// Read waits for the next request, applying the idle timeout until the
+// request header has been read, and the read timeout thereafter.
+// While earlier requests are being served, the connection is not idle, and
+// the wait is left for the server's expiry of idle connections to bound.
 func (ssc *StampedServerConn) Read() (req *http.Request, err error) {
 	ssc.touch()
 	defer ssc.touch()
-	return ssc.ServerConn.Read()
+	idle, read, _ := ssc.getTimeouts()
+	if ssc.isBusy() {
+		if err = ssc.conn.SetReadTimeout(0); err != nil {
+			return nil, err
+		}
+	} else if idle > 0 {
+		if err = ssc.conn.SetReadTimeout(idle); err != nil {
+			return nil, err
+		}
+	}
+	req, err = ssc.ServerConn.Read()
+	if req != nil && read > 0 {
+		if e := ssc.conn.SetReadTimeout(read); e != nil && err == nil {
+			err = e
+		}
+	}
+	return req, err
 }
 
+// Write writes resp, applying the write timeout.
 func (ssc *StampedServerConn) Write(req *http.Request, resp *http.Response) (err error) {
 	ssc.touch()
 	defer ssc.touch()
+	_, _, write := ssc.getTimeouts()
+	if write > 0 {
+		if err = ssc.conn.SetWriteTimeout(write); err != nil {
+			return err
+		}
+	}
 	return ssc.ServerConn.Write(req, resp)
 }
 
@@ -14,19 +14,41 @@ import (
 // Stats maintains server statistics and methods for
 // querying into them.
 type Stats struct {
-	TimeStarted     int64  // Time server started
-	RequestCount    uint64 // Number of request successfully received
-	ResponseCount   uint64 // Number of responses successfully received
-	ExpireConnCount uint64 // Number of connections, expired by the server
-	AcceptConnCount uint64
-	MaxReqRespTime  uint64 // Duration of longest request-response cycle
-	lk              sync.Mutex
+	StatsSnapshot
+	lk sync.Mutex
+}
+
+// StatsSnapshot holds the values of Stats at one point in time.
+// Unlike Stats, it holds no lock, and may be copied freely.
+type StatsSnapshot struct {
+	TimeStarted       int64  // Time server started
+	RequestCount      uint64 // Number of request successfully received
+	ResponseCount     uint64 // Number of responses successfully received
+	ExpireConnCount   uint64 // Number of connections, expired by the server
+	WriteTimeoutCount uint64 // Number of connections buried for missing their response write deadline
+	AcceptConnCount   uint64
+	MaxReqRespTime    uint64 // Duration of longest request-response cycle
+	CacheHitCount     uint64 // Number of queries answered from the response cache
+	CacheMissCount    uint64 // Number of cacheable queries passed on to be served
+	RateLimitedCount  uint64 // Number of queries rejected by the request rate limit
+	DroppedRateLimit  uint64 // Number of connections refused by the per-IP connection rate limit
+	DroppedPerIP      uint64 // Number of connections refused for exceeding Config.MaxConnsPerIP
+	DroppedOverload   uint64 // Number of connections answered with 503 for lack of file descriptors
+	FDInUse           int    // Number of file descriptors allocated to connections, as of Server.Stats
+	FDLimit           int    // Limit on FDInUse, as of Server.Stats
 }
 
 func (s *Stats) Init() {
 	s.TimeStarted = time.Nanoseconds()
 }
 
+// Copy returns a consistent snapshot of the statistics.
+func (s *Stats) Copy() StatsSnapshot {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.StatsSnapshot
+}
+
 func (s *Stats) AddReqRespTime(d int64) {
 	s.lk.Lock()
 	defer s.lk.Unlock()
@@ -53,18 +75,62 @@ func (s *Stats) IncExpireConn() {
 	s.ExpireConnCount++
 }
 
+func (s *Stats) IncWriteTimeout() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.WriteTimeoutCount++
+}
+
 func (s *Stats) IncAcceptConn() {
 	s.lk.Lock()
 	defer s.lk.Unlock()
 	s.AcceptConnCount++
 }
 
+func (s *Stats) IncCacheHit() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.CacheHitCount++
+}
+
+func (s *Stats) IncCacheMiss() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.CacheMissCount++
+}
+
+func (s *Stats) IncRateLimited() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.RateLimitedCount++
+}
+
+func (s *Stats) IncDroppedRateLimit() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.DroppedRateLimit++
+}
+
+func (s *Stats) IncDroppedPerIP() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.DroppedPerIP++
+}
+
+func (s *Stats) IncDroppedOverload() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.DroppedOverload++
+}
+
 func (s *Stats) SummaryLine() string {
 	s.lk.Lock()
 	defer s.lk.Unlock()
-	return fmt.Sprintf("Running %d mins, %d accept, %d expire, %d req, %d resp; MaxReqRespTime: %dms; %d goroutine",
+	return fmt.Sprintf("Running %d mins, %d accept, %d expire, %d wtmo, %d req, %d resp, %d hit, %d miss, %d limited, %d/%d/%d dropped (rate/ip/overload); MaxReqRespTime: %dms; %d goroutine",
 		(time.Nanoseconds()-s.TimeStarted)/(60*1e9),
-		s.AcceptConnCount, s.ExpireConnCount, s.RequestCount, s.ResponseCount,
+		s.AcceptConnCount, s.ExpireConnCount, s.WriteTimeoutCount, s.RequestCount, s.ResponseCount,
+		s.CacheHitCount, s.CacheMissCount, s.RateLimitedCount,
+		s.DroppedRateLimit, s.DroppedPerIP, s.DroppedOverload,
 		s.MaxReqRespTime/1e6,
 		runtime.Goroutines())
 }
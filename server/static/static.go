@@ -5,6 +5,7 @@
 package static
 
 import (
+	"fmt"
 	"path"
 	http "net/http/httputil"
 	"github.com/petar/GoHTTP/cache"
@@ -12,6 +13,9 @@ import (
 )
 
 // StaticSub is a Sub that serves static files from a given directory.
+// Responses carry an ETag derived from the size and modification time of
+// the file, and requests whose If-None-Match lists it are answered with 304.
+// HEAD requests are answered from the size of the file, without reading it.
 type StaticSub struct {
 	staticPath string
 	cache      *cache.Cache
@@ -26,27 +30,51 @@ func NewStaticSub(staticPath string) *StaticSub {
 
 func (ss *StaticSub) Serve(q *server.Query) {
 	req := q.Req
-	if req.Method != "GET" {
+	if req.Method != "GET" && req.Method != "HEAD" {
 		q.ContinueAndWrite(http.NewResponse404(req))
 		return
 	}
-	p := req.URL.Path
+	p, ok := server.CleanPath(req.URL.Path)
+	if !ok {
+		// Refuse paths that climb out of the static directory
+		q.ContinueAndWrite(http.NewResponse400(req))
+		return
+	}
+	p = p[1:]
 	if len(p) == 0 {
 		p = "index.html"
-	} else if p[0] == '/' {
-		p = p[1:]
 	}
 	full := path.Clean(path.Join(ss.staticPath, p))
-	buf, mimetype, err := ss.cache.Get(full)
+	var (
+		buf      []byte
+		size     int64
+		mimetype string
+		mtime    int64
+		err      error
+	)
+	if req.Method == "HEAD" {
+		size, mimetype, mtime, err = ss.cache.Stat(full)
+	} else {
+		buf, mimetype, mtime, err = ss.cache.GetWithModTime(full)
+		size = int64(len(buf))
+	}
 	if err != nil {
 		q.ContinueAndWrite(http.NewResponse404(req))
 		return
 	}
-	resp := http.NewResponseWithBytes(req, buf)
+	etag := fmt.Sprintf("\"%x-%x\"", size, mtime)
+	if http.IfNoneMatch(req.Header, etag) {
+		q.ContinueAndWrite(http.NewResponse304(req, etag))
+		return
+	}
+	// Responses carry a Content-Length, so that HEAD and GET agree
+	resp := http.NewResponse200Bytes(req, buf)
+	resp.ContentLength = size
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Etag", etag)
 	if mimetype != "" {
-		if resp.Header == nil {
-			resp.Header = make(http.Header)
-		}
 		resp.Header.Set("Content-Type", mimetype)
 	}
 	q.ContinueAndWrite(resp)
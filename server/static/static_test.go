@@ -0,0 +1,90 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package static
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	http "net/http/httputil"
+	"github.com/petar/GoHTTP/server/servertest"
+)
+
+func serve(t *testing.T, ss *StaticSub, method, path, etag string) *http.Response {
+	req, _ := http.NewRequest(method, path, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	q, rec := servertest.NewTestQuery(req)
+	ss.Serve(q)
+	resp, err := rec.Response()
+	if err != nil {
+		t.Fatalf("%s %s: read response: %s", method, path, err)
+	}
+	return resp
+}
+
+func TestStaticSub(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-test-")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	full := filepath.Join(dir, "a.txt")
+	if err = ioutil.WriteFile(full, []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	fi, _ := os.Stat(full)
+	etag := fmt.Sprintf("\"%x-%x\"", 5, fi.ModTime().UnixNano())
+
+	tests := []struct {
+		method, path, ifNoneMatch string
+		code                      int
+		body                      string
+		length                    int64 // Content-Length, or -1 if not checked
+	}{
+		{"GET", "/a.txt", "", 200, "hello", 5},
+		{"HEAD", "/a.txt", "", 200, "", 5},
+		{"GET", "/a.txt", etag, 304, "", -1},
+		{"HEAD", "/a.txt", etag, 304, "", -1},
+		{"GET", "/a.txt", "\"other\"", 200, "hello", 5},
+		{"GET", "/sub", "", 404, "", -1},
+		{"HEAD", "/sub", "", 404, "", -1},
+		{"GET", "/missing.txt", "", 404, "", -1},
+		{"POST", "/a.txt", "", 404, "", -1},
+	}
+	ss := NewStaticSub(dir)
+	for _, tt := range tests {
+		resp := serve(t, ss, tt.method, tt.path, tt.ifNoneMatch)
+		name := tt.method + " " + tt.path
+		if tt.ifNoneMatch != "" {
+			name += " If-None-Match " + tt.ifNoneMatch
+		}
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: got status %d, want %d", name, resp.StatusCode, tt.code)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != tt.body {
+			t.Errorf("%s: got body %q, want %q", name, body, tt.body)
+		}
+		if tt.length >= 0 {
+			if cl := resp.Header.Get("Content-Length"); cl != strconv.FormatInt(tt.length, 10) {
+				t.Errorf("%s: got Content-Length %q, want %d", name, cl, tt.length)
+			}
+		}
+		if tt.code == 200 || tt.code == 304 {
+			if g := resp.Header.Get("Etag"); g != etag {
+				t.Errorf("%s: got Etag %q, want %q", name, g, etag)
+			}
+		}
+	}
+}
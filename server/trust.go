@@ -0,0 +1,115 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"strings"
+	"net/http"
+)
+
+// SetTrustedProxies sets the networks of the reverse proxies that the server
+// trusts to report the addresses of clients, for queries read from now on.
+//
+// The trust model is as follows. A query whose connection does not come from
+// a trusted proxy has the peer's IP as its ClientIP, whatever its headers say.
+// Otherwise, the X-Forwarded-For header, to which each proxy on the way
+// appends the address it received the request from, is walked from right to
+// left, and the first address not belonging to a trusted proxy is the client.
+// Addresses further left were supplied by the client itself and are ignored,
+// so that a client cannot forge its IP by sending X-Forwarded-For. In the
+// absence of X-Forwarded-For, the X-Real-IP header of a trusted peer is used.
+// A nil nets trusts no proxy. The same model decides Query.ClientAddr,
+// Query.ForwardedFor and Query.ViaTrustedProxy, the key of the default
+// request rate limit, and the X-Forwarded-For and X-Forwarded-Proto headers
+// passed on by the reverse proxy.
+func (srv *Server) SetTrustedProxies(nets []net.IPNet) {
+	srv.Lock()
+	defer srv.Unlock()
+	srv.trusted = append([]net.IPNet(nil), nets...)
+}
+
+func (srv *Server) getTrustedProxies() []net.IPNet {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.trusted
+}
+
+func isTrusted(nets []net.IPNet, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP returns the IP in a hop of X-Forwarded-For, which may carry a
+// port, or "" if the hop is not an IP.
+func forwardedIP(hop string) string {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	if net.ParseIP(hop) == nil {
+		return ""
+	}
+	return hop
+}
+
+// peerIP returns the IP of peer, or its string form if it has no port.
+func peerIP(peer net.Addr) string {
+	ip, _, err := net.SplitHostPort(peer.String())
+	if err != nil {
+		return peer.String()
+	}
+	return ip
+}
+
+// trusts reports whether ip belongs to a proxy in nets, or to any peer
+// under the deprecated Config.TrustForwardedFor.
+func (srv *Server) trusts(nets []net.IPNet, ip string) bool {
+	return srv.config.TrustForwardedFor || isTrusted(nets, ip)
+}
+
+// clientIP returns the IP of the client that sent req over a connection
+// from peer, according to the trust model of SetTrustedProxies. It also
+// returns the addresses that the model vouches for: the client, followed by
+// the trusted proxies between it and peer, in X-Forwarded-For order.
+func (srv *Server) clientIP(peer net.Addr, req *http.Request) (string, []string) {
+	ip := peerIP(peer)
+	trusted := srv.getTrustedProxies()
+	if !srv.trusts(trusted, ip) {
+		return ip, nil
+	}
+	var hops []string
+	for _, v := range req.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if rip := forwardedIP(req.Header.Get("X-Real-IP")); rip != "" {
+			return rip, []string{rip}
+		}
+		return ip, nil
+	}
+	var chain []string
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := forwardedIP(hops[i])
+		if hop == "" {
+			// A garbled entry ends what can be verified
+			break
+		}
+		ip = hop
+		chain = append([]string{hop}, chain...)
+		if !srv.trusts(trusted, ip) {
+			break
+		}
+	}
+	return ip, chain
+}
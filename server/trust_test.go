@@ -0,0 +1,77 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"net/http"
+)
+
+var clientIPTests = []struct {
+	trusted []string
+	header  string
+	want    string
+	chain   string // Query.ForwardedFor, joined
+}{
+	{nil, "X-Forwarded-For: 1.2.3.4", "127.0.0.1", ""},
+	{[]string{"10.0.0.0/8"}, "X-Forwarded-For: 1.2.3.4", "127.0.0.1", ""},
+	{[]string{"127.0.0.0/8"}, "", "127.0.0.1", ""},
+	{[]string{"127.0.0.0/8"}, "X-Forwarded-For: 1.2.3.4", "1.2.3.4", "1.2.3.4"},
+	{[]string{"127.0.0.0/8"}, "X-Forwarded-For: 1.2.3.4:5678", "1.2.3.4", "1.2.3.4"},
+	{[]string{"127.0.0.0/8", "10.0.0.0/8"}, "X-Forwarded-For: 1.2.3.4, 10.0.0.2", "1.2.3.4", "1.2.3.4, 10.0.0.2"},
+	{[]string{"127.0.0.0/8"}, "X-Forwarded-For: 6.6.6.6, 1.2.3.4", "1.2.3.4", "1.2.3.4"},
+	{[]string{"127.0.0.0/8"}, "X-Forwarded-For: 1.2.3.4, bogus", "127.0.0.1", ""},
+	{[]string{"127.0.0.0/8"}, "X-Real-IP: 1.2.3.4", "1.2.3.4", "1.2.3.4"},
+	{nil, "X-Real-IP: 1.2.3.4", "127.0.0.1", ""},
+}
+
+func TestClientIP(t *testing.T) {
+	srv, addr := startTestServer(t, Config{Timeout: 5e9}, func(q *Query) {
+		resp := http.NewResponse200(q.Req)
+		resp.Header = http.Header{
+			"X-Client-Ip": {q.ClientIP()},
+			"X-Chain":     {strings.Join(q.ForwardedFor(), ", ")},
+		}
+		q.ContinueAndWrite(resp)
+	})
+	defer srv.Shutdown()
+
+	for _, tt := range clientIPTests {
+		var nets []net.IPNet
+		for _, s := range tt.trusted {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				t.Fatalf("ParseCIDR(%q): %s", s, err)
+			}
+			nets = append(nets, *n)
+		}
+		srv.SetTrustedProxies(nets)
+
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		header := ""
+		if tt.header != "" {
+			header = tt.header + "\r\n"
+		}
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n%s\r\n", header)
+		resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+		c.Close()
+		if err != nil {
+			t.Fatalf("read response: %s", err)
+		}
+		if g := resp.Header.Get("X-Client-Ip"); g != tt.want {
+			t.Errorf("trusting %v with %q: got ClientIP %q, want %q", tt.trusted, tt.header, g, tt.want)
+		}
+		if g := resp.Header.Get("X-Chain"); g != tt.chain {
+			t.Errorf("trusting %v with %q: got ForwardedFor %q, want %q", tt.trusted, tt.header, g, tt.chain)
+		}
+	}
+}
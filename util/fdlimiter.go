@@ -14,11 +14,12 @@ var ErrTimeout = errors.New("timeout")
 
 // FDLimiter helps keep track of the number of file descriptors in use.
 type FDLimiter struct {
-	limit int
-	count int
-	lk    sync.Mutex
-	ch    chan int
-	nfych chan<- int
+	limit   int
+	count   int
+	waiting int // Number of goroutines blocked waiting for an fd
+	lk      sync.Mutex
+	ch      chan int
+	nfych   chan<- int
 }
 
 // Init initializes (or resets) an FDLimiter object.
@@ -29,10 +30,25 @@ func (fdl *FDLimiter) Init(fdlim int) {
 	}
 	fdl.limit = fdlim
 	fdl.count = 0
-	fdl.ch = make(chan int)
+	fdl.waiting = 0
+	fdl.ch = make(chan int, 1)
 	fdl.lk.Unlock()
 }
 
+// SetLimit changes the maximum number of fds that can be allocated.
+// Growing the limit wakes up blocked Lock callers. Shrinking the limit
+// below the current count does not revoke any fds; it merely takes
+// effect as fds are released.
+func (fdl *FDLimiter) SetLimit(fdlim int) {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	if fdlim <= 0 {
+		panic("FDLimiter, bad limit")
+	}
+	fdl.limit = fdlim
+	fdl.wake()
+}
+
 // SetNotifyChan instructs the FDLimiter to send the current
 // number of utilized file descriptors every time that number changes.
 // Calling this method with a nil argument, removes the notify channel.
@@ -48,28 +64,84 @@ func (fdl *FDLimiter) notify() {
 	}
 }
 
+// wake signals one blocked waiter, if there is room for it.
+// The caller must hold fdl.lk. A woken waiter that obtains an fd
+// calls wake again, so that the signal propagates while room remains.
+func (fdl *FDLimiter) wake() {
+	if fdl.waiting > 0 && fdl.count < fdl.limit {
+		select {
+		case fdl.ch <- 1:
+		default:
+		}
+	}
+}
+
+// tryLock allocates an fd if one is available. The caller must hold fdl.lk.
+func (fdl *FDLimiter) tryLock() bool {
+	if fdl.count < fdl.limit {
+		fdl.count++
+		fdl.notify()
+		fdl.wake()
+		return true
+	}
+	return false
+}
+
 func (fdl *FDLimiter) LockCount() int {
 	fdl.lk.Lock()
 	defer fdl.lk.Unlock()
 	return fdl.count
 }
 
-func (fdl *FDLimiter) Limit() int { return fdl.limit }
+// Count returns the number of currently allocated fds and the limit,
+// as observed together at one instant.
+func (fdl *FDLimiter) Count() (used, limit int) {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	return fdl.count, fdl.limit
+}
+
+// InUse returns the number of currently allocated fds.
+func (fdl *FDLimiter) InUse() int { return fdl.LockCount() }
+
+// Waiting returns the number of goroutines blocked waiting for an fd.
+func (fdl *FDLimiter) Waiting() int {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	return fdl.waiting
+}
+
+func (fdl *FDLimiter) Limit() int {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	return fdl.limit
+}
+
+// TryLock allocates one fd if this can be done without violating
+// the limit, and reports whether it succeeded. It never blocks.
+func (fdl *FDLimiter) TryLock() bool {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	return fdl.tryLock()
+}
 
 // Lock blocks until it can allocate one fd without violating the limit.
 func (fdl *FDLimiter) Lock() {
-	for {
-		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
-			fdl.lk.Unlock()
-			return
-		}
+	fdl.lk.Lock()
+	for !fdl.tryLock() {
+		fdl.waiting++
 		fdl.lk.Unlock()
 		<-fdl.ch
+		fdl.lk.Lock()
+		fdl.waiting--
 	}
-	panic("FDLimiter, unreachable")
+	fdl.lk.Unlock()
+}
+
+// LockTimeout proceeds as Lock, except that it gives up and returns
+// false, if a lock cannot be obtained within ns nanoseconds.
+func (fdl *FDLimiter) LockTimeout(ns int64) bool {
+	return fdl.LockOrTimeout(ns) == nil
 }
 
 // LockOrTimeout proceeds as Lock, except that it returns an ErrTimeout
@@ -79,24 +151,27 @@ func (fdl *FDLimiter) LockOrTimeout(ns int64) error {
 	for {
 		// Try to get an fd
 		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
+		if fdl.tryLock() {
 			fdl.lk.Unlock()
 			return nil
 		}
-		fdl.lk.Unlock()
 
 		// Or, wait for an fd or timeout
 		if waitsofar >= ns {
+			fdl.lk.Unlock()
 			return ErrTimeout
 		}
+		fdl.waiting++
+		fdl.lk.Unlock()
 		t0 := time.Now().UnixNano()
 		alrm := alarmOnce(ns - waitsofar)
 		select {
 		case <-alrm:
 		case <-fdl.ch:
 		}
+		fdl.lk.Lock()
+		fdl.waiting--
+		fdl.lk.Unlock()
 		waitsofar += time.Now().UnixNano() - t0
 	}
 	panic("FDLimiter, unreachable")
@@ -105,19 +180,24 @@ func (fdl *FDLimiter) LockOrTimeout(ns int64) error {
 func (fdl *FDLimiter) LockOrChan(ch <-chan interface{}) (msg interface{}, err error) {
 	for {
 		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
+		if fdl.tryLock() {
 			fdl.lk.Unlock()
 			return nil, nil
 		}
+		fdl.waiting++
 		fdl.lk.Unlock()
 
 		select {
 		case msg = <-ch:
+			fdl.lk.Lock()
+			fdl.waiting--
+			fdl.lk.Unlock()
 			return msg, ErrTimeout
 		case <-fdl.ch:
 		}
+		fdl.lk.Lock()
+		fdl.waiting--
+		fdl.lk.Unlock()
 	}
 	panic("FDLimiter, unreachable")
 }
@@ -130,15 +210,13 @@ func (fdl *FDLimiter) Unlock() {
 	}
 	fdl.count--
 	fdl.notify()
-	if fdl.count == fdl.limit-1 {
-		fdl.ch <- 1
-	}
+	fdl.wake()
 	fdl.lk.Unlock()
 }
 
 // alarmOnce sends "1" to the returned chan after ns nanoseconds
 func alarmOnce(ns int64) <-chan int {
-	backchan := make(chan int)
+	backchan := make(chan int, 1)
 	go func() {
 		time.Sleep(time.Duration(ns))
 		backchan <- 1
@@ -5,6 +5,7 @@
 package util
 
 import (
+	"errors"
 	"io"
 	"net"
 )
@@ -49,3 +50,16 @@ func (t *runOnCloseConn) Close() error {
 	}
 	return err
 }
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// CloseWrite shuts down the writing side of the underlying connection,
+// if it supports doing so.
+func (t *runOnCloseConn) CloseWrite() error {
+	if cw, ok := t.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("util: connection cannot be half-closed")
+}